@@ -0,0 +1,260 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/PawBer/FrogBoard/internal/models"
+	"github.com/PawBer/FrogBoard/pkg/filestorage"
+)
+
+const usage = `Usage: frogboard [command]
+
+Without a command the board server is started.
+
+Commands:
+  thumbnails regenerate    Render every stored thumbnail again with the current [filestorage.thumbnails] settings
+  storage verify           Rehash every stored file and report missing, corrupt and untracked files and thumbnails
+      -regenerate-thumbnails    Render the missing thumbnails of intact files
+      -quarantine               Move corrupt files out of the store
+  storage migrate          Copy every file from the [filestorage.previous] store to the [filestorage] store,
+                           files that were already copied are skipped
+  storage measure          Record the size of the files uploaded before sizes were recorded
+  storage generate-key     Print a new key for [filestorage.encryption]
+  storage rotate-key       Encrypt every stored file with the current [filestorage.encryption] key,
+                           files stored in plain form or with a previous key are encrypted again
+`
+
+func runCommand(config Config, infoLog *log.Logger, args []string) {
+	switch {
+	case len(args) == 2 && args[0] == "thumbnails" && args[1] == "regenerate":
+		regenerateThumbnails(config, infoLog)
+	case len(args) >= 2 && args[0] == "storage" && args[1] == "verify":
+		verifyStorage(config, infoLog, args[2:])
+	case len(args) == 2 && args[0] == "storage" && args[1] == "migrate":
+		migrateStorage(config, infoLog)
+	case len(args) == 2 && args[0] == "storage" && args[1] == "measure":
+		measureStorage(config, infoLog)
+	case len(args) == 2 && args[0] == "storage" && args[1] == "generate-key":
+		generateEncryptionKey()
+	case len(args) == 2 && args[0] == "storage" && args[1] == "rotate-key":
+		rotateEncryptionKey(config, infoLog)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func regenerateThumbnails(config Config, infoLog *log.Logger) {
+	db := openDatabase(config, infoLog)
+	fileStore := openFileStore(config)
+
+	fileInfoModel := &models.FileInfoModel{DbConn: db, FileStore: fileStore}
+
+	fileIds, err := fileInfoModel.GetFileIDs()
+	if err != nil {
+		log.Fatalf("Error getting files: %s", err.Error())
+	}
+
+	var failed int
+	for i, fileId := range fileIds {
+		err := fileStore.RegenerateThumbnail(fileId)
+		if err != nil {
+			failed++
+			infoLog.Printf("[%d/%d] %s failed: %s", i+1, len(fileIds), fileId, err.Error())
+			continue
+		}
+
+		infoLog.Printf("[%d/%d] %s", i+1, len(fileIds), fileId)
+	}
+
+	infoLog.Printf("Regenerated thumbnails for %d files, %d failed", len(fileIds)-failed, failed)
+}
+
+func verifyStorage(config Config, infoLog *log.Logger, args []string) {
+	flags := flag.NewFlagSet("storage verify", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	regenerate := flags.Bool("regenerate-thumbnails", false, "")
+	quarantine := flags.Bool("quarantine", false, "")
+	flags.Parse(args)
+
+	db := openDatabase(config, infoLog)
+	fileStore := openFileStore(config)
+
+	fileInfoModel := &models.FileInfoModel{DbConn: db, FileStore: fileStore}
+
+	report, err := fileInfoModel.VerifyStorage(filestorage.VerifyOptions{
+		RegenerateThumbnails: *regenerate,
+		Quarantine:           *quarantine,
+		Progress: func(done, total int, key string) {
+			infoLog.Printf("[%d/%d] %s", done+1, total, key)
+		},
+	})
+	if err != nil {
+		log.Fatalf("Error verifying storage: %s", err.Error())
+	}
+
+	sections := []struct {
+		title string
+		keys  []string
+	}{
+		{"Missing files", report.Missing},
+		{"Corrupt files", report.Corrupt},
+		{"Untracked files", report.Untracked},
+		{"Missing thumbnails", report.MissingThumbnails},
+		{"Videos without thumbnails, ffmpeg isn't installed", report.UnthumbnailedVideos},
+		{"Thumbnails without a file", report.UntrackedThumbnails},
+		{"Regenerated thumbnails", report.RegeneratedThumbnails},
+		{"Quarantined files", report.Quarantined},
+		{"Failures", report.Failures},
+	}
+
+	for _, section := range sections {
+		if len(section.keys) == 0 {
+			continue
+		}
+
+		fmt.Printf("%s (%d):\n", section.title, len(section.keys))
+		for _, key := range section.keys {
+			fmt.Printf("  %s\n", key)
+		}
+	}
+
+	infoLog.Printf("Checked %d files", report.Checked)
+
+	if !report.OK() || len(report.Failures) != 0 {
+		os.Exit(1)
+	}
+}
+
+func migrateStorage(config Config, infoLog *log.Logger) {
+	if config.FileStorage.Previous == nil {
+		log.Fatal("There is no [filestorage.previous] store to migrate from")
+	}
+
+	db := openDatabase(config, infoLog)
+	source := openStore(*config.FileStorage.Previous, config.FileStorage.Thumbnails)
+	destination := openStore(config.FileStorage, config.FileStorage.Thumbnails)
+
+	fileInfoModel := &models.FileInfoModel{DbConn: db, FileStore: destination}
+
+	fileIds, err := fileInfoModel.GetFileIDs()
+	if err != nil {
+		log.Fatalf("Error getting files: %s", err.Error())
+	}
+
+	var copied, failed int
+	for i, fileId := range fileIds {
+		wasCopied, err := filestorage.CopyFile(source, destination, fileId)
+		if err != nil {
+			failed++
+			infoLog.Printf("[%d/%d] %s failed: %s", i+1, len(fileIds), fileId, err.Error())
+			continue
+		}
+
+		if wasCopied {
+			copied++
+			infoLog.Printf("[%d/%d] %s copied", i+1, len(fileIds), fileId)
+		} else {
+			infoLog.Printf("[%d/%d] %s already copied", i+1, len(fileIds), fileId)
+		}
+	}
+
+	infoLog.Printf("Copied %d files, %d were already copied, %d failed", copied, len(fileIds)-copied-failed, failed)
+
+	if failed != 0 {
+		os.Exit(1)
+	}
+}
+
+func measureStorage(config Config, infoLog *log.Logger) {
+	db := openDatabase(config, infoLog)
+	fileStore := openFileStore(config)
+
+	fileInfoModel := &models.FileInfoModel{DbConn: db, FileStore: fileStore}
+
+	fileIds, err := fileInfoModel.GetUnmeasuredFileIDs()
+	if err != nil {
+		log.Fatalf("Error getting files: %s", err.Error())
+	}
+
+	var total int64
+	var failed int
+	for i, fileId := range fileIds {
+		size, err := fileInfoModel.Measure(fileId)
+		if err != nil {
+			failed++
+			infoLog.Printf("[%d/%d] %s failed: %s", i+1, len(fileIds), fileId, err.Error())
+			continue
+		}
+
+		total += size
+		infoLog.Printf("[%d/%d] %s %s", i+1, len(fileIds), fileId, models.FormatSize(size))
+	}
+
+	infoLog.Printf("Measured %d files taking up %s, %d failed", len(fileIds)-failed, models.FormatSize(total), failed)
+
+	if failed != 0 {
+		os.Exit(1)
+	}
+}
+
+func generateEncryptionKey() {
+	key, err := filestorage.GenerateEncryptionKey()
+	if err != nil {
+		log.Fatalf("Error generating key: %s", err.Error())
+	}
+
+	fmt.Println(key)
+}
+
+func rotateEncryptionKey(config Config, infoLog *log.Logger) {
+	fileStore, ok := openStore(config.FileStorage, config.FileStorage.Thumbnails).(*filestorage.EncryptedFileStore)
+	if !ok {
+		log.Fatal("There is no [filestorage.encryption] set up")
+	}
+
+	type storedFile struct {
+		key       string
+		thumbnail bool
+	}
+
+	var files []storedFile
+	err := fileStore.Walk(func(key string, thumbnail bool) error {
+		files = append(files, storedFile{key, thumbnail})
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Error listing files: %s", err.Error())
+	}
+
+	var rotated, failed int
+	for i, file := range files {
+		name := file.key
+		if file.thumbnail {
+			name += " (thumbnail)"
+		}
+
+		wasRotated, err := fileStore.RotateKey(file.key, file.thumbnail)
+		if err != nil {
+			failed++
+			infoLog.Printf("[%d/%d] %s failed: %s", i+1, len(files), name, err.Error())
+			continue
+		}
+
+		if wasRotated {
+			rotated++
+			infoLog.Printf("[%d/%d] %s encrypted", i+1, len(files), name)
+		} else {
+			infoLog.Printf("[%d/%d] %s already uses the current key", i+1, len(files), name)
+		}
+	}
+
+	infoLog.Printf("Encrypted %d files, %d already used the current key, %d failed", rotated, len(files)-rotated-failed, failed)
+
+	if failed != 0 {
+		os.Exit(1)
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"crypto/rand"
 	"database/sql"
 	"embed"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
@@ -30,6 +32,15 @@ func GetFirstRun(templates embed.FS) http.HandlerFunc {
 	}
 }
 
+func generateTripcodeSalt() (string, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(salt), nil
+}
+
 func PostFirstRun(w http.ResponseWriter, r *http.Request) {
 	formModel := struct {
 		Port             string `form:"port"`
@@ -111,6 +122,12 @@ func PostFirstRun(w http.ResponseWriter, r *http.Request) {
 
 	w.Write([]byte("Everything is correct. Writing configuration\n"))
 
+	tripcodeSalt, err := generateTripcodeSalt()
+	if err != nil {
+		w.Write([]byte(fmt.Sprintf("Error generating tripcode salt: %s\n", err.Error())))
+		return
+	}
+
 	config := Config{
 		Port: formModel.Port,
 		Db: DbConfig{
@@ -130,6 +147,9 @@ func PostFirstRun(w http.ResponseWriter, r *http.Request) {
 				Path string
 			}{Path: formModel.FileStoragePath},
 		},
+		Tripcodes: TripcodeConfig{
+			Salt: tripcodeSalt,
+		},
 	}
 
 	err = os.MkdirAll("/var/frogboard", 0744)
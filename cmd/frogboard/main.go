@@ -41,6 +41,8 @@ type Config struct {
 	Db          DbConfig
 	Redis       RedisConfig
 	FileStorage FileStorage
+	Moderation  ModerationConfig
+	Tripcodes   TripcodeConfig
 }
 
 type DbConfig struct {
@@ -56,11 +58,41 @@ type RedisConfig struct {
 	Port     string
 }
 
+type ModerationConfig struct {
+	// ImageMatchDistance is how many of the 64 bits of the perceptual hashes
+	// may differ for an upload to match a banned image, 10 when unset.
+	ImageMatchDistance int
+	// ImageAutoBan and FileAutoBan ban posters of blocklisted images and
+	// banned files for this long, 0 only rejects the upload.
+	ImageAutoBan time.Duration
+	FileAutoBan  time.Duration
+}
+
+type TripcodeConfig struct {
+	// Salt makes secure tripcodes unique to the server. Changing it changes
+	// every secure tripcode, they're turned off when it's empty.
+	Salt string
+}
+
 type FileStorage struct {
 	Type string
 	Fs   struct {
 		Path string
 	}
+	S3         filestorage.S3Config
+	Thumbnails filestorage.ThumbnailPolicy
+	// Encryption, when set, encrypts the files before they're stored.
+	Encryption *filestorage.EncryptionConfig
+	// Files no post uses anymore are removed once they have been unused
+	// for GracePeriod, checking every Interval.
+	GarbageCollection struct {
+		Interval    time.Duration
+		GracePeriod time.Duration
+	}
+	// Previous is the store files are being migrated away from. Files the
+	// store doesn't have yet are read from it until "frogboard storage
+	// migrate" has copied them.
+	Previous *FileStorage
 }
 
 func main() {
@@ -92,31 +124,16 @@ func main() {
 		log.Fatalf("Error parsing config: %s", err.Error())
 	}
 
-	connStr := fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=disable", config.Db.Hostname, config.Db.Port, config.Db.Username, config.Db.TableName, config.Db.Password)
-	dbConn, _ := sql.Open("postgres", connStr)
-	err = dbConn.Ping()
-	if err != nil {
-		log.Fatalf("Error connecting to db: %s", err.Error())
-	}
-
-	driver, _ := postgres.WithInstance(dbConn, &postgres.Config{})
-	source, _ := iofs.New(migrations, "migrations")
-
-	migrator, _ := migrate.NewWithInstance("iofs", source, "postgres", driver)
-	infoLog.Output(2, "Starting migration")
-	err = migrator.Up()
-	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
-		log.Fatalf("Error migrating: %s", err.Error())
+	if len(os.Args) > 1 {
+		runCommand(config, infoLog, os.Args[1:])
+		return
 	}
 
-	db := goqu.Dialect("postgres").DB(dbConn)
+	db := openDatabase(config, infoLog)
 
 	formDecoder := form.NewDecoder()
 
-	var fileStore filestorage.FileStore
-	if config.FileStorage.Type == "fs" {
-		fileStore = filestorage.NewFileSystemStore(config.FileStorage.Fs.Path)
-	}
+	fileStore := openFileStore(config)
 
 	redisConnStr := fmt.Sprintf("%s:%s", config.Redis.Hostname, config.Redis.Port)
 	pool := &redis.Pool{
@@ -131,7 +148,19 @@ func main() {
 	sessionStore.Store = redisstore.New(pool)
 
 	boardModel := &models.BoardModel{DbConn: db}
-	fileInfoModel := &models.FileInfoModel{DbConn: db, FileStore: fileStore}
+	imageMatchDistance := config.Moderation.ImageMatchDistance
+	if imageMatchDistance <= 0 {
+		imageMatchDistance = 10
+	}
+
+	bannedFileModel := &models.BannedFileModel{DbConn: db, FileStore: fileStore}
+	bannedImageModel := &models.BannedImageModel{DbConn: db, MaxDistance: imageMatchDistance}
+	fileInfoModel := &models.FileInfoModel{
+		DbConn:           db,
+		FileStore:        fileStore,
+		BannedFileModel:  bannedFileModel,
+		BannedImageModel: bannedImageModel,
+	}
 	citationModel := &models.CitationModel{DbConn: db}
 
 	userModel := &models.UserModel{
@@ -142,18 +171,24 @@ func main() {
 		DbConn: db,
 	}
 
+	posterIdModel := &models.PosterIDModel{DbConn: db}
+
 	replyModel := &models.ReplyModel{
 		DbConn:        db,
 		FileInfoModel: fileInfoModel,
 		CitationModel: citationModel,
+		PosterIDModel: posterIdModel,
 	}
 	threadModel := &models.ThreadModel{
 		DbConn:        db,
 		FileInfoModel: fileInfoModel,
 		CitationModel: citationModel,
 		ReplyModel:    replyModel,
+		PosterIDModel: posterIdModel,
 	}
 
+	go collectGarbage(fileInfoModel, config.FileStorage, infoLog, errorLog)
+
 	app := handlers.Application{
 		InfoLog:       infoLog,
 		ErrorLog:      errorLog,
@@ -168,7 +203,15 @@ func main() {
 		Public:        public,
 		FormDecoder:   formDecoder,
 		FileStore:     fileStore,
+		Thumbnails:    config.FileStorage.Thumbnails.WithDefaults(),
 		Sessions:      sessionStore,
+
+		BannedFileModel:  bannedFileModel,
+		BannedImageModel: bannedImageModel,
+		FileAutoBan:      config.Moderation.FileAutoBan,
+		ImageAutoBan:     config.Moderation.ImageAutoBan,
+
+		TripcodeSalt: config.Tripcodes.Salt,
 	}
 
 	var port string
@@ -183,3 +226,84 @@ func main() {
 	listenAddress := fmt.Sprintf(":%s", port)
 	log.Fatal(http.ListenAndServe(listenAddress, app.GetRouter()))
 }
+
+func openDatabase(config Config, infoLog *log.Logger) *goqu.Database {
+	connStr := fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=disable", config.Db.Hostname, config.Db.Port, config.Db.Username, config.Db.TableName, config.Db.Password)
+	dbConn, _ := sql.Open("postgres", connStr)
+	err := dbConn.Ping()
+	if err != nil {
+		log.Fatalf("Error connecting to db: %s", err.Error())
+	}
+
+	driver, _ := postgres.WithInstance(dbConn, &postgres.Config{})
+	source, _ := iofs.New(migrations, "migrations")
+
+	migrator, _ := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	infoLog.Output(2, "Starting migration")
+	err = migrator.Up()
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		log.Fatalf("Error migrating: %s", err.Error())
+	}
+
+	return goqu.Dialect("postgres").DB(dbConn)
+}
+
+func openStore(storage FileStorage, thumbnails filestorage.ThumbnailPolicy) filestorage.FileStore {
+	var fileStore filestorage.FileStore
+	var err error
+
+	if storage.Type == "fs" {
+		fileStore = filestorage.NewFileSystemStore(storage.Fs.Path, thumbnails)
+	} else if storage.Type == "s3" {
+		fileStore, err = filestorage.NewS3Store(storage.S3, thumbnails)
+		if err != nil {
+			log.Fatalf("Error connecting to s3 storage: %s", err.Error())
+		}
+	} else {
+		log.Fatalf("Unknown file storage type %q", storage.Type)
+	}
+
+	if storage.Encryption != nil {
+		fileStore, err = filestorage.NewEncryptedStore(fileStore, thumbnails, *storage.Encryption)
+		if err != nil {
+			log.Fatalf("Error setting up file encryption: %s", err.Error())
+		}
+	}
+
+	return fileStore
+}
+
+func openFileStore(config Config) filestorage.FileStore {
+	fileStore := openStore(config.FileStorage, config.FileStorage.Thumbnails)
+
+	if config.FileStorage.Previous != nil {
+		previous := openStore(*config.FileStorage.Previous, config.FileStorage.Thumbnails)
+		fileStore = filestorage.NewFallbackStore(fileStore, previous)
+	}
+
+	return fileStore
+}
+
+func collectGarbage(fileInfoModel *models.FileInfoModel, config FileStorage, infoLog, errorLog *log.Logger) {
+	interval := config.GarbageCollection.Interval
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+
+	gracePeriod := config.GarbageCollection.GracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = time.Hour
+	}
+
+	for range time.Tick(interval) {
+		removed, err := fileInfoModel.CollectGarbage(gracePeriod)
+		if err != nil {
+			errorLog.Printf("Error collecting unused files: %s", err.Error())
+			continue
+		}
+
+		if removed > 0 {
+			infoLog.Printf("Removed %d unused files", removed)
+		}
+	}
+}
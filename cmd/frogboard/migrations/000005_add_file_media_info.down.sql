@@ -0,0 +1,5 @@
+BEGIN;
+ALTER TABLE public.file_infos DROP COLUMN IF EXISTS duration_ms;
+ALTER TABLE public.file_infos DROP COLUMN IF EXISTS height;
+ALTER TABLE public.file_infos DROP COLUMN IF EXISTS width;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,5 @@
+BEGIN;
+ALTER TABLE public.file_infos ADD COLUMN IF NOT EXISTS width INT NOT NULL DEFAULT 0;
+ALTER TABLE public.file_infos ADD COLUMN IF NOT EXISTS height INT NOT NULL DEFAULT 0;
+ALTER TABLE public.file_infos ADD COLUMN IF NOT EXISTS duration_ms BIGINT NOT NULL DEFAULT 0;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,4 @@
+BEGIN;
+ALTER TABLE public.boards DROP COLUMN IF EXISTS reencode_images;
+ALTER TABLE public.boards DROP COLUMN IF EXISTS strip_metadata;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,4 @@
+BEGIN;
+ALTER TABLE public.boards ADD COLUMN IF NOT EXISTS strip_metadata BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE public.boards ADD COLUMN IF NOT EXISTS reencode_images BOOLEAN NOT NULL DEFAULT FALSE;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,6 @@
+BEGIN;
+ALTER TABLE public.boards DROP COLUMN IF EXISTS op_requires_image;
+ALTER TABLE public.boards DROP COLUMN IF EXISTS max_files;
+ALTER TABLE public.boards DROP COLUMN IF EXISTS max_file_size;
+ALTER TABLE public.boards DROP COLUMN IF EXISTS allowed_content_types;
+COMMIT;
\ No newline at end of file
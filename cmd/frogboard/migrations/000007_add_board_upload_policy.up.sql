@@ -0,0 +1,8 @@
+BEGIN;
+-- Existing boards get the defaults below: any content type, at most 5 files
+-- per post and 32 MiB per file. They can be changed in the board edit form.
+ALTER TABLE public.boards ADD COLUMN IF NOT EXISTS allowed_content_types TEXT NOT NULL DEFAULT '';
+ALTER TABLE public.boards ADD COLUMN IF NOT EXISTS max_file_size BIGINT NOT NULL DEFAULT 33554432;
+ALTER TABLE public.boards ADD COLUMN IF NOT EXISTS max_files INT NOT NULL DEFAULT 5;
+ALTER TABLE public.boards ADD COLUMN IF NOT EXISTS op_requires_image BOOLEAN NOT NULL DEFAULT FALSE;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,6 @@
+BEGIN;
+DROP INDEX IF EXISTS public.post_files_post_idx;
+DROP INDEX IF EXISTS public.file_infos_unreferenced_idx;
+ALTER TABLE public.file_infos DROP COLUMN IF EXISTS unreferenced_at;
+ALTER TABLE public.file_infos DROP COLUMN IF EXISTS ref_count;
+COMMIT;
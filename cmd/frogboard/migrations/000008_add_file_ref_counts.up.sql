@@ -0,0 +1,12 @@
+BEGIN;
+ALTER TABLE public.file_infos ADD COLUMN IF NOT EXISTS ref_count INT NOT NULL DEFAULT 0;
+ALTER TABLE public.file_infos ADD COLUMN IF NOT EXISTS unreferenced_at TIMESTAMP;
+
+UPDATE public.file_infos SET ref_count = (
+    SELECT COUNT(*) FROM public.post_files WHERE post_files.file_id = file_infos.id
+);
+UPDATE public.file_infos SET unreferenced_at = NOW() WHERE ref_count = 0;
+
+CREATE INDEX IF NOT EXISTS file_infos_unreferenced_idx ON public.file_infos (unreferenced_at) WHERE ref_count = 0;
+CREATE INDEX IF NOT EXISTS post_files_post_idx ON public.post_files (board_id, post_id);
+COMMIT;
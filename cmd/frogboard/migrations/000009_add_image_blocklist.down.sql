@@ -0,0 +1,4 @@
+BEGIN;
+DROP TABLE IF EXISTS public.banned_images;
+ALTER TABLE public.file_infos DROP COLUMN IF EXISTS phash;
+COMMIT;
@@ -0,0 +1,11 @@
+BEGIN;
+ALTER TABLE public.file_infos ADD COLUMN IF NOT EXISTS phash BIGINT;
+
+CREATE TABLE IF NOT EXISTS public.banned_images (
+    id SERIAL NOT NULL PRIMARY KEY,
+    file_id VARCHAR(255) NOT NULL,
+    phash BIGINT NOT NULL,
+    reason VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+COMMIT;
@@ -0,0 +1,3 @@
+BEGIN;
+DROP TABLE IF EXISTS public.banned_files;
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+CREATE TABLE IF NOT EXISTS public.banned_files (
+    file_id VARCHAR(255) NOT NULL PRIMARY KEY,
+    reason VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+COMMIT;
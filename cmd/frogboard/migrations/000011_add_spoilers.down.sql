@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE public.post_files DROP COLUMN IF EXISTS spoiler;
+COMMIT;
\ No newline at end of file
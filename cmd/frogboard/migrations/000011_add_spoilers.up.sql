@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE public.post_files ADD COLUMN IF NOT EXISTS spoiler BOOLEAN NOT NULL DEFAULT FALSE;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,5 @@
+BEGIN;
+ALTER TABLE public.boards DROP COLUMN IF EXISTS quota_action;
+ALTER TABLE public.boards DROP COLUMN IF EXISTS storage_quota;
+ALTER TABLE public.file_infos DROP COLUMN IF EXISTS size;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,5 @@
+BEGIN;
+ALTER TABLE public.file_infos ADD COLUMN IF NOT EXISTS size BIGINT;
+ALTER TABLE public.boards ADD COLUMN IF NOT EXISTS storage_quota BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE public.boards ADD COLUMN IF NOT EXISTS quota_action VARCHAR(16) NOT NULL DEFAULT 'reject';
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,7 @@
+BEGIN;
+ALTER TABLE public.boards DROP COLUMN IF EXISTS force_anonymous;
+ALTER TABLE public.replies DROP COLUMN IF EXISTS tripcode;
+ALTER TABLE public.replies DROP COLUMN IF EXISTS name;
+ALTER TABLE public.threads DROP COLUMN IF EXISTS tripcode;
+ALTER TABLE public.threads DROP COLUMN IF EXISTS name;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,7 @@
+BEGIN;
+ALTER TABLE public.threads ADD COLUMN IF NOT EXISTS name VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE public.threads ADD COLUMN IF NOT EXISTS tripcode VARCHAR(16) NOT NULL DEFAULT '';
+ALTER TABLE public.replies ADD COLUMN IF NOT EXISTS name VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE public.replies ADD COLUMN IF NOT EXISTS tripcode VARCHAR(16) NOT NULL DEFAULT '';
+ALTER TABLE public.boards ADD COLUMN IF NOT EXISTS force_anonymous BOOLEAN NOT NULL DEFAULT FALSE;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,4 @@
+BEGIN;
+DROP TABLE IF EXISTS public.poster_id_salts;
+ALTER TABLE public.boards DROP COLUMN IF EXISTS poster_ids;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,7 @@
+BEGIN;
+ALTER TABLE public.boards ADD COLUMN IF NOT EXISTS poster_ids BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE TABLE IF NOT EXISTS public.poster_id_salts (
+    day DATE NOT NULL PRIMARY KEY,
+    salt BYTEA NOT NULL
+);
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE public.replies DROP COLUMN IF EXISTS sage;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE public.replies ADD COLUMN IF NOT EXISTS sage BOOLEAN NOT NULL DEFAULT FALSE;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,4 @@
+BEGIN;
+DROP INDEX IF EXISTS public.citations_cited_idx;
+ALTER TABLE public.citations DROP COLUMN IF EXISTS cited_board_id;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,6 @@
+BEGIN;
+ALTER TABLE public.citations ADD COLUMN IF NOT EXISTS cited_board_id VARCHAR(100);
+UPDATE public.citations SET cited_board_id = board_id WHERE cited_board_id IS NULL;
+ALTER TABLE public.citations ALTER COLUMN cited_board_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS citations_cited_idx ON public.citations (cited_board_id, cites);
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,4 @@
+BEGIN;
+ALTER TABLE public.replies DROP COLUMN IF EXISTS content_html;
+ALTER TABLE public.threads DROP COLUMN IF EXISTS content_html;
+COMMIT;
\ No newline at end of file
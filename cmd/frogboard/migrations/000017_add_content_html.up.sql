@@ -0,0 +1,4 @@
+BEGIN;
+ALTER TABLE public.threads ADD COLUMN IF NOT EXISTS content_html TEXT NOT NULL DEFAULT '';
+ALTER TABLE public.replies ADD COLUMN IF NOT EXISTS content_html TEXT NOT NULL DEFAULT '';
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,5 @@
+BEGIN;
+ALTER TABLE public.threads DROP COLUMN IF EXISTS locked;
+ALTER TABLE public.threads DROP COLUMN IF EXISTS sticky;
+ALTER TABLE public.threads DROP COLUMN IF EXISTS cyclical;
+COMMIT;
\ No newline at end of file
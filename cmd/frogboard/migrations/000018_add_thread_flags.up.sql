@@ -0,0 +1,5 @@
+BEGIN;
+ALTER TABLE public.threads ADD COLUMN IF NOT EXISTS locked BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE public.threads ADD COLUMN IF NOT EXISTS sticky BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE public.threads ADD COLUMN IF NOT EXISTS cyclical BOOLEAN NOT NULL DEFAULT FALSE;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,5 @@
+BEGIN;
+ALTER TABLE public.threads DROP COLUMN IF EXISTS archived_at;
+ALTER TABLE public.boards DROP COLUMN IF EXISTS prune_action;
+ALTER TABLE public.boards DROP COLUMN IF EXISTS max_pages;
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,5 @@
+BEGIN;
+ALTER TABLE public.boards ADD COLUMN IF NOT EXISTS max_pages INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE public.boards ADD COLUMN IF NOT EXISTS prune_action VARCHAR(16) NOT NULL DEFAULT 'delete';
+ALTER TABLE public.threads ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+COMMIT;
\ No newline at end of file
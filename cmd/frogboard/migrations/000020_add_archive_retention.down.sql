@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE public.boards DROP COLUMN IF EXISTS archive_days;
+COMMIT;
\ No newline at end of file
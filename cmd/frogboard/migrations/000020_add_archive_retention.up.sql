@@ -0,0 +1,3 @@
+BEGIN;
+ALTER TABLE public.boards ADD COLUMN IF NOT EXISTS archive_days INTEGER NOT NULL DEFAULT 0;
+COMMIT;
\ No newline at end of file
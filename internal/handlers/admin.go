@@ -43,6 +43,12 @@ func (app *Application) GetAdmin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	storageUsage, err := app.FileInfoModel.StorageUsage()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
 	templateData, err := app.getTemplateData(r)
 	if err != nil {
 		app.serverError(w, err)
@@ -54,6 +60,7 @@ func (app *Application) GetAdmin(w http.ResponseWriter, r *http.Request) {
 	templateData["LatestThreads"] = latestThreads
 	templateData["LatestReplies"] = latestReplies
 	templateData["LatestFiles"] = latestFiles
+	templateData["StorageUsage"] = storageUsage
 
 	err = tmpl.ExecuteTemplate(w, "base", &templateData)
 	if err != nil {
@@ -22,15 +22,72 @@ func (app *Application) GetPostJson(w http.ResponseWriter, r *http.Request) {
 			http.NotFound(w, r)
 			return
 		}
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
 
 		reply.Content = string(reply.FormatedContent())
 
 		json.NewEncoder(w).Encode(&reply)
 		return
 	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// The preview puts the content in the page as is, so it has to be the
+	// escaped and formatted content like for replies.
+	thread.Content = string(thread.FormatedContent())
+
 	json.NewEncoder(w).Encode(&thread)
 }
 
+// GetPostHtml renders a post like it's shown in its thread, for previews of
+// posts that aren't on the page.
+func (app *Application) GetPostHtml(w http.ResponseWriter, r *http.Request) {
+	boardId := chi.URLParam(r, "boardId")
+	postIdStr := chi.URLParam(r, "postId")
+	postId, _ := strconv.ParseUint(postIdStr, 10, 32)
+
+	var post any
+
+	thread, err := app.ThreadModel.Get(boardId, uint(postId))
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		reply, err := app.ReplyModel.Get(boardId, uint(postId))
+		if err != nil && errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		post = reply
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	} else {
+		post = thread
+	}
+
+	tmpl, err := app.createTemplate(nil, r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err = tmpl.ExecuteTemplate(w, "post", post)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
 func (app *Application) DeletePost(w http.ResponseWriter, r *http.Request) {
 	boardId := chi.URLParam(r, "boardId")
 	postIdStr := chi.URLParam(r, "postId")
@@ -1,9 +1,10 @@
 package handlers
 
 import (
-	"embed"
+	"io/fs"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/PawBer/FrogBoard/internal/models"
 	"github.com/PawBer/FrogBoard/pkg/filestorage"
@@ -23,11 +24,26 @@ type Application struct {
 	CitationModel *models.CitationModel
 	UserModel     *models.UserModel
 	BanModel      *models.BanModel
-	Templates     embed.FS
-	Public        embed.FS
+	Templates     fs.FS
+	Public        fs.FS
 	FormDecoder   *form.Decoder
 	FileStore     filestorage.FileStore
+	Thumbnails    filestorage.ThumbnailPolicy
 	Sessions      *scs.SessionManager
+
+	// BannedFileModel and BannedImageModel hold the banned files and the
+	// image blocklist, their posters are banned for FileAutoBan and
+	// ImageAutoBan when they're set.
+	BannedFileModel  *models.BannedFileModel
+	BannedImageModel *models.BannedImageModel
+	FileAutoBan      time.Duration
+	ImageAutoBan     time.Duration
+
+	// TripcodeSalt keeps secure tripcodes from being guessed, they can't be
+	// used when it's empty.
+	TripcodeSalt string
+
+	storageScan storageScan
 }
 
 func (app *Application) GetRouter() http.Handler {
@@ -46,12 +62,16 @@ func (app *Application) GetRouter() http.Handler {
 	router.Post("/logout/", app.PostLogout)
 	router.Get("/{boardId}/", app.GetBoard)
 	router.Post("/{boardId}/", app.PostBoard)
+	router.Get("/{boardId}/archive/", app.GetArchive)
+	router.Get("/{boardId}/catalog/", app.GetCatalog)
 	router.Get("/{boardId}/{postId}/", app.GetPost)
 	router.Post("/{boardId}/{postId}/", app.PostThread)
 	router.Get("/file/{hash}/", app.GetFile)
 	router.Get("/file/{hash}/thumb/", app.GetFileThumbnail)
 	router.Mount("/captcha/", captcha.Server(240, 80))
 	router.Get("/api/post/{boardId}/{postId}/", app.GetPostJson)
+	router.Get("/api/post/{boardId}/{postId}/html/", app.GetPostHtml)
+	router.Get("/api/catalog/{boardId}/", app.GetCatalogJson)
 
 	router.Mount("/admin/", app.getAdminRouter())
 
@@ -72,8 +92,18 @@ func (app *Application) getAdminRouter() http.Handler {
 	router.Post("/board/{boardId}/delete/", app.PostBoardDelete)
 	router.Get("/{boardId}/{postId}/delete/", app.GetDelete)
 	router.Post("/{boardId}/{postId}/delete/", app.PostDelete)
+	router.Post("/{boardId}/{postId}/flags/", app.PostThreadFlag)
 	router.Get("/file/{fileId}/delete/", app.GetFileDelete)
 	router.Post("/file/{fileId}/delete/", app.PostFileDelete)
+	router.Get("/file/{fileId}/blocklist/", app.GetFileBlocklist)
+	router.Post("/file/{fileId}/blocklist/", app.PostFileBlocklist)
+	router.Get("/file/{fileId}/ban/", app.GetFileBan)
+	router.Post("/file/{fileId}/ban/", app.PostFileBan)
+	router.Get("/blocklist/", app.GetBlocklist)
+	router.Post("/blocklist/files/{fileId}/delete/", app.PostFileUnban)
+	router.Post("/blocklist/{id}/delete/", app.PostBlocklistDelete)
+	router.Get("/storage/", app.GetStorage)
+	router.Post("/storage/", app.PostStorage)
 	router.Get("/bans/", app.GetBans)
 	router.Get("/bans/create/", app.GetBanCreate)
 	router.Post("/bans/create/", app.PostBanCreate)
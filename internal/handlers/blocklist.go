@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PawBer/FrogBoard/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// rejectBannedUpload checks whether InsertFile rejected an upload for being a
+// banned file or matching the image blocklist, banning the poster when the
+// configuration asks for it. It returns the message shown to the poster, or an
+// empty string when err isn't such a rejection.
+func (app *Application) rejectBannedUpload(r *http.Request, err error) (string, error) {
+	var autoBan time.Duration
+	var reason string
+
+	var bannedFile models.BannedFileError
+	var bannedImage models.BannedImageError
+
+	switch {
+	case errors.As(err, &bannedFile):
+		autoBan = app.FileAutoBan
+		reason = fmt.Sprintf("Posted a banned file: %s", bannedFile.File.Reason)
+	case errors.As(err, &bannedImage):
+		autoBan = app.ImageAutoBan
+		reason = fmt.Sprintf("Posted a banned image: %s", bannedImage.Image.Reason)
+	default:
+		return "", nil
+	}
+
+	if autoBan <= 0 {
+		return "This file is not allowed", nil
+	}
+
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+	err = app.BanModel.BanUser(net.ParseIP(host), time.Now().UTC().Add(autoBan), reason)
+	if err != nil {
+		return "", err
+	}
+
+	return "This file is not allowed, you have been banned", nil
+}
+
+func (app *Application) GetBlocklist(w http.ResponseWriter, r *http.Request) {
+	requiredTemplates := []string{"blocklist"}
+
+	tmpl, err := app.createTemplate(requiredTemplates, r)
+	if err != nil {
+		log.Fatalf("Failed to load templates: %s", err.Error())
+	}
+
+	templateData, err := app.getTemplateData(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	images, err := app.BannedImageModel.GetAll()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	files, err := app.BannedFileModel.GetAll()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	templateData["BannedImages"] = images
+	templateData["BannedFiles"] = files
+
+	err = tmpl.ExecuteTemplate(w, "base", &templateData)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+func (app *Application) PostBlocklistDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.BannedImageModel.Delete(uint(id))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.Sessions.Put(r.Context(), "flash", "Image removed from the blocklist")
+	http.Redirect(w, r, "/admin/blocklist/", http.StatusSeeOther)
+}
+
+func (app *Application) GetFileBlocklist(w http.ResponseWriter, r *http.Request) {
+	requiredTemplates := []string{"fileblocklist"}
+
+	tmpl, err := app.createTemplate(requiredTemplates, r)
+	if err != nil {
+		log.Fatalf("Failed to load templates: %s", err.Error())
+	}
+
+	fileId := chi.URLParam(r, "fileId")
+
+	templateData, err := app.getTemplateData(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	templateData["ID"] = fileId
+
+	err = tmpl.ExecuteTemplate(w, "base", &templateData)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+func (app *Application) PostFileBlocklist(w http.ResponseWriter, r *http.Request) {
+	fileId := chi.URLParam(r, "fileId")
+
+	formModel := struct {
+		Reason string `form:"reason"`
+		Delete bool   `form:"delete"`
+	}{}
+
+	r.ParseForm()
+	err := app.FormDecoder.Decode(&formModel, r.Form)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	phash, err := app.FileInfoModel.GetPHash(fileId)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w)
+		return
+	}
+	if err != nil && errors.Is(err, models.ErrNoPHash) {
+		app.Sessions.Put(r.Context(), "flash", "Only images and videos can be added to the blocklist")
+		http.Redirect(w, r, "/admin/", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.BannedImageModel.Insert(fileId, phash, formModel.Reason)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if formModel.Delete {
+		err = app.FileInfoModel.Delete(fileId)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	app.Sessions.Put(r.Context(), "flash", "Image added to the blocklist")
+	http.Redirect(w, r, "/admin/blocklist/", http.StatusSeeOther)
+}
+
+func (app *Application) GetFileBan(w http.ResponseWriter, r *http.Request) {
+	requiredTemplates := []string{"fileban"}
+
+	tmpl, err := app.createTemplate(requiredTemplates, r)
+	if err != nil {
+		log.Fatalf("Failed to load templates: %s", err.Error())
+	}
+
+	fileId := chi.URLParam(r, "fileId")
+
+	templateData, err := app.getTemplateData(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	templateData["ID"] = fileId
+
+	err = tmpl.ExecuteTemplate(w, "base", &templateData)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+func (app *Application) PostFileBan(w http.ResponseWriter, r *http.Request) {
+	fileId := chi.URLParam(r, "fileId")
+
+	formModel := struct {
+		Reason string `form:"reason"`
+	}{}
+
+	r.ParseForm()
+	err := app.FormDecoder.Decode(&formModel, r.Form)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.BannedFileModel.Ban(fileId, formModel.Reason)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.Sessions.Put(r.Context(), "flash", "File banned succesfully")
+	http.Redirect(w, r, "/admin/blocklist/", http.StatusSeeOther)
+}
+
+func (app *Application) PostFileUnban(w http.ResponseWriter, r *http.Request) {
+	fileId := chi.URLParam(r, "fileId")
+
+	err := app.BannedFileModel.Unban(fileId)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.Sessions.Put(r.Context(), "flash", "File unbanned")
+	http.Redirect(w, r, "/admin/blocklist/", http.StatusSeeOther)
+}
@@ -1,8 +1,9 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"math"
 	"net"
@@ -10,6 +11,7 @@ import (
 	"strconv"
 
 	"github.com/PawBer/FrogBoard/internal/models"
+	"github.com/PawBer/FrogBoard/pkg/sanitize"
 	"github.com/dchest/captcha"
 	"github.com/go-chi/chi/v5"
 )
@@ -32,7 +34,7 @@ func (app *Application) GetBoard(w http.ResponseWriter, r *http.Request) {
 
 	var pageNumber uint
 
-	if threadCount <= 10 {
+	if threadCount <= models.ThreadsPerPage {
 		pageNumber = 0
 	} else if r.URL.Query().Has("page") {
 		queryPageNumber, err := strconv.Atoi(r.URL.Query().Get("page"))
@@ -46,7 +48,7 @@ func (app *Application) GetBoard(w http.ResponseWriter, r *http.Request) {
 		pageNumber = 0
 	}
 
-	pageCount := math.Ceil(float64(threadCount) / 10)
+	pageCount := math.Ceil(float64(threadCount) / models.ThreadsPerPage)
 	var pageNumbers []int
 	for i := 1; i <= int(pageCount); i++ {
 		pageNumbers = append(pageNumbers, i)
@@ -55,7 +57,7 @@ func (app *Application) GetBoard(w http.ResponseWriter, r *http.Request) {
 		pageNumbers = []int{1}
 	}
 
-	threads, err := app.ThreadModel.GetLatest(boardId, pageNumber, 10)
+	threads, err := app.ThreadModel.GetLatest(boardId, pageNumber, models.ThreadsPerPage)
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -82,6 +84,7 @@ func (app *Application) GetBoard(w http.ResponseWriter, r *http.Request) {
 	templateData["Threads"] = threads
 	templateData["PageNumbers"] = pageNumbers
 	templateData["CaptchaID"] = captchaId
+	templateData["FormName"] = app.Sessions.GetString(r.Context(), "poster-name")
 
 	if app.Sessions.Exists(r.Context(), "form-title") && app.Sessions.Exists(r.Context(), "form-content") {
 		templateData["FormTitle"] = app.Sessions.PopString(r.Context(), "form-title")
@@ -98,14 +101,38 @@ func (app *Application) GetBoard(w http.ResponseWriter, r *http.Request) {
 func (app *Application) PostBoard(w http.ResponseWriter, r *http.Request) {
 	boardId := chi.URLParam(r, "boardId")
 
+	board, err := app.BoardModel.Get(boardId)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
 	formModel := struct {
 		Title       string `form:"title"`
+		Name        string `form:"name"`
 		Content     string `form:"content"`
 		CaptchaId   string `form:"captcha-id"`
 		CaptchaCode string `form:"captcha-code"`
 	}{}
 
-	err := r.ParseMultipartForm(32 << 20)
+	maxPostSize := board.MaxPostSize()
+	if maxPostSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxPostSize)
+	}
+
+	err = r.ParseMultipartForm(32 << 20)
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		app.Sessions.Put(r.Context(), "flash", fmt.Sprintf("Posts on /%s/ can't be larger than %s", boardId, models.FormatSize(maxPostSize)))
+
+		url := fmt.Sprintf("/%s/", boardId)
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -128,11 +155,55 @@ func (app *Application) PostBoard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var fileInfos []models.FileInfo
+	// The content isn't put back in the form, it's too long to keep in the
+	// session.
+	if message := contentTooLong(formModel.Content); message != "" {
+		app.Sessions.Put(r.Context(), "flash", message)
+
+		app.Sessions.Put(r.Context(), "form-title", formModel.Title)
+
+		url := fmt.Sprintf("/%s/", boardId)
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
+
+	name, tripcode, message := app.posterName(r, board, formModel.Name)
+	if message != "" {
+		app.Sessions.Put(r.Context(), "flash", message)
+
+		app.Sessions.Put(r.Context(), "form-title", formModel.Title)
+		app.Sessions.Put(r.Context(), "form-content", formModel.Content)
+
+		url := fmt.Sprintf("/%s/", boardId)
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
 
 	files := r.MultipartForm.File["files"]
 
-	for _, fileHeader := range files {
+	message, err = checkUploads(board, files, true)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if message != "" {
+		app.Sessions.Put(r.Context(), "flash", message)
+
+		app.Sessions.Put(r.Context(), "form-title", formModel.Title)
+		app.Sessions.Put(r.Context(), "form-content", formModel.Content)
+
+		// The files break the rules of the board, the page is shown again
+		// with the message instead of redirecting so the status says so.
+		w.WriteHeader(http.StatusBadRequest)
+		app.GetBoard(w, r)
+		return
+	}
+
+	spoilers := spoilerFiles(r.MultipartForm.Value["spoiler"], len(files))
+
+	var fileInfos []models.FileInfo
+
+	for i, fileHeader := range files {
 		file, err := fileHeader.Open()
 		if err != nil {
 			app.serverError(w, err)
@@ -140,28 +211,77 @@ func (app *Application) PostBoard(w http.ResponseWriter, r *http.Request) {
 		}
 		defer file.Close()
 
-		buf, err := io.ReadAll(file)
-		if err != nil {
-			app.serverError(w, err)
+		fileInfo, err := app.FileInfoModel.InsertFile(board, fileHeader.Filename, file)
+		message, banErr := app.rejectBannedUpload(r, err)
+		if banErr != nil {
+			app.serverError(w, banErr)
 			return
 		}
+		if errors.Is(err, sanitize.ErrMalformedImage) {
+			message = fmt.Sprintf("%s isn't a valid image", fileHeader.Filename)
+		}
+		if message != "" {
+			app.Sessions.Put(r.Context(), "flash", message)
+
+			app.Sessions.Put(r.Context(), "form-title", formModel.Title)
+			app.Sessions.Put(r.Context(), "form-content", formModel.Content)
 
-		fileInfo, err := app.FileInfoModel.InsertFile(fileHeader.Filename, buf)
+			url := fmt.Sprintf("/%s/", boardId)
+			http.Redirect(w, r, url, http.StatusSeeOther)
+			return
+		}
 		if err != nil {
 			app.serverError(w, err)
 			return
 		}
 
+		fileInfo.Spoiler = spoilers[i]
 		fileInfos = append(fileInfos, fileInfo)
 	}
 
+	message, err = app.checkQuota(board, fileInfos)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if message != "" {
+		app.Sessions.Put(r.Context(), "flash", message)
+
+		app.Sessions.Put(r.Context(), "form-title", formModel.Title)
+		app.Sessions.Put(r.Context(), "form-content", formModel.Content)
+
+		url := fmt.Sprintf("/%s/", boardId)
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
+
 	host, _, _ := net.SplitHostPort(r.RemoteAddr)
-	postId, err := app.ThreadModel.Insert(boardId, formModel.Title, formModel.Content, fileInfos, host)
+	postId, err := app.ThreadModel.Insert(boardId, formModel.Title, name, tripcode, formModel.Content, fileInfos, host)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
+	if len(fileInfos) != 0 {
+		app.pruneForQuota(board, postId)
+	}
+
+	// The thread is posted either way, threads that should have been pruned
+	// will be with the next one.
+	pruned, err := app.ThreadModel.Prune(board)
+	if err != nil {
+		app.ErrorLog.Printf("Pruning the threads of /%s/: %s", boardId, err)
+	} else if pruned > 0 {
+		app.InfoLog.Printf("Pruned %d threads pushed off the last page of /%s/ (%s)", pruned, boardId, board.PruneAction)
+	}
+
+	expired, err := app.ThreadModel.ExpireArchived(board)
+	if err != nil {
+		app.ErrorLog.Printf("Deleting the expired archived threads of /%s/: %s", boardId, err)
+	} else if expired > 0 {
+		app.InfoLog.Printf("Deleted %d threads archived on /%s/ for more than %d days", expired, boardId, board.ArchiveDays)
+	}
+
 	url := fmt.Sprintf("/%s/%d/#p%d", boardId, postId, postId)
 	http.Redirect(w, r, url, http.StatusFound)
 }
@@ -218,9 +338,26 @@ func (app *Application) PostBoardEdit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	formModel := struct {
-		ID        string `form:"board-id"`
-		FullName  string `form:"full-name"`
-		BumpLimit string `form:"bump-limit"`
+		ID             string `form:"board-id"`
+		FullName       string `form:"full-name"`
+		BumpLimit      string `form:"bump-limit"`
+		StripMetadata  bool   `form:"strip-metadata"`
+		ReencodeImages bool   `form:"reencode-images"`
+
+		AllowedContentTypes string `form:"allowed-content-types"`
+		MaxFileSize         string `form:"max-file-size"`
+		MaxFiles            string `form:"max-files"`
+		OpRequiresImage     bool   `form:"op-requires-image"`
+
+		StorageQuota string `form:"storage-quota"`
+		QuotaAction  string `form:"quota-action"`
+
+		ForceAnonymous bool `form:"force-anonymous"`
+		PosterIDs      bool `form:"poster-ids"`
+
+		MaxPages    string `form:"max-pages"`
+		PruneAction string `form:"prune-action"`
+		ArchiveDays string `form:"archive-days"`
 	}{}
 
 	r.ParseForm()
@@ -236,10 +373,84 @@ func (app *Application) PostBoardEdit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The form takes the file size in KiB.
+	maxFileSize, err := strconv.ParseInt(formModel.MaxFileSize, 10, 64)
+	if err != nil || maxFileSize < 0 {
+		app.Sessions.Put(r.Context(), "flash", "The max file size has to be a number of KiB")
+
+		url := fmt.Sprintf("/admin/board/%s/edit/", formModel.ID)
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
+
+	maxFiles, err := strconv.ParseInt(formModel.MaxFiles, 10, 32)
+	if err != nil || maxFiles < 0 {
+		app.Sessions.Put(r.Context(), "flash", "The max files per post has to be a number")
+
+		url := fmt.Sprintf("/admin/board/%s/edit/", formModel.ID)
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
+
+	// The form takes the storage quota in MiB.
+	storageQuota, err := strconv.ParseInt(formModel.StorageQuota, 10, 64)
+	if err != nil || storageQuota < 0 {
+		app.Sessions.Put(r.Context(), "flash", "The storage quota has to be a number of MiB")
+
+		url := fmt.Sprintf("/admin/board/%s/edit/", formModel.ID)
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
+
+	if formModel.QuotaAction != models.QuotaReject && formModel.QuotaAction != models.QuotaPrune {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	maxPages, err := strconv.ParseUint(formModel.MaxPages, 10, 16)
+	if err != nil {
+		app.Sessions.Put(r.Context(), "flash", "The max pages has to be a number")
+
+		url := fmt.Sprintf("/admin/board/%s/edit/", formModel.ID)
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
+
+	if formModel.PruneAction != models.PruneDelete && formModel.PruneAction != models.PruneArchive {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	archiveDays, err := strconv.ParseUint(formModel.ArchiveDays, 10, 16)
+	if err != nil {
+		app.Sessions.Put(r.Context(), "flash", "The days archived threads are kept has to be a number")
+
+		url := fmt.Sprintf("/admin/board/%s/edit/", formModel.ID)
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
+
 	newBoard := models.Board{
-		ID:        formModel.ID,
-		FullName:  formModel.FullName,
-		BumpLimit: uint(bumpLimit),
+		ID:             formModel.ID,
+		FullName:       formModel.FullName,
+		BumpLimit:      uint(bumpLimit),
+		StripMetadata:  formModel.StripMetadata,
+		ReencodeImages: formModel.ReencodeImages,
+
+		AllowedContentTypes: models.ParseContentTypes(formModel.AllowedContentTypes),
+		MaxFileSize:         maxFileSize << 10,
+		MaxFiles:            int(maxFiles),
+		OpRequiresImage:     formModel.OpRequiresImage,
+
+		StorageQuota: storageQuota << 20,
+		QuotaAction:  formModel.QuotaAction,
+
+		ForceAnonymous: formModel.ForceAnonymous,
+		PosterIDs:      formModel.PosterIDs,
+
+		MaxPages:    uint(maxPages),
+		PruneAction: formModel.PruneAction,
+		ArchiveDays: uint(archiveDays),
 	}
 
 	err = app.BoardModel.Update(newBoard)
@@ -312,12 +523,6 @@ func (app *Application) PostBoardDelete(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.FileInfoModel.DeleteOrphanedFiles()
-	if err != nil {
-		app.serverError(w, err)
-		return
-	}
-
 	app.Sessions.Put(r.Context(), "flash", "Board deleted successfully")
 	http.Redirect(w, r, "/admin/", http.StatusSeeOther)
 }
@@ -394,3 +599,74 @@ func (app *Application) PostBoardCreate(w http.ResponseWriter, r *http.Request)
 	app.Sessions.Put(r.Context(), "flash", "Board edited succesfully")
 	http.Redirect(w, r, "/admin/", http.StatusSeeOther)
 }
+
+func (app *Application) GetArchive(w http.ResponseWriter, r *http.Request) {
+	requiredTemplates := []string{"archive"}
+
+	tmpl, err := app.createTemplate(requiredTemplates, r)
+	if err != nil {
+		log.Fatalf("Failed to load templates: %s", err.Error())
+	}
+
+	boardId := chi.URLParam(r, "boardId")
+
+	templateData, err := app.getTemplateData(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	boards := templateData["Boards"].([]models.Board)
+	var board models.Board
+
+	for _, v := range boards {
+		if v.ID == boardId {
+			board = v
+		}
+	}
+
+	if board.ID == "" {
+		app.notFound(w)
+		return
+	}
+
+	threadCount, err := app.ThreadModel.GetArchivedCount(boardId)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	var pageNumber uint
+
+	if r.URL.Query().Has("page") {
+		queryPageNumber, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil || queryPageNumber < 1 {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+
+		pageNumber = uint(queryPageNumber) - 1
+	}
+
+	pageCount := math.Ceil(float64(threadCount) / models.ArchivedThreadsPerPage)
+	var pageNumbers []int
+	for i := 1; i <= int(pageCount); i++ {
+		pageNumbers = append(pageNumbers, i)
+	}
+
+	threads, err := app.ThreadModel.GetArchived(boardId, pageNumber, models.ArchivedThreadsPerPage)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	templateData["Board"] = board
+	templateData["Threads"] = threads
+	templateData["PageNumbers"] = pageNumbers
+
+	err = tmpl.ExecuteTemplate(w, "base", &templateData)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
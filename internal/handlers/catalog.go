@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/PawBer/FrogBoard/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// catalogSort reads the order of the catalog from the sort query parameter,
+// reporting whether it's one of models.CatalogSorts.
+func catalogSort(r *http.Request) (string, bool) {
+	if !r.URL.Query().Has("sort") {
+		return models.CatalogSorts[0], true
+	}
+
+	sortBy := r.URL.Query().Get("sort")
+	for _, s := range models.CatalogSorts {
+		if s == sortBy {
+			return sortBy, true
+		}
+	}
+
+	return "", false
+}
+
+func (app *Application) GetCatalog(w http.ResponseWriter, r *http.Request) {
+	requiredTemplates := []string{"catalog"}
+
+	tmpl, err := app.createTemplate(requiredTemplates, r)
+	if err != nil {
+		log.Fatalf("Failed to load templates: %s", err.Error())
+	}
+
+	boardId := chi.URLParam(r, "boardId")
+
+	sortBy, ok := catalogSort(r)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	templateData, err := app.getTemplateData(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	boards := templateData["Boards"].([]models.Board)
+	var board models.Board
+
+	for _, v := range boards {
+		if v.ID == boardId {
+			board = v
+		}
+	}
+
+	if board.ID == "" {
+		app.notFound(w)
+		return
+	}
+
+	threads, err := app.ThreadModel.GetCatalog(boardId, sortBy)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	templateData["Board"] = board
+	templateData["Threads"] = threads
+	templateData["Sort"] = sortBy
+
+	err = tmpl.ExecuteTemplate(w, "base", &templateData)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+// GetCatalogJson is the catalog of a board for clients. Only what the catalog
+// page shows is included, the addresses of posters aren't.
+func (app *Application) GetCatalogJson(w http.ResponseWriter, r *http.Request) {
+	boardId := chi.URLParam(r, "boardId")
+
+	sortBy, ok := catalogSort(r)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	boards, err := app.BoardModel.GetBoards()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	found := false
+	for _, board := range boards {
+		if board.ID == boardId {
+			found = true
+		}
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	threads, err := app.ThreadModel.GetCatalog(boardId, sortBy)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	type catalogThread struct {
+		ID         uint
+		BoardID    string
+		Title      string
+		Snippet    string
+		CreatedAt  time.Time
+		LastBump   time.Time
+		LastReply  *time.Time
+		ReplyCount uint
+		ImageCount uint
+		Sticky     bool
+		Locked     bool
+		Cyclical   bool
+		// Thumbnail is the URL of the thumbnail of the first file of the
+		// opening post, or of a file icon for files without one. It's empty
+		// when the opening post has no files.
+		Thumbnail string
+		// Spoiler tells that the poster marked the file as a spoiler,
+		// Thumbnail then points to the spoiler image. Spoilers are a hint
+		// for display, the real thumbnail is at the URL without ?spoiler=1.
+		Spoiler bool
+	}
+
+	catalog := []catalogThread{}
+	for _, thread := range threads {
+		entry := catalogThread{
+			ID:         thread.ID,
+			BoardID:    thread.BoardID,
+			Title:      thread.Title,
+			Snippet:    thread.Snippet(),
+			CreatedAt:  thread.CreatedAt,
+			LastBump:   thread.LastBump,
+			ReplyCount: thread.ReplyCount,
+			ImageCount: thread.ImageCount,
+			Sticky:     thread.Sticky,
+			Locked:     thread.Locked,
+			Cyclical:   thread.Cyclical,
+		}
+
+		if !thread.LastReply.IsZero() {
+			lastReply := thread.LastReply
+			entry.LastReply = &lastReply
+		}
+
+		if file := thread.Thumbnail(); file != nil && (file.ContainsImage() || file.ContainsVideo()) {
+			entry.Thumbnail = fmt.Sprintf("/file/%s/thumb/", file.ID)
+			if file.Spoiler {
+				entry.Thumbnail += "?spoiler=1"
+				entry.Spoiler = true
+			}
+		} else if file != nil {
+			entry.Thumbnail = "/public/file.png"
+		}
+
+		catalog = append(catalog, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(catalog)
+}
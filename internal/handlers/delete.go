@@ -64,12 +64,6 @@ func (app *Application) PostDelete(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err = app.FileInfoModel.DeleteOrphanedFiles()
-		if err != nil {
-			app.serverError(w, err)
-			return
-		}
-
 		url := fmt.Sprintf("/%s/", boardId)
 		http.Redirect(w, r, url, http.StatusFound)
 		return
@@ -79,12 +73,6 @@ func (app *Application) PostDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.FileInfoModel.DeleteOrphanedFiles()
-	if err != nil {
-		app.serverError(w, err)
-		return
-	}
-
 	url := fmt.Sprintf("/%s/%d/", boardId, threadId)
 	http.Redirect(w, r, url, http.StatusFound)
 }
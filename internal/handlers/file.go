@@ -1,34 +1,153 @@
 package handlers
 
 import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/PawBer/FrogBoard/internal/models"
 	"github.com/go-chi/chi/v5"
 )
 
+// Files are addressed by the hash of their content, so whatever is served
+// under a key never changes and can be cached for as long as browsers allow.
+const fileCacheControl = "public, max-age=31536000, immutable"
+
+func setFileCacheHeaders(w http.ResponseWriter, etag string) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fileCacheControl)
+}
+
+func isNotModified(r *http.Request, etag string) bool {
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookupFile finds a file that may be served. Banned files are treated as
+// missing even while they're still stored.
+func (app *Application) lookupFile(hash string) (models.FileInfo, error) {
+	banned, _, err := app.BannedFileModel.IsBanned(hash)
+	if err != nil {
+		return models.FileInfo{}, err
+	}
+	if banned {
+		return models.FileInfo{}, sql.ErrNoRows
+	}
+
+	return app.FileInfoModel.Get(hash)
+}
+
 func (app *Application) GetFile(w http.ResponseWriter, r *http.Request) {
 	hash := chi.URLParam(r, "hash")
 
+	fileInfo, err := app.lookupFile(hash)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// A cached copy is only confirmed once the file is known to still exist
+	// and not to be banned.
+	etag := fmt.Sprintf(`"%s"`, hash)
+	if isNotModified(r, etag) {
+		setFileCacheHeaders(w, etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	file, err := app.FileStore.GetFile(hash)
 	if err != nil {
 		app.notFound(w)
 		return
 	}
+	defer file.Close()
+
+	setFileCacheHeaders(w, etag)
+	w.Header().Set("Content-Type", fileInfo.ContentType)
 
-	w.Write(file)
+	http.ServeContent(w, r, "", time.Time{}, file)
 }
 
 func (app *Application) GetFileThumbnail(w http.ResponseWriter, r *http.Request) {
 	hash := chi.URLParam(r, "hash")
 
+	// Spoilered files link to their thumbnail with ?spoiler=1, which serves
+	// the generic spoiler image until the file is revealed. A file has one
+	// thumbnail shared by every post using it and only some of them may mark
+	// it as a spoiler, so spoilers are a hint for display rather than access
+	// control: the thumbnail without ?spoiler=1 and the file itself are
+	// always served.
+	if r.URL.Query().Get("spoiler") == "1" {
+		app.serveSpoiler(w, r)
+		return
+	}
+
+	_, err := app.lookupFile(hash)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%s-thumb"`, hash)
+	if isNotModified(r, etag) {
+		setFileCacheHeaders(w, etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	file, err := app.FileStore.GetFileThumbnail(hash)
 	if err != nil {
 		app.notFound(w)
 		return
 	}
+	defer file.Close()
+
+	// Thumbnails aren't tracked in file_infos, ServeContent sniffs their type.
+	setFileCacheHeaders(w, etag)
+
+	http.ServeContent(w, r, "", time.Time{}, file)
+}
+
+func (app *Application) serveSpoiler(w http.ResponseWriter, r *http.Request) {
+	etag := `"spoiler"`
+	if isNotModified(r, etag) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	spoiler, err := fs.ReadFile(app.Public, "public/spoiler.svg")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// The spoiler image can change between releases, unlike files.
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("Content-Type", "image/svg+xml")
 
-	w.Write(file)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(spoiler))
 }
 
 func (app *Application) GetFileDelete(w http.ResponseWriter, r *http.Request) {
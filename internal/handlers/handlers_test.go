@@ -0,0 +1,1490 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PawBer/FrogBoard/internal/models"
+)
+
+// postId reads the id of a new post from the redirect to it.
+func postId(t *testing.T, resp response) uint {
+	t.Helper()
+
+	var boardId string
+	var threadId, id uint
+
+	location := strings.NewReplacer("/", " ", "#p", " ").Replace(resp.location)
+	if _, err := fmt.Sscan(location, &boardId, &threadId, &id); err != nil || resp.status != http.StatusFound {
+		t.Fatalf("posting returned %d to %q instead of a redirect to the post", resp.status, resp.location)
+	}
+
+	return id
+}
+
+func fileKey(content string) string {
+	sum := sha1.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestPostBoard(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp := ts.post("b", 0, "first thread", map[string]string{"frog.txt": "ribbit"})
+	id := postId(t, resp)
+
+	if resp.location != fmt.Sprintf("/b/%d/#p%d", id, id) {
+		t.Errorf("the redirect goes to %q", resp.location)
+	}
+
+	thread, err := ts.app.ThreadModel.Get("b", id)
+	if err != nil {
+		t.Fatalf("getting the thread: %s", err)
+	}
+	if thread.Content != "first thread" {
+		t.Errorf("the thread's content is %q", thread.Content)
+	}
+	if len(thread.Files) != 1 || thread.Files[0].ID != fileKey("ribbit") {
+		t.Errorf("the thread has files %+v", thread.Files)
+	}
+
+	if exists, _ := ts.store.Exists(fileKey("ribbit"), false); !exists {
+		t.Error("the file wasn't stored")
+	}
+
+	page := ts.get(fmt.Sprintf("/b/%d/", id))
+	if page.status != http.StatusOK || !strings.Contains(page.body, "first thread") {
+		t.Errorf("the thread page returned %d without the thread", page.status)
+	}
+
+	board := ts.get("/b/")
+	if board.status != http.StatusOK || !strings.Contains(board.body, "first thread") {
+		t.Errorf("the board page returned %d without the thread", board.status)
+	}
+}
+
+func TestPostBoardWrongCaptcha(t *testing.T) {
+	ts := newTestServer(t)
+
+	captchaId, _ := solveCaptcha()
+	resp := ts.postMultipart("/b/", map[string]string{
+		"title":        "Title",
+		"content":      "a robot",
+		"captcha-id":   captchaId,
+		"captcha-code": "wrong",
+	}, nil)
+
+	if resp.status != http.StatusSeeOther || resp.location != "/b/" {
+		t.Fatalf("posting returned %d to %q, want a redirect back to the board", resp.status, resp.location)
+	}
+
+	count, err := ts.app.ThreadModel.GetThreadCount("b")
+	if err != nil || count != 0 {
+		t.Errorf("the board has %d threads, %v", count, err)
+	}
+
+	// The board keeps what was written.
+	board := ts.get("/b/")
+	if !strings.Contains(board.body, "Failed captcha authentication") || !strings.Contains(board.body, "a robot") {
+		t.Error("the board doesn't show the error and the content of the failed post")
+	}
+}
+
+func TestPostBoardUnknownBoard(t *testing.T) {
+	ts := newTestServer(t)
+
+	if resp := ts.post("nope", 0, "lost", nil); resp.status != http.StatusNotFound {
+		t.Errorf("posting to a missing board returned %d", resp.status)
+	}
+}
+
+func TestPostThread(t *testing.T) {
+	ts := newTestServer(t)
+
+	threadId := postId(t, ts.post("b", 0, "thread", nil))
+
+	resp := ts.post("b", threadId, "a reply", nil)
+	replyId := postId(t, resp)
+
+	if resp.location != fmt.Sprintf("/b/%d/#p%d", threadId, replyId) {
+		t.Errorf("the redirect goes to %q", resp.location)
+	}
+
+	reply, err := ts.app.ReplyModel.Get("b", replyId)
+	if err != nil {
+		t.Fatalf("getting the reply: %s", err)
+	}
+	if reply.ThreadID != threadId || reply.Content != "a reply" {
+		t.Errorf("the reply is %+v", reply)
+	}
+
+	page := ts.get(fmt.Sprintf("/b/%d/", threadId))
+	if !strings.Contains(page.body, "a reply") {
+		t.Error("the thread page doesn't show the reply")
+	}
+}
+
+func TestGetPost(t *testing.T) {
+	ts := newTestServer(t)
+
+	threadId := postId(t, ts.post("b", 0, "thread", nil))
+	replyId := postId(t, ts.post("b", threadId, "reply", nil))
+
+	resp := ts.get(fmt.Sprintf("/b/%d/", replyId))
+	if resp.status != http.StatusFound || resp.location != fmt.Sprintf("/b/%d/#p%d", threadId, replyId) {
+		t.Errorf("getting a reply returned %d to %q, want a redirect into its thread", resp.status, resp.location)
+	}
+
+	if resp := ts.get("/b/1000/"); resp.status != http.StatusNotFound {
+		t.Errorf("getting a missing post returned %d", resp.status)
+	}
+}
+
+func TestDeletePosts(t *testing.T) {
+	ts := newTestServer(t)
+
+	threadId := postId(t, ts.post("b", 0, "thread", map[string]string{"thread.txt": "thread file"}))
+	replyId := postId(t, ts.post("b", threadId, "reply", nil))
+
+	deletePath := fmt.Sprintf("/admin/b/%d/delete/", replyId)
+
+	if resp := ts.postForm(deletePath, nil); resp.status != http.StatusForbidden {
+		t.Errorf("deleting without logging in returned %d", resp.status)
+	}
+
+	ts.login()
+
+	resp := ts.postForm(deletePath, nil)
+	if resp.status != http.StatusFound || resp.location != fmt.Sprintf("/b/%d/", threadId) {
+		t.Errorf("deleting the reply returned %d to %q", resp.status, resp.location)
+	}
+	if resp := ts.get(fmt.Sprintf("/b/%d/", replyId)); resp.status != http.StatusNotFound {
+		t.Errorf("getting the deleted reply returned %d", resp.status)
+	}
+
+	resp = ts.postForm(fmt.Sprintf("/admin/b/%d/delete/", threadId), nil)
+	if resp.status != http.StatusFound || resp.location != "/b/" {
+		t.Errorf("deleting the thread returned %d to %q", resp.status, resp.location)
+	}
+	if resp := ts.get(fmt.Sprintf("/b/%d/", threadId)); resp.status != http.StatusNotFound {
+		t.Errorf("getting the deleted thread returned %d", resp.status)
+	}
+}
+
+func TestBannedUser(t *testing.T) {
+	ts := newTestServer(t)
+
+	threadId := postId(t, ts.post("b", 0, "thread", nil))
+
+	ts.ban("spam", time.Hour)
+
+	resp := ts.get("/b/")
+	if !strings.Contains(resp.body, "You are banned") || !strings.Contains(resp.body, "Reason: spam") {
+		t.Errorf("a banned user got %q", resp.body)
+	}
+
+	ts.post("b", threadId, "still here", nil)
+
+	thread, err := ts.app.ThreadModel.Get("b", threadId)
+	if err != nil {
+		t.Fatalf("getting the thread: %s", err)
+	}
+	if len(thread.Replies) != 0 {
+		t.Error("a banned user could reply")
+	}
+}
+
+func TestExpiredBan(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.ban("old", -time.Minute)
+
+	if resp := ts.get("/b/"); resp.status != http.StatusOK || strings.Contains(resp.body, "You are banned") {
+		t.Errorf("an expired ban still blocks, got %d", resp.status)
+	}
+
+	if banned, _, err := ts.app.BanModel.IsBanned(&http.Request{RemoteAddr: "127.0.0.1:1"}); err != nil || banned {
+		t.Errorf("the expired ban wasn't lifted: %t, %v", banned, err)
+	}
+}
+
+func TestBanCreate(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.login()
+
+	resp := ts.postForm("/admin/bans/create/", url.Values{
+		"ip":       {"127.0.0.1"},
+		"reason":   {"rule 1"},
+		"end-date": {time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02T15:04")},
+	})
+	if resp.status != http.StatusSeeOther || resp.location != "/admin/" {
+		t.Fatalf("creating a ban returned %d to %q", resp.status, resp.location)
+	}
+
+	if resp := ts.get("/"); !strings.Contains(resp.body, "Reason: rule 1") {
+		t.Errorf("the banned address got %q", resp.body)
+	}
+}
+
+func TestBannedFile(t *testing.T) {
+	ts := newTestServer(t)
+
+	threadId := postId(t, ts.post("b", 0, "thread", map[string]string{"bad.txt": "bad file"}))
+
+	ts.login()
+
+	resp := ts.postForm(fmt.Sprintf("/admin/file/%s/ban/", fileKey("bad file")), url.Values{"reason": {"illegal"}})
+	if resp.status != http.StatusSeeOther {
+		t.Fatalf("banning the file returned %d", resp.status)
+	}
+
+	// The banned file is deleted and no longer served.
+	if exists, _ := ts.store.Exists(fileKey("bad file"), false); exists {
+		t.Error("the banned file is still stored")
+	}
+	if _, err := ts.app.FileInfoModel.Get(fileKey("bad file")); err == nil {
+		t.Error("the banned file is still recorded")
+	}
+	if resp := ts.get(fmt.Sprintf("/file/%s/", fileKey("bad file"))); resp.status != http.StatusNotFound {
+		t.Errorf("getting the banned file returned %d", resp.status)
+	}
+
+	resp = ts.post("b", threadId, "again", map[string]string{"renamed.txt": "bad file"})
+	if resp.status != http.StatusSeeOther || resp.location != fmt.Sprintf("/b/%d/", threadId) {
+		t.Fatalf("reposting the banned file returned %d to %q", resp.status, resp.location)
+	}
+
+	page := ts.get(fmt.Sprintf("/b/%d/", threadId))
+	if !strings.Contains(page.body, "This file is not allowed") {
+		t.Error("the poster isn't told the file is banned")
+	}
+
+	thread, err := ts.app.ThreadModel.Get("b", threadId)
+	if err != nil {
+		t.Fatalf("getting the thread: %s", err)
+	}
+	if len(thread.Replies) != 0 {
+		t.Error("the reply with the banned file was posted")
+	}
+
+	// Without an auto ban the poster can keep posting other files.
+	postId(t, ts.post("b", threadId, "fine", map[string]string{"good.txt": "good file"}))
+}
+
+func TestBannedFileAutoBan(t *testing.T) {
+	ts := newTestServer(t)
+	ts.app.FileAutoBan = time.Hour
+
+	if err := ts.app.BannedFileModel.Ban(fileKey("bad file"), "illegal"); err != nil {
+		t.Fatalf("banning the file: %s", err)
+	}
+
+	ts.post("b", 0, "thread", map[string]string{"bad.txt": "bad file"})
+
+	if resp := ts.get("/b/"); !strings.Contains(resp.body, "Posted a banned file: illegal") {
+		t.Errorf("the poster wasn't banned, got %q", resp.body)
+	}
+
+	// The refused file is neither stored nor recorded.
+	if exists, _ := ts.store.Exists(fileKey("bad file"), false); exists {
+		t.Error("the banned file was stored")
+	}
+	if _, err := ts.app.FileInfoModel.Get(fileKey("bad file")); err == nil {
+		t.Error("the banned file was recorded")
+	}
+}
+
+func TestPostWithTripcode(t *testing.T) {
+	ts := newTestServer(t)
+
+	threadId := postId(t, ts.postAs("b", 0, "frog#tripcode", "thread", nil))
+
+	thread, err := ts.app.ThreadModel.Get("b", threadId)
+	if err != nil {
+		t.Fatalf("getting the thread: %s", err)
+	}
+	if thread.Name != "frog" || thread.Tripcode != "!3GqYIJ3Obs" {
+		t.Errorf("the thread was posted by %q %q", thread.Name, thread.Tripcode)
+	}
+
+	replyId := postId(t, ts.postAs("b", threadId, "##secret", "reply", nil))
+
+	api := ts.get(fmt.Sprintf("/api/post/b/%d/", replyId))
+	if !strings.Contains(api.body, `"Name":""`) || !strings.Contains(api.body, `"Tripcode":"!!`) {
+		t.Errorf("the JSON of the reply is %s", api.body)
+	}
+
+	page := ts.get(fmt.Sprintf("/b/%d/", threadId))
+	if !strings.Contains(page.body, "!3GqYIJ3Obs") || !strings.Contains(page.body, "Anonymous") {
+		t.Error("the thread page doesn't show the names")
+	}
+	if strings.Contains(page.body, "secret") {
+		t.Error("the page shows the secure tripcode's password")
+	}
+
+	// The name is filled in again without the password.
+	postId(t, ts.postAs("b", threadId, "frog##secret", "another reply", nil))
+
+	page = ts.get(fmt.Sprintf("/b/%d/", threadId))
+	if !strings.Contains(page.body, `name="name" value="frog"`) {
+		t.Error("the reply form doesn't fill in the name")
+	}
+	if strings.Contains(page.body, "secret") {
+		t.Error("the reply form fills in the secure tripcode's password")
+	}
+}
+
+func TestForceAnonymous(t *testing.T) {
+	ts := newTestServer(t)
+
+	board, err := ts.app.BoardModel.Get("b")
+	if err != nil {
+		t.Fatalf("getting the board: %s", err)
+	}
+	board.ForceAnonymous = true
+	if err := ts.app.BoardModel.Update(board); err != nil {
+		t.Fatalf("updating the board: %s", err)
+	}
+
+	threadId := postId(t, ts.postAs("b", 0, "frog#tripcode", "thread", nil))
+
+	thread, err := ts.app.ThreadModel.Get("b", threadId)
+	if err != nil {
+		t.Fatalf("getting the thread: %s", err)
+	}
+	if thread.Name != "" || thread.Tripcode != "" {
+		t.Errorf("the thread was posted by %q %q on a forced anonymous board", thread.Name, thread.Tripcode)
+	}
+}
+
+func TestSecureTripcodeWithoutSalt(t *testing.T) {
+	ts := newTestServer(t)
+	ts.app.TripcodeSalt = ""
+
+	resp := ts.postAs("b", 0, "frog##secret", "thread", nil)
+	if resp.status != http.StatusSeeOther {
+		t.Fatalf("posting returned %d, want a redirect back to the board", resp.status)
+	}
+
+	if board := ts.get("/b/"); !strings.Contains(board.body, "Secure tripcodes aren") {
+		t.Error("the poster isn't told secure tripcodes are off")
+	}
+}
+
+func TestPostTooLong(t *testing.T) {
+	ts := newTestServer(t)
+
+	content := strings.Repeat("*", maxContentLength+1)
+
+	if resp := ts.post("b", 0, content, nil); resp.status != http.StatusSeeOther || resp.location != "/b/" {
+		t.Fatalf("posting a long thread returned %d to %q, want a redirect back to the board", resp.status, resp.location)
+	}
+	if board := ts.get("/b/"); !strings.Contains(board.body, "Posts can be at most") {
+		t.Error("the poster isn't told the thread is too long")
+	}
+
+	threadId := postId(t, ts.post("b", 0, strings.Repeat("*", maxContentLength), nil))
+
+	resp := ts.post("b", threadId, content, nil)
+	if resp.status != http.StatusSeeOther || resp.location != fmt.Sprintf("/b/%d/", threadId) {
+		t.Fatalf("posting a long reply returned %d to %q, want a redirect back to the thread", resp.status, resp.location)
+	}
+
+	count, err := ts.app.ThreadModel.GetThreadCount("b")
+	if err != nil || count != 1 {
+		t.Errorf("the board has %d threads, %v", count, err)
+	}
+	if page := ts.get(fmt.Sprintf("/b/%d/", threadId)); !strings.Contains(page.body, "Posts can be at most") {
+		t.Error("the poster isn't told the reply is too long")
+	}
+}
+
+func TestPosterIDs(t *testing.T) {
+	ts := newTestServer(t)
+
+	threadId := postId(t, ts.post("b", 0, "thread", nil))
+
+	thread, err := ts.app.ThreadModel.Get("b", threadId)
+	if err != nil {
+		t.Fatalf("getting the thread: %s", err)
+	}
+	if thread.PosterID != "" {
+		t.Errorf("the thread has the ID %q on a board without IDs", thread.PosterID)
+	}
+
+	board, err := ts.app.BoardModel.Get("b")
+	if err != nil {
+		t.Fatalf("getting the board: %s", err)
+	}
+	board.PosterIDs = true
+	if err := ts.app.BoardModel.Update(board); err != nil {
+		t.Fatalf("updating the board: %s", err)
+	}
+
+	replyId := postId(t, ts.post("b", threadId, "reply", nil))
+	otherThreadId := postId(t, ts.post("b", 0, "other thread", nil))
+
+	thread, err = ts.app.ThreadModel.Get("b", threadId)
+	if err != nil {
+		t.Fatalf("getting the thread: %s", err)
+	}
+	if thread.PosterID == "" || len(thread.Replies) != 1 || thread.Replies[0].PosterID != thread.PosterID {
+		t.Errorf("the posts of one poster in a thread don't share an ID")
+	}
+
+	otherThread, err := ts.app.ThreadModel.Get("b", otherThreadId)
+	if err != nil {
+		t.Fatalf("getting the thread: %s", err)
+	}
+	if otherThread.PosterID == thread.PosterID {
+		t.Error("the poster has the same ID in two threads")
+	}
+
+	api := ts.get(fmt.Sprintf("/api/post/b/%d/", replyId))
+	if !strings.Contains(api.body, fmt.Sprintf(`"PosterID":%q`, thread.PosterID)) {
+		t.Errorf("the JSON of the reply is %s", api.body)
+	}
+
+	page := ts.get(fmt.Sprintf("/b/%d/", threadId))
+	if !strings.Contains(page.body, "ID: "+thread.PosterID) {
+		t.Error("the thread page doesn't show the ID")
+	}
+}
+
+func TestParsePostOptions(t *testing.T) {
+	cases := map[string]postOptions{
+		"":                {},
+		"noko":            {},
+		"sage":            {Sage: true},
+		"SAGE":            {Sage: true},
+		"nonoko":          {NoNoko: true},
+		"sage, nonoko":    {Sage: true, NoNoko: true},
+		"nonokosage":      {Sage: true, NoNoko: true},
+		"nokosage":        {Sage: true},
+		"me@example.com":  {},
+		"nonoko noko":     {},
+		" sage  nonoko  ": {Sage: true, NoNoko: true},
+	}
+
+	for field, want := range cases {
+		if got := parsePostOptions(field); got != want {
+			t.Errorf("parsePostOptions(%q) = %+v, want %+v", field, got, want)
+		}
+	}
+}
+
+func TestSageAndNoko(t *testing.T) {
+	ts := newTestServer(t)
+
+	olderId := postId(t, ts.post("b", 0, "older", nil))
+	newerId := postId(t, ts.post("b", 0, "newer", nil))
+
+	latestId := func() uint {
+		threads, err := ts.app.ThreadModel.GetLatest("b", 0, 10)
+		if err != nil || len(threads) == 0 {
+			t.Fatalf("getting the threads: %v", err)
+		}
+
+		return threads[0].ID
+	}
+
+	captchaId, captchaCode := solveCaptcha()
+	resp := ts.postMultipart(fmt.Sprintf("/b/%d/", olderId), map[string]string{
+		"content":      "sage",
+		"options":      "sage nonoko",
+		"captcha-id":   captchaId,
+		"captcha-code": captchaCode,
+	}, nil)
+	if resp.status != http.StatusFound || resp.location != "/b/" {
+		t.Errorf("a nonoko reply returned %d to %q, want a redirect to the board", resp.status, resp.location)
+	}
+
+	if latest := latestId(); latest != newerId {
+		t.Errorf("the sage reply bumped its thread")
+	}
+
+	thread, err := ts.app.ThreadModel.Get("b", olderId)
+	if err != nil {
+		t.Fatalf("getting the thread: %s", err)
+	}
+	if len(thread.Replies) != 1 || !thread.Replies[0].Sage {
+		t.Error("the reply isn't recorded as sage")
+	}
+
+	postId(t, ts.post("b", olderId, "bump", nil))
+
+	if latest := latestId(); latest != olderId {
+		t.Errorf("a normal reply didn't bump its thread")
+	}
+}
+
+func TestCrossBoardCitations(t *testing.T) {
+	ts := newTestServer(t)
+
+	if err := ts.app.BoardModel.Insert("a", "Anime", 300); err != nil {
+		t.Fatalf("creating a board: %s", err)
+	}
+
+	threadId := postId(t, ts.post("b", 0, "thread", nil))
+	replyId := postId(t, ts.post("b", threadId, "reply", nil))
+
+	// Board a gets a post with the same number as the cited one, the
+	// citation mustn't show up on it.
+	postId(t, ts.post("a", 0, "first", nil))
+	sameId := postId(t, ts.post("a", 0, "same number", nil))
+	if sameId != replyId {
+		t.Fatalf("the posts got the numbers %d and %d", sameId, replyId)
+	}
+
+	citerId := postId(t, ts.post("a", 0, fmt.Sprintf(">>>/b/%d", replyId), nil))
+
+	resp := ts.get(fmt.Sprintf("/a/%d/", citerId))
+	if link := fmt.Sprintf(`data-board="b" data-post="%d" class="post-link text-blue-500" href="/b/%d/">`, replyId, replyId); !strings.Contains(resp.body, link) {
+		t.Errorf("the citing post doesn't link to the other board")
+	}
+
+	backlink := fmt.Sprintf(`href="/a/%d/">&gt;&gt;&gt;/a/%d</a>`, citerId, citerId)
+
+	resp = ts.get(fmt.Sprintf("/b/%d/", threadId))
+	if !strings.Contains(resp.body, backlink) {
+		t.Errorf("the cited post doesn't link back to the other board")
+	}
+
+	resp = ts.get(fmt.Sprintf("/a/%d/", sameId))
+	if strings.Contains(resp.body, fmt.Sprintf(`href="/a/%d/"`, citerId)) {
+		t.Errorf("the citation of /b/%d shows up on /a/%d", replyId, sameId)
+	}
+
+	resp = ts.get(fmt.Sprintf("/api/post/a/%d/", citerId))
+	if !strings.Contains(resp.body, fmt.Sprintf(`data-board=\"b\" data-post=\"%d\"`, replyId)) {
+		t.Errorf("the preview of the citing post isn't formatted: %s", resp.body)
+	}
+}
+
+func TestPostMarkup(t *testing.T) {
+	ts := newTestServer(t)
+
+	threadId := postId(t, ts.post("b", 0, "**bold** <i>", nil))
+
+	thread, err := ts.app.ThreadModel.Get("b", threadId)
+	if err != nil {
+		t.Fatalf("getting the thread: %s", err)
+	}
+	if thread.ContentHTML != "<strong>bold</strong> &lt;i&gt;" {
+		t.Errorf("the rendered content stored is %q", thread.ContentHTML)
+	}
+
+	resp := ts.get(fmt.Sprintf("/b/%d/", threadId))
+	if !strings.Contains(resp.body, "<strong>bold</strong> &lt;i&gt;") {
+		t.Error("the thread doesn't show the rendered content")
+	}
+}
+
+func TestDeadCitations(t *testing.T) {
+	ts := newTestServer(t)
+
+	threadId := postId(t, ts.post("b", 0, "thread", nil))
+	citedId := postId(t, ts.post("b", threadId, "cited", nil))
+	citerId := postId(t, ts.post("b", threadId, fmt.Sprintf(">>%d >>1000", citedId), nil))
+
+	dead := func(id uint) string {
+		return fmt.Sprintf(`<span class="dead-link line-through text-gray-500">&gt;&gt; %d</span>`, id)
+	}
+
+	thread, err := ts.app.ThreadModel.Get("b", threadId)
+	if err != nil {
+		t.Fatalf("getting the thread: %s", err)
+	}
+	if citations := thread.Replies[0].Citations; len(citations) != 1 || citations[0].PostID != citerId {
+		t.Errorf("the cited post has the citations %+v", citations)
+	}
+	if !strings.Contains(thread.Replies[1].ContentHTML, dead(1000)) {
+		t.Errorf("the citation of a missing post isn't dead: %s", thread.Replies[1].ContentHTML)
+	}
+	if strings.Contains(thread.Replies[1].ContentHTML, dead(citedId)) {
+		t.Errorf("the citation of an existing post is dead")
+	}
+
+	ts.login()
+
+	resp := ts.postForm(fmt.Sprintf("/admin/b/%d/delete/", citedId), nil)
+	if resp.status != http.StatusFound {
+		t.Fatalf("deleting returned %d", resp.status)
+	}
+
+	resp = ts.get(fmt.Sprintf("/b/%d/", threadId))
+	if !strings.Contains(resp.body, dead(citedId)) {
+		t.Errorf("the citation of the deleted post isn't dead")
+	}
+}
+
+func TestGetPostHtml(t *testing.T) {
+	ts := newTestServer(t)
+
+	threadId := postId(t, ts.post("b", 0, "thread", nil))
+	replyId := postId(t, ts.post("b", threadId, "**reply**", map[string]string{"reply.txt": "reply file"}))
+
+	resp := ts.get(fmt.Sprintf("/api/post/b/%d/html/", replyId))
+	if resp.status != http.StatusOK {
+		t.Fatalf("getting the post returned %d", resp.status)
+	}
+
+	for _, want := range []string{"<strong>reply</strong>", "reply.txt", fmt.Sprintf("No. %d", replyId)} {
+		if !strings.Contains(resp.body, want) {
+			t.Errorf("the rendered post doesn't contain %q", want)
+		}
+	}
+
+	resp = ts.get(fmt.Sprintf("/api/post/b/%d/html/", threadId))
+	if resp.status != http.StatusOK || !strings.Contains(resp.body, "Title") {
+		t.Errorf("getting the thread returned %d", resp.status)
+	}
+
+	if resp := ts.get("/api/post/b/1000/html/"); resp.status != http.StatusNotFound {
+		t.Errorf("getting a missing post returned %d", resp.status)
+	}
+}
+
+func TestThreadFlags(t *testing.T) {
+	ts := newTestServer(t)
+
+	olderId := postId(t, ts.post("b", 0, "older", nil))
+	newerId := postId(t, ts.post("b", 0, "newer", nil))
+
+	flag := func(threadId uint, flag string, value bool) response {
+		return ts.postForm(fmt.Sprintf("/admin/b/%d/flags/", threadId), url.Values{
+			"flag":  {flag},
+			"value": {fmt.Sprint(value)},
+		})
+	}
+
+	if resp := flag(olderId, "locked", true); resp.status != http.StatusForbidden {
+		t.Errorf("flagging without logging in returned %d", resp.status)
+	}
+
+	ts.login()
+
+	if resp := flag(olderId, "deleted", true); resp.status != http.StatusBadRequest {
+		t.Errorf("setting an unknown flag returned %d", resp.status)
+	}
+
+	resp := flag(olderId, "sticky", true)
+	if resp.status != http.StatusSeeOther || resp.location != fmt.Sprintf("/b/%d/", olderId) {
+		t.Errorf("making the thread sticky returned %d to %q", resp.status, resp.location)
+	}
+
+	postId(t, ts.post("b", newerId, "bump", nil))
+
+	threads, err := ts.app.ThreadModel.GetLatest("b", 0, 10)
+	if err != nil || len(threads) != 2 {
+		t.Fatalf("getting the threads: %v", err)
+	}
+	if threads[0].ID != olderId || !threads[0].Sticky {
+		t.Errorf("the sticky thread isn't first")
+	}
+
+	flag(olderId, "locked", true)
+
+	resp = ts.post("b", olderId, "locked out", nil)
+	if resp.status != http.StatusSeeOther || resp.location != fmt.Sprintf("/b/%d/", olderId) {
+		t.Errorf("replying to a locked thread returned %d to %q", resp.status, resp.location)
+	}
+
+	thread, err := ts.app.ThreadModel.Get("b", olderId)
+	if err != nil {
+		t.Fatalf("getting the thread: %s", err)
+	}
+	if !thread.Locked || len(thread.Replies) != 0 {
+		t.Errorf("the locked thread got a reply")
+	}
+
+	flag(olderId, "locked", false)
+	postId(t, ts.post("b", olderId, "unlocked", nil))
+}
+
+func TestCyclicalThread(t *testing.T) {
+	ts := newTestServer(t)
+
+	if err := ts.app.BoardModel.Insert("c", "Cyclical", 3); err != nil {
+		t.Fatalf("creating a board: %s", err)
+	}
+
+	threadId := postId(t, ts.post("c", 0, "thread", nil))
+	if err := ts.app.ThreadModel.SetFlag("c", threadId, "cyclical", true); err != nil {
+		t.Fatalf("making the thread cyclical: %s", err)
+	}
+
+	var replyIds []uint
+	for i := 0; i < 5; i++ {
+		replyIds = append(replyIds, postId(t, ts.post("c", threadId, fmt.Sprintf("reply %d", i), map[string]string{
+			fmt.Sprintf("%d.txt", i): fmt.Sprintf("cyclical file %d", i),
+		})))
+	}
+
+	thread, err := ts.app.ThreadModel.Get("c", threadId)
+	if err != nil {
+		t.Fatalf("getting the thread: %s", err)
+	}
+
+	if len(thread.Replies) != 3 {
+		t.Fatalf("the thread has %d replies, want 3", len(thread.Replies))
+	}
+	for i, reply := range thread.Replies {
+		if reply.ID != replyIds[i+2] {
+			t.Errorf("reply %d is %d, want %d", i, reply.ID, replyIds[i+2])
+		}
+	}
+
+	if resp := ts.get(fmt.Sprintf("/c/%d/", replyIds[0])); resp.status != http.StatusNotFound {
+		t.Errorf("getting a pruned reply returned %d", resp.status)
+	}
+}
+
+func TestThreadPruning(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, action := range []string{models.PruneDelete, models.PruneArchive} {
+		if err := ts.app.BoardModel.Insert(action, action, 300); err != nil {
+			t.Fatalf("creating a board: %s", err)
+		}
+
+		board, err := ts.app.BoardModel.Get(action)
+		if err != nil {
+			t.Fatalf("getting the board: %s", err)
+		}
+		board.MaxPages = 1
+		board.PruneAction = action
+		if err := ts.app.BoardModel.Update(board); err != nil {
+			t.Fatalf("updating the board: %s", err)
+		}
+
+		stickyId := postId(t, ts.post(action, 0, "sticky", nil))
+		if err := ts.app.ThreadModel.SetFlag(action, stickyId, "sticky", true); err != nil {
+			t.Fatalf("making the thread sticky: %s", err)
+		}
+
+		oldestId := postId(t, ts.post(action, 0, "oldest", map[string]string{"oldest.txt": "pruned file " + action}))
+		for i := 0; i < models.ThreadsPerPage-1; i++ {
+			postId(t, ts.post(action, 0, fmt.Sprintf("thread %d", i), nil))
+		}
+
+		count, err := ts.app.ThreadModel.GetThreadCount(action)
+		if err != nil {
+			t.Fatalf("counting the threads: %s", err)
+		}
+		if count != models.ThreadsPerPage {
+			t.Errorf("/%s/ has %d threads, want %d", action, count, models.ThreadsPerPage)
+		}
+
+		if _, err := ts.app.ThreadModel.Get(action, stickyId); err != nil {
+			t.Errorf("the sticky thread of /%s/ was pruned: %s", action, err)
+		}
+
+		resp := ts.get(fmt.Sprintf("/%s/%d/", action, oldestId))
+		archive := ts.get(fmt.Sprintf("/%s/archive/", action))
+
+		switch action {
+		case models.PruneDelete:
+			if resp.status != http.StatusNotFound {
+				t.Errorf("getting the deleted thread returned %d", resp.status)
+			}
+			if _, err := ts.app.FileInfoModel.CollectGarbage(-time.Minute); err != nil {
+				t.Fatalf("collecting garbage: %s", err)
+			}
+			if exists, _ := ts.store.Exists(fileKey("pruned file "+action), false); exists {
+				t.Error("the file of the deleted thread is still stored")
+			}
+		case models.PruneArchive:
+			if resp.status != http.StatusOK || !strings.Contains(resp.body, "This thread was archived") {
+				t.Errorf("getting the archived thread returned %d", resp.status)
+			}
+			if !strings.Contains(archive.body, fmt.Sprintf(`href="/%s/%d/"`, action, oldestId)) {
+				t.Errorf("the archive doesn't list the archived thread")
+			}
+
+			resp = ts.post(action, oldestId, "too late", nil)
+			if resp.status != http.StatusSeeOther {
+				t.Errorf("replying to an archived thread returned %d", resp.status)
+			}
+		}
+	}
+}
+
+// archiveThreads posts threads on /b/ and moves them to the archive as if
+// they were archived the given time ago.
+func (ts *testServer) archiveThreads(count int, age time.Duration) []uint {
+	ts.t.Helper()
+
+	var threadIds []uint
+	for i := 0; i < count; i++ {
+		threadId, err := ts.app.ThreadModel.Insert("b", "", "", "", fmt.Sprintf("archived %d", i), nil, "127.0.0.1")
+		if err != nil {
+			ts.t.Fatalf("posting a thread: %s", err)
+		}
+
+		_, err = ts.app.ThreadModel.DbConn.Exec("UPDATE threads SET archived_at = $1 WHERE board_id = 'b' AND id = $2", time.Now().UTC().Add(-age), threadId)
+		if err != nil {
+			ts.t.Fatalf("archiving a thread: %s", err)
+		}
+
+		threadIds = append(threadIds, threadId)
+	}
+
+	return threadIds
+}
+
+func TestArchivePaging(t *testing.T) {
+	ts := newTestServer(t)
+
+	threadIds := ts.archiveThreads(models.ArchivedThreadsPerPage+1, time.Hour)
+	first := threadIds[0]
+	last := threadIds[len(threadIds)-1]
+
+	page := ts.get("/b/archive/")
+	if page.status != http.StatusOK {
+		t.Fatalf("getting the archive returned %d", page.status)
+	}
+	if !strings.Contains(page.body, fmt.Sprintf(`href="/b/%d/"`, last)) || strings.Contains(page.body, fmt.Sprintf(`href="/b/%d/"`, first)) {
+		t.Error("the first page of the archive doesn't list the latest threads only")
+	}
+	if !strings.Contains(page.body, `href="/b/archive/?page=2"`) {
+		t.Error("the archive doesn't link to its second page")
+	}
+
+	page = ts.get("/b/archive/?page=2")
+	if page.status != http.StatusOK || !strings.Contains(page.body, fmt.Sprintf(`href="/b/%d/"`, first)) {
+		t.Errorf("the second page of the archive returned %d without the oldest thread", page.status)
+	}
+
+	for _, query := range []string{"0", "-1", "first"} {
+		if resp := ts.get("/b/archive/?page=" + query); resp.status != http.StatusBadRequest {
+			t.Errorf("getting archive page %q returned %d", query, resp.status)
+		}
+	}
+}
+
+func TestArchiveExpiry(t *testing.T) {
+	ts := newTestServer(t)
+
+	board, err := ts.app.BoardModel.Get("b")
+	if err != nil {
+		t.Fatalf("getting the board: %s", err)
+	}
+	board.ArchiveDays = 2
+	if err := ts.app.BoardModel.Update(board); err != nil {
+		t.Fatalf("updating the board: %s", err)
+	}
+
+	expired := ts.archiveThreads(1, 3*24*time.Hour)[0]
+	kept := ts.archiveThreads(1, 24*time.Hour)[0]
+
+	// Archived threads expire when something is posted on the board.
+	postId(t, ts.post("b", 0, "new thread", nil))
+
+	if resp := ts.get(fmt.Sprintf("/b/%d/", expired)); resp.status != http.StatusNotFound {
+		t.Errorf("getting the expired thread returned %d", resp.status)
+	}
+	if resp := ts.get(fmt.Sprintf("/b/%d/", kept)); resp.status != http.StatusOK {
+		t.Errorf("getting the thread archived a day ago returned %d", resp.status)
+	}
+
+	count, err := ts.app.ThreadModel.GetArchivedCount("b")
+	if err != nil || count != 1 {
+		t.Errorf("the archive has %d threads, %v", count, err)
+	}
+}
+
+func TestThumbnailBoxes(t *testing.T) {
+	ts := newTestServer(t)
+
+	var threadImg, replyImg bytes.Buffer
+	png.Encode(&threadImg, image.NewGray(image.Rect(0, 0, 8, 8)))
+	png.Encode(&replyImg, image.NewGray(image.Rect(0, 0, 9, 9)))
+
+	threadId := postId(t, ts.post("b", 0, "thread", map[string]string{"thread.png": threadImg.String()}))
+	postId(t, ts.post("b", threadId, "reply", map[string]string{"reply.png": replyImg.String()}))
+
+	page := ts.get(fmt.Sprintf("/b/%d/", threadId))
+
+	thumbnails := ts.app.Thumbnails
+	for _, box := range []string{
+		fmt.Sprintf("max-width: min(35vw, %dpx); max-height: %dpx;", thumbnails.MaxWidth, thumbnails.MaxHeight),
+		fmt.Sprintf("max-width: min(35vw, %dpx); max-height: %dpx;", thumbnails.ReplyMaxWidth, thumbnails.ReplyMaxHeight),
+	} {
+		if !strings.Contains(page.body, box) {
+			t.Errorf("the thread page doesn't show a thumbnail in the box %q", box)
+		}
+	}
+}
+
+// fileRefs reads how many posts use a file and whether it's waiting for
+// garbage collection.
+func (ts *testServer) fileRefs(fileId string) (int, bool) {
+	ts.t.Helper()
+
+	var refCount int
+	var unreferenced bool
+	err := ts.app.FileInfoModel.DbConn.QueryRow("SELECT ref_count, unreferenced_at IS NOT NULL FROM file_infos WHERE id = $1", fileId).Scan(&refCount, &unreferenced)
+	if err != nil {
+		ts.t.Fatalf("reading the references of %s: %s", fileId, err)
+	}
+
+	return refCount, unreferenced
+}
+
+func (ts *testServer) collectGarbage(gracePeriod time.Duration) {
+	ts.t.Helper()
+
+	if _, err := ts.app.FileInfoModel.CollectGarbage(gracePeriod); err != nil {
+		ts.t.Fatalf("collecting garbage: %s", err)
+	}
+}
+
+func TestFileReferences(t *testing.T) {
+	ts := newTestServer(t)
+
+	shared := fileKey("shared file")
+
+	threadId := postId(t, ts.post("b", 0, "thread", map[string]string{"a.txt": "shared file", "b.txt": "shared file"}))
+	replyId := postId(t, ts.post("b", threadId, "reply", map[string]string{"c.txt": "shared file"}))
+
+	if refs, unreferenced := ts.fileRefs(shared); refs != 3 || unreferenced {
+		t.Errorf("the file has %d references, unreferenced: %t, want 3 references", refs, unreferenced)
+	}
+
+	// Deleting one of the posts using the file keeps it.
+	if _, err := ts.app.ReplyModel.Delete("b", replyId); err != nil {
+		t.Fatalf("deleting the reply: %s", err)
+	}
+	ts.collectGarbage(-time.Minute)
+
+	if refs, unreferenced := ts.fileRefs(shared); refs != 2 || unreferenced {
+		t.Errorf("after deleting the reply the file has %d references, unreferenced: %t, want 2 references", refs, unreferenced)
+	}
+	if exists, _ := ts.store.Exists(shared, false); !exists {
+		t.Error("the file was collected while a post still uses it")
+	}
+
+	// Without any post it's only collected after the grace period.
+	if err := ts.app.ThreadModel.Delete("b", threadId); err != nil {
+		t.Fatalf("deleting the thread: %s", err)
+	}
+
+	if refs, unreferenced := ts.fileRefs(shared); refs != 0 || !unreferenced {
+		t.Errorf("after deleting every post the file has %d references, unreferenced: %t", refs, unreferenced)
+	}
+
+	ts.collectGarbage(time.Hour)
+	if exists, _ := ts.store.Exists(shared, false); !exists {
+		t.Error("the file was collected during the grace period")
+	}
+
+	ts.collectGarbage(-time.Minute)
+	if exists, _ := ts.store.Exists(shared, false); exists {
+		t.Error("the file wasn't collected after the grace period")
+	}
+	if _, err := ts.app.FileInfoModel.Get(shared); err == nil {
+		t.Error("the collected file is still recorded")
+	}
+}
+
+func TestFileReuploadDuringGracePeriod(t *testing.T) {
+	ts := newTestServer(t)
+
+	reused := fileKey("reused file")
+
+	threadId := postId(t, ts.post("b", 0, "thread", map[string]string{"first.txt": "reused file"}))
+	if err := ts.app.ThreadModel.Delete("b", threadId); err != nil {
+		t.Fatalf("deleting the thread: %s", err)
+	}
+
+	if _, unreferenced := ts.fileRefs(reused); !unreferenced {
+		t.Fatal("the file isn't waiting for garbage collection after its post was deleted")
+	}
+
+	postId(t, ts.post("b", 0, "again", map[string]string{"second.txt": "reused file"}))
+
+	if refs, unreferenced := ts.fileRefs(reused); refs != 1 || unreferenced {
+		t.Errorf("after posting the file again it has %d references, unreferenced: %t, want 1 reference", refs, unreferenced)
+	}
+
+	ts.collectGarbage(-time.Minute)
+	if exists, _ := ts.store.Exists(reused, false); !exists {
+		t.Error("the file posted again was collected")
+	}
+}
+
+func TestUploadPolicy(t *testing.T) {
+	var img bytes.Buffer
+	png.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8)))
+
+	tests := []struct {
+		name     string
+		policy   func(board *models.Board)
+		reply    bool
+		files    map[string]string
+		message  string
+		accepted map[string]string
+	}{
+		{
+			name:     "too many files",
+			policy:   func(board *models.Board) { board.MaxFiles = 1 },
+			files:    map[string]string{"one.txt": "first of two", "two.txt": "second of two"},
+			message:  "can have at most 1 files",
+			accepted: map[string]string{"one.txt": "just one"},
+		},
+		{
+			name:     "too many files in a reply",
+			policy:   func(board *models.Board) { board.MaxFiles = 1 },
+			reply:    true,
+			files:    map[string]string{"one.txt": "first of two", "two.txt": "second of two"},
+			message:  "can have at most 1 files",
+			accepted: map[string]string{"one.txt": "just one"},
+		},
+		{
+			name:     "file too large",
+			policy:   func(board *models.Board) { board.MaxFileSize = 16 },
+			files:    map[string]string{"large.txt": "more than sixteen bytes"},
+			message:  "large.txt is larger than the 16 B limit",
+			accepted: map[string]string{"small.txt": "sixteen or less"},
+		},
+		{
+			name:     "content type not allowed",
+			policy:   func(board *models.Board) { board.AllowedContentTypes = []string{"image/*"} },
+			files:    map[string]string{"notes.txt": "not an image"},
+			message:  "notes.txt is a text/plain file, which can",
+			accepted: map[string]string{"image.png": img.String()},
+		},
+		{
+			name:     "thread without an image",
+			policy:   func(board *models.Board) { board.OpRequiresImage = true },
+			files:    map[string]string{"notes.txt": "no image here"},
+			message:  "New threads on /b/ need an image",
+			accepted: map[string]string{"image.png": img.String()},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts := newTestServer(t)
+
+			var threadId uint
+			if test.reply {
+				threadId = postId(t, ts.post("b", 0, "thread", nil))
+			}
+
+			board, err := ts.app.BoardModel.Get("b")
+			if err != nil {
+				t.Fatalf("getting the board: %s", err)
+			}
+			test.policy(&board)
+			if err := ts.app.BoardModel.Update(board); err != nil {
+				t.Fatalf("updating the board: %s", err)
+			}
+
+			resp := ts.post("b", threadId, "refused post", test.files)
+			if resp.status != http.StatusBadRequest {
+				t.Fatalf("posting returned %d, want %d", resp.status, http.StatusBadRequest)
+			}
+			if !strings.Contains(resp.body, test.message) || !strings.Contains(resp.body, "refused post") {
+				t.Error("the page doesn't show the message and the content of the refused post")
+			}
+
+			for _, content := range test.files {
+				if exists, _ := ts.store.Exists(fileKey(content), false); exists {
+					t.Errorf("the refused file %q was stored", content)
+				}
+				if _, err := ts.app.FileInfoModel.Get(fileKey(content)); err == nil {
+					t.Errorf("the refused file %q was recorded", content)
+				}
+			}
+
+			// Files within the rules are still accepted.
+			postId(t, ts.post("b", threadId, "accepted post", test.accepted))
+		})
+	}
+}
+
+func TestSpoileredUpload(t *testing.T) {
+	ts := newTestServer(t)
+
+	var spoilered, plain bytes.Buffer
+	png.Encode(&spoilered, image.NewGray(image.Rect(0, 0, 8, 8)))
+	png.Encode(&plain, image.NewGray(image.Rect(0, 0, 9, 9)))
+
+	spoileredKey := fileKey(spoilered.String())
+	plainKey := fileKey(plain.String())
+
+	captchaId, captchaCode := solveCaptcha()
+	threadId := postId(t, ts.postMultipart("/b/", map[string]string{
+		"title":        "Title",
+		"content":      "spoilered thread",
+		"spoiler":      "0",
+		"captcha-id":   captchaId,
+		"captcha-code": captchaCode,
+	}, map[string]string{"spoiler.png": spoilered.String()}))
+
+	postId(t, ts.post("b", threadId, "plain reply", map[string]string{"plain.png": plain.String()}))
+
+	thread, err := ts.app.ThreadModel.Get("b", threadId)
+	if err != nil {
+		t.Fatalf("getting the thread: %s", err)
+	}
+	if len(thread.Files) != 1 || !thread.Files[0].Spoiler {
+		t.Errorf("the thread has files %+v, want one spoilered file", thread.Files)
+	}
+
+	page := ts.get(fmt.Sprintf("/b/%d/", threadId))
+	if !strings.Contains(page.body, fmt.Sprintf(`src="/file/%s/thumb/?spoiler=1"`, spoileredKey)) {
+		t.Error("the thread page doesn't show the spoiler image for the spoilered file")
+	}
+	if strings.Contains(page.body, fmt.Sprintf(`src="/file/%s/thumb/"`, spoileredKey)) {
+		t.Error("the thread page shows the thumbnail of the spoilered file")
+	}
+	if !strings.Contains(page.body, fmt.Sprintf(`src="/file/%s/thumb/"`, plainKey)) {
+		t.Error("the thread page doesn't show the thumbnail of the file that isn't spoilered")
+	}
+
+	spoiler := ts.get(fmt.Sprintf("/file/%s/thumb/?spoiler=1", spoileredKey))
+	if spoiler.status != http.StatusOK || spoiler.header.Get("Content-Type") != "image/svg+xml" {
+		t.Errorf("the spoiler thumbnail returned %d with the type %q", spoiler.status, spoiler.header.Get("Content-Type"))
+	}
+
+	var catalog []struct {
+		ID        uint
+		Thumbnail string
+		Spoiler   bool
+	}
+	if err := json.Unmarshal([]byte(ts.get("/api/catalog/b/").body), &catalog); err != nil {
+		t.Fatalf("decoding the catalog: %s", err)
+	}
+	if len(catalog) != 1 || !catalog[0].Spoiler || catalog[0].Thumbnail != fmt.Sprintf("/file/%s/thumb/?spoiler=1", spoileredKey) {
+		t.Errorf("the catalog is %+v, want the spoilered thread with the spoiler image", catalog)
+	}
+}
+
+func TestSpoilerFiles(t *testing.T) {
+	tests := []struct {
+		values []string
+		count  int
+		want   []bool
+	}{
+		{nil, 2, []bool{false, false}},
+		{[]string{"1"}, 3, []bool{false, true, false}},
+		{[]string{"all"}, 2, []bool{true, true}},
+		{[]string{"0", "2", "5", "-1", "first"}, 3, []bool{true, false, true}},
+	}
+
+	for _, test := range tests {
+		if got := spoilerFiles(test.values, test.count); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("spoilerFiles(%q, %d) = %v, want %v", test.values, test.count, got, test.want)
+		}
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	ts := newTestServer(t)
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewGray(image.Rect(0, 0, 8, 8)))
+
+	quietId := postId(t, ts.post("b", 0, "quiet", nil))
+	busyId := postId(t, ts.post("b", 0, "busy", nil))
+	newestId := postId(t, ts.post("b", 0, "newest", nil))
+
+	postId(t, ts.post("b", busyId, "first", map[string]string{"image.png": img.String()}))
+	postId(t, ts.post("b", busyId, "second", map[string]string{"notes.txt": "catalog notes"}))
+
+	tests := []struct {
+		sort string
+		want []uint
+	}{
+		{"bump", []uint{busyId, newestId, quietId}},
+		{"created", []uint{newestId, busyId, quietId}},
+		{"replies", []uint{busyId, newestId, quietId}},
+		{"last-reply", []uint{busyId, newestId, quietId}},
+	}
+
+	for _, test := range tests {
+		resp := ts.get("/api/catalog/b/?sort=" + test.sort)
+		if resp.status != http.StatusOK {
+			t.Fatalf("getting the catalog sorted by %s returned %d", test.sort, resp.status)
+		}
+		if strings.Contains(resp.body, "PosterIP") || strings.Contains(resp.body, "127.0.0.1") {
+			t.Errorf("the catalog shows the addresses of posters")
+		}
+
+		var catalog []struct {
+			ID         uint
+			ReplyCount uint
+			ImageCount uint
+			LastReply  *time.Time
+		}
+		if err := json.Unmarshal([]byte(resp.body), &catalog); err != nil {
+			t.Fatalf("decoding the catalog: %s", err)
+		}
+
+		var got []uint
+		for _, thread := range catalog {
+			got = append(got, thread.ID)
+
+			if thread.ID == busyId && (thread.ReplyCount != 2 || thread.ImageCount != 1 || thread.LastReply == nil) {
+				t.Errorf("the busy thread has %d replies and %d images", thread.ReplyCount, thread.ImageCount)
+			}
+			if thread.ID == quietId && (thread.ReplyCount != 0 || thread.LastReply != nil) {
+				t.Errorf("the quiet thread has %d replies", thread.ReplyCount)
+			}
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("sorted by %s the catalog is %v, want %v", test.sort, got, test.want)
+		}
+	}
+
+	resp := ts.get("/b/catalog/?sort=replies")
+	if resp.status != http.StatusOK || !strings.Contains(resp.body, fmt.Sprintf(`href="/b/%d/"`, busyId)) {
+		t.Errorf("the catalog page returned %d without the threads", resp.status)
+	}
+
+	if resp := ts.get("/b/catalog/?sort=random"); resp.status != http.StatusBadRequest {
+		t.Errorf("an unknown sort returned %d", resp.status)
+	}
+	if resp := ts.get("/api/catalog/nope/"); resp.status != http.StatusNotFound {
+		t.Errorf("the catalog of an unknown board returned %d", resp.status)
+	}
+}
+
+func TestFileNotModified(t *testing.T) {
+	ts := newTestServer(t)
+
+	postId(t, ts.post("b", 0, "thread", map[string]string{"frog.txt": "frog file"}))
+	key := fileKey("frog file")
+
+	revalidate := func(path, etag string) int {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set("If-None-Match", etag)
+		return ts.do(req).status
+	}
+
+	if status := revalidate(fmt.Sprintf("/file/%s/", key), `"`+key+`"`); status != http.StatusNotModified {
+		t.Errorf("revalidating the file returned %d", status)
+	}
+
+	if err := ts.app.FileInfoModel.Delete(key); err != nil {
+		t.Fatalf("deleting the file: %s", err)
+	}
+
+	if status := revalidate(fmt.Sprintf("/file/%s/", key), `"`+key+`"`); status != http.StatusNotFound {
+		t.Errorf("revalidating a deleted file returned %d", status)
+	}
+	if status := revalidate(fmt.Sprintf("/file/%s/thumb/", key), `"`+key+`-thumb"`); status != http.StatusNotFound {
+		t.Errorf("revalidating the thumbnail of a deleted file returned %d", status)
+	}
+}
+
+func TestMalformedImage(t *testing.T) {
+	ts := newTestServer(t)
+
+	board, err := ts.app.BoardModel.Get("b")
+	if err != nil {
+		t.Fatalf("getting the board: %s", err)
+	}
+	board.StripMetadata = true
+	if err := ts.app.BoardModel.Update(board); err != nil {
+		t.Fatalf("updating the board: %s", err)
+	}
+
+	threadId := postId(t, ts.post("b", 0, "thread", nil))
+
+	// A JPEG signature followed by a segment running past the end of the file.
+	resp := ts.post("b", threadId, "broken", map[string]string{"broken.jpg": "\xFF\xD8\xFF\xE1\x10\x00Exif"})
+	if resp.status != http.StatusSeeOther || resp.location != fmt.Sprintf("/b/%d/", threadId) {
+		t.Fatalf("posting a malformed image returned %d to %q", resp.status, resp.location)
+	}
+
+	if page := ts.get(resp.location); !strings.Contains(page.body, "a valid image") {
+		t.Error("the poster isn't told the image is malformed")
+	}
+}
+
+func TestStorageScan(t *testing.T) {
+	ts := newTestServer(t)
+
+	postId(t, ts.post("b", 0, "thread", map[string]string{"frog.txt": "frog file"}))
+
+	ts.login()
+
+	resp := ts.postForm("/admin/storage/", url.Values{})
+	if resp.status != http.StatusSeeOther || resp.location != "/admin/storage/" {
+		t.Fatalf("starting the scan returned %d to %q", resp.status, resp.location)
+	}
+
+	// The scan runs in the background, the page shows its report once it's done.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		page := ts.get("/admin/storage/")
+		if strings.Contains(page.body, "Checked 1 files") {
+			if !strings.Contains(page.body, "No problems found") {
+				t.Errorf("the scan found problems: %s", page.body)
+			}
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("the scan didn't finish: %s", page.body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStorageUsage(t *testing.T) {
+	ts := newTestServer(t)
+
+	if err := ts.app.BoardModel.Insert("c", "Other", 300); err != nil {
+		t.Fatalf("creating a board: %s", err)
+	}
+
+	threadId := postId(t, ts.post("b", 0, "thread", map[string]string{"a.txt": strings.Repeat("a", 10)}))
+	// The same file posted again on a board only counts once.
+	postId(t, ts.post("b", threadId, "reply", map[string]string{"again.txt": strings.Repeat("a", 10), "b.txt": strings.Repeat("b", 20)}))
+	postId(t, ts.post("c", 0, "thread", map[string]string{"a.txt": strings.Repeat("a", 10)}))
+
+	for boardId, want := range map[string]int64{"b": 30, "c": 10, "none": 0} {
+		usage, err := ts.app.FileInfoModel.BoardUsage(boardId)
+		if err != nil {
+			t.Fatalf("BoardUsage: %s", err)
+		}
+		if usage != want {
+			t.Errorf("/%s/ uses %d bytes, want %d", boardId, usage, want)
+		}
+	}
+
+	usage, err := ts.app.FileInfoModel.StorageUsage()
+	if err != nil {
+		t.Fatalf("StorageUsage: %s", err)
+	}
+
+	want := models.StorageUsage{Boards: map[string]int64{"b": 30, "c": 10}, Total: 30}
+	if fmt.Sprint(usage) != fmt.Sprint(want) {
+		t.Errorf("StorageUsage returned %+v, want %+v", usage, want)
+	}
+}
+
+// setQuota gives board b a storage quota.
+func (ts *testServer) setQuota(quota int64, action string) {
+	ts.t.Helper()
+
+	board, err := ts.app.BoardModel.Get("b")
+	if err != nil {
+		ts.t.Fatalf("getting the board: %s", err)
+	}
+	board.StorageQuota = quota
+	board.QuotaAction = action
+	if err := ts.app.BoardModel.Update(board); err != nil {
+		ts.t.Fatalf("updating the board: %s", err)
+	}
+}
+
+func TestStorageQuotaPrune(t *testing.T) {
+	ts := newTestServer(t)
+	ts.setQuota(25, models.QuotaPrune)
+
+	oldestId := postId(t, ts.post("b", 0, "oldest", map[string]string{"1.txt": strings.Repeat("1", 10)}))
+	olderId := postId(t, ts.post("b", 0, "older", map[string]string{"2.txt": strings.Repeat("2", 10)}))
+
+	// Files larger than the whole quota are refused.
+	resp := ts.post("b", 0, "huge", map[string]string{"huge.txt": strings.Repeat("h", 30)})
+	if resp.status != http.StatusSeeOther || !strings.Contains(ts.get("/b/").body, "larger than the 25 B storage quota") {
+		t.Errorf("posting files larger than the quota returned %d", resp.status)
+	}
+
+	// Replying to the oldest thread goes over the quota, the files of the next
+	// oldest thread make room as the thread posted to is spared.
+	postId(t, ts.post("b", oldestId, "reply", map[string]string{"3.txt": strings.Repeat("3", 10)}))
+
+	files := func(threadId uint) int {
+		thread, err := ts.app.ThreadModel.Get("b", threadId)
+		if err != nil {
+			t.Fatalf("getting the thread: %s", err)
+		}
+
+		count := len(thread.Files)
+		for _, reply := range thread.Replies {
+			count += len(reply.Files)
+		}
+		return count
+	}
+
+	if got := files(oldestId); got != 2 {
+		t.Errorf("the thread replied to has %d files, want 2", got)
+	}
+	if got := files(olderId); got != 0 {
+		t.Errorf("the older thread kept %d files", got)
+	}
+
+	// A file already on the board takes no more room.
+	postId(t, ts.post("b", oldestId, "repost", map[string]string{"1.txt": strings.Repeat("1", 10)}))
+	if got := files(oldestId); got != 3 {
+		t.Errorf("the thread has %d files after a repost, want 3", got)
+	}
+
+	if usage, _ := ts.app.FileInfoModel.BoardUsage("b"); usage != 20 {
+		t.Errorf("the board uses %d bytes, want 20", usage)
+	}
+}
+
+func TestStorageQuotaReject(t *testing.T) {
+	ts := newTestServer(t)
+	ts.setQuota(15, models.QuotaReject)
+
+	threadId := postId(t, ts.post("b", 0, "thread", map[string]string{"1.txt": strings.Repeat("1", 10)}))
+
+	resp := ts.post("b", threadId, "reply", map[string]string{"2.txt": strings.Repeat("2", 10)})
+	if resp.status != http.StatusSeeOther || resp.location != fmt.Sprintf("/b/%d/", threadId) {
+		t.Fatalf("posting over the quota returned %d to %q", resp.status, resp.location)
+	}
+	if page := ts.get(resp.location); !strings.Contains(page.body, "/b/ is out of storage space") {
+		t.Error("the poster isn't told the board is out of space")
+	}
+
+	// A file already on the board still fits.
+	postId(t, ts.post("b", threadId, "repost", map[string]string{"1.txt": strings.Repeat("1", 10)}))
+}
+
+func TestGetPostJsonHidesPosterIP(t *testing.T) {
+	ts := newTestServer(t)
+
+	threadId := postId(t, ts.post("b", 0, "thread", nil))
+	replyId := postId(t, ts.post("b", threadId, "reply", nil))
+
+	for _, id := range []uint{threadId, replyId} {
+		resp := ts.get(fmt.Sprintf("/api/post/b/%d/", id))
+		if resp.status != http.StatusOK {
+			t.Fatalf("getting post %d returned %d", id, resp.status)
+		}
+
+		var post map[string]any
+		if err := json.Unmarshal([]byte(resp.body), &post); err != nil {
+			t.Fatalf("decoding post %d: %s", id, err)
+		}
+
+		if _, ok := post["PosterIP"]; ok || strings.Contains(resp.body, "127.0.0.1") {
+			t.Errorf("post %d shows the address of its poster: %s", id, resp.body)
+		}
+		if post["Content"] == nil {
+			t.Errorf("post %d has no content: %s", id, resp.body)
+		}
+	}
+}
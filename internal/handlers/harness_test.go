@@ -0,0 +1,410 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PawBer/FrogBoard/internal/models"
+	"github.com/PawBer/FrogBoard/pkg/filestorage"
+	"github.com/alexedwards/scs/v2"
+	"github.com/dchest/captcha"
+	"github.com/doug-martin/goqu/v9"
+	_ "github.com/doug-martin/goqu/v9/dialect/postgres"
+	"github.com/go-playground/form"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/lib/pq"
+)
+
+// The handler tests need Postgres. FROGBOARD_TEST_DATABASE can hold the
+// connection string of a server the tests may create databases on, like
+// "host=localhost user=postgres password=postgres sslmode=disable". Without
+// it a temporary server is started when initdb and pg_ctl are installed,
+// otherwise the tests are skipped. "just test-db" runs them against a server
+// started with docker compose. In CI, where the CI variable is set, a missing
+// database fails the tests instead so they can't be skipped unnoticed.
+var testDatabase string
+var testDatabaseError string
+
+var databaseCounter atomic.Int64
+
+// The captcha package only has one global store, the tests read the digits
+// of a captcha from it to solve it.
+var captchas = &captchaStore{digits: map[string][]byte{}}
+
+type captchaStore struct {
+	sync.Mutex
+	digits map[string][]byte
+}
+
+func (s *captchaStore) Set(id string, digits []byte) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.digits[id] = digits
+}
+
+func (s *captchaStore) Get(id string, clear bool) []byte {
+	s.Lock()
+	defer s.Unlock()
+
+	digits := s.digits[id]
+	if clear {
+		delete(s.digits, id)
+	}
+
+	return digits
+}
+
+func TestMain(m *testing.M) {
+	captcha.SetCustomStore(captchas)
+
+	testDatabase = os.Getenv("FROGBOARD_TEST_DATABASE")
+
+	var stop func()
+	if testDatabase == "" {
+		var err error
+		testDatabase, stop, err = startPostgres()
+		if err != nil {
+			testDatabaseError = err.Error()
+		}
+	}
+
+	if testDatabase == "" && os.Getenv("CI") != "" {
+		fmt.Fprintf(os.Stderr, "no database to test against in CI: %s\n", testDatabaseError)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+
+	if stop != nil {
+		stop()
+	}
+
+	os.Exit(code)
+}
+
+// startPostgres starts a throwaway Postgres server listening only on a unix
+// socket in a temporary directory.
+func startPostgres() (string, func(), error) {
+	for _, command := range []string{"initdb", "pg_ctl"} {
+		if _, err := exec.LookPath(command); err != nil {
+			return "", nil, fmt.Errorf("FROGBOARD_TEST_DATABASE isn't set and %s isn't installed", command)
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "frogboard-postgres-*")
+	if err != nil {
+		return "", nil, err
+	}
+
+	data := filepath.Join(dir, "data")
+
+	output, err := exec.Command("initdb", "-D", data, "-U", "postgres", "--auth=trust").CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("initdb failed: %s: %s", err, output)
+	}
+
+	options := fmt.Sprintf("-c listen_addresses='' -k %s", dir)
+	output, err = exec.Command("pg_ctl", "-D", data, "-o", options, "-l", filepath.Join(dir, "log"), "-w", "start").CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("starting postgres failed: %s: %s", err, output)
+	}
+
+	stop := func() {
+		exec.Command("pg_ctl", "-D", data, "-m", "immediate", "stop").Run()
+		os.RemoveAll(dir)
+	}
+
+	return fmt.Sprintf("host=%s user=postgres sslmode=disable", dir), stop, nil
+}
+
+// openTestDatabase creates an empty database for a test and migrates it.
+func openTestDatabase(t *testing.T) *goqu.Database {
+	t.Helper()
+
+	if testDatabase == "" {
+		t.Skipf("no database to test against: %s", testDatabaseError)
+	}
+
+	server, err := sql.Open("postgres", testDatabase+" dbname=postgres")
+	if err != nil {
+		t.Fatalf("connecting to postgres: %s", err)
+	}
+	defer server.Close()
+
+	name := fmt.Sprintf("frogboard_test_%d_%d", os.Getpid(), databaseCounter.Add(1))
+	if _, err := server.Exec("CREATE DATABASE " + name); err != nil {
+		t.Fatalf("creating the test database: %s", err)
+	}
+
+	dbConn, err := sql.Open("postgres", testDatabase+" dbname="+name)
+	if err != nil {
+		t.Fatalf("connecting to the test database: %s", err)
+	}
+
+	t.Cleanup(func() {
+		dbConn.Close()
+
+		server, err := sql.Open("postgres", testDatabase+" dbname=postgres")
+		if err != nil {
+			return
+		}
+		defer server.Close()
+
+		server.Exec("DROP DATABASE IF EXISTS " + name)
+	})
+
+	driver, err := postgres.WithInstance(dbConn, &postgres.Config{})
+	if err != nil {
+		t.Fatalf("setting up migrations: %s", err)
+	}
+
+	source, err := iofs.New(os.DirFS("../../cmd/frogboard/migrations"), ".")
+	if err != nil {
+		t.Fatalf("reading migrations: %s", err)
+	}
+
+	migrator, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		t.Fatalf("setting up migrations: %s", err)
+	}
+
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("migrating the test database: %s", err)
+	}
+
+	return goqu.Dialect("postgres").DB(dbConn)
+}
+
+// testServer runs an Application wired up like in main, with files kept in
+// memory and sessions in the default in memory store.
+type testServer struct {
+	*httptest.Server
+	t      *testing.T
+	app    *Application
+	store  *filestorage.MemoryFileStore
+	client *http.Client
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	db := openTestDatabase(t)
+
+	store := filestorage.NewMemoryStore(filestorage.ThumbnailPolicy{})
+	logger := log.New(io.Discard, "", 0)
+
+	bannedFileModel := &models.BannedFileModel{DbConn: db, FileStore: store}
+	bannedImageModel := &models.BannedImageModel{DbConn: db, MaxDistance: 10}
+	fileInfoModel := &models.FileInfoModel{
+		DbConn:           db,
+		FileStore:        store,
+		BannedFileModel:  bannedFileModel,
+		BannedImageModel: bannedImageModel,
+	}
+	citationModel := &models.CitationModel{DbConn: db}
+	posterIdModel := &models.PosterIDModel{DbConn: db}
+
+	replyModel := &models.ReplyModel{
+		DbConn:        db,
+		FileInfoModel: fileInfoModel,
+		CitationModel: citationModel,
+		PosterIDModel: posterIdModel,
+	}
+	threadModel := &models.ThreadModel{
+		DbConn:        db,
+		FileInfoModel: fileInfoModel,
+		CitationModel: citationModel,
+		ReplyModel:    replyModel,
+		PosterIDModel: posterIdModel,
+	}
+
+	app := &Application{
+		InfoLog:       logger,
+		ErrorLog:      logger,
+		BoardModel:    &models.BoardModel{DbConn: db},
+		ThreadModel:   threadModel,
+		ReplyModel:    replyModel,
+		FileInfoModel: fileInfoModel,
+		CitationModel: citationModel,
+		UserModel:     &models.UserModel{DbConn: db},
+		BanModel:      &models.BanModel{DbConn: db},
+		Templates:     os.DirFS("../../cmd/frogboard"),
+		Public:        os.DirFS("../../cmd/frogboard"),
+		FormDecoder:   form.NewDecoder(),
+		FileStore:     store,
+		Thumbnails:    filestorage.ThumbnailPolicy{}.WithDefaults(),
+		Sessions:      scs.New(),
+
+		BannedFileModel:  bannedFileModel,
+		BannedImageModel: bannedImageModel,
+
+		TripcodeSalt: "test salt",
+	}
+
+	if err := app.BoardModel.Insert("b", "Random", 300); err != nil {
+		t.Fatalf("creating a board: %s", err)
+	}
+
+	server := httptest.NewServer(app.GetRouter())
+	t.Cleanup(server.Close)
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Timeout: 10 * time.Second,
+	}
+
+	return &testServer{Server: server, t: t, app: app, store: store, client: client}
+}
+
+// solveCaptcha creates a captcha and returns its id and solution.
+func solveCaptcha() (string, string) {
+	id := captcha.New()
+
+	var code strings.Builder
+	for _, digit := range captchas.Get(id, false) {
+		code.WriteByte('0' + digit)
+	}
+
+	return id, code.String()
+}
+
+type response struct {
+	status   int
+	location string
+	header   http.Header
+	body     string
+}
+
+func (ts *testServer) do(req *http.Request) response {
+	ts.t.Helper()
+
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		ts.t.Fatalf("%s %s: %s", req.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		ts.t.Fatalf("%s %s: reading the body: %s", req.Method, req.URL.Path, err)
+	}
+
+	return response{status: resp.StatusCode, location: resp.Header.Get("Location"), header: resp.Header, body: string(body)}
+}
+
+func (ts *testServer) get(path string) response {
+	ts.t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+	return ts.do(req)
+}
+
+func (ts *testServer) postForm(path string, values url.Values) response {
+	ts.t.Helper()
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return ts.do(req)
+}
+
+// postMultipart posts a form like the board and thread forms do, files maps
+// file names to their content.
+func (ts *testServer) postMultipart(path string, values map[string]string, files map[string]string) response {
+	ts.t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for name, value := range values {
+		writer.WriteField(name, value)
+	}
+	for name, content := range files {
+		part, _ := writer.CreateFormFile("files", name)
+		part.Write([]byte(content))
+	}
+	writer.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+path, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return ts.do(req)
+}
+
+// post posts a thread, or a reply when threadId isn't 0, with a solved
+// captcha.
+func (ts *testServer) post(boardId string, threadId uint, content string, files map[string]string) response {
+	ts.t.Helper()
+
+	return ts.postAs(boardId, threadId, "", content, files)
+}
+
+// postAs posts like post with something in the name field.
+func (ts *testServer) postAs(boardId string, threadId uint, name, content string, files map[string]string) response {
+	ts.t.Helper()
+
+	captchaId, captchaCode := solveCaptcha()
+	values := map[string]string{
+		"title":        "Title",
+		"name":         name,
+		"content":      content,
+		"captcha-id":   captchaId,
+		"captcha-code": captchaCode,
+	}
+
+	path := fmt.Sprintf("/%s/", boardId)
+	if threadId != 0 {
+		path = fmt.Sprintf("/%s/%d/", boardId, threadId)
+	}
+
+	return ts.postMultipart(path, values, files)
+}
+
+// login logs in as a new admin.
+func (ts *testServer) login() {
+	ts.t.Helper()
+
+	password, err := ts.app.UserModel.RegisterUser("admin", "Admin", models.Admin)
+	if err != nil {
+		ts.t.Fatalf("registering a user: %s", err)
+	}
+
+	resp := ts.postForm("/login/", url.Values{"username": {"admin"}, "password": {password}})
+	if resp.status != http.StatusSeeOther || resp.location != "/" {
+		ts.t.Fatalf("logging in returned %d to %q", resp.status, resp.location)
+	}
+}
+
+// ban bans the address the test client connects from.
+func (ts *testServer) ban(reason string, duration time.Duration) {
+	ts.t.Helper()
+
+	err := ts.app.BanModel.BanUser(net.ParseIP("127.0.0.1"), time.Now().UTC().Add(duration), reason)
+	if err != nil {
+		ts.t.Fatalf("banning: %s", err)
+	}
+}
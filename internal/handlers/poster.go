@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/PawBer/FrogBoard/internal/models"
+	"github.com/PawBer/FrogBoard/pkg/tripcode"
+)
+
+const maxNameLength = 64
+
+// maxContentLength keeps posts to a size that's quick to render and store.
+const maxContentLength = 10000
+
+// posterName splits the name field of a post into the name and tripcode that
+// are stored with it. The name is kept in the session so the post forms can
+// fill it in again, the tripcode password isn't. It returns a message for the
+// poster when the name can't be used.
+func (app *Application) posterName(r *http.Request, board models.Board, field string) (string, string, string) {
+	displayName, _, _ := strings.Cut(field, "#")
+	app.Sessions.Put(r.Context(), "poster-name", displayName)
+
+	if board.ForceAnonymous {
+		return "", "", ""
+	}
+
+	name, trip, err := tripcode.Parse(field, app.TripcodeSalt)
+	if errors.Is(err, tripcode.ErrNoSecureSalt) {
+		return "", "", "Secure tripcodes aren't enabled on this server"
+	}
+
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return "", "", fmt.Sprintf("Names can be at most %d characters long", maxNameLength)
+	}
+
+	return name, trip, ""
+}
+
+// contentTooLong returns a message for the poster when the content of a post
+// is longer than maxContentLength.
+func contentTooLong(content string) string {
+	if utf8.RuneCountInString(content) > maxContentLength {
+		return fmt.Sprintf("Posts can be at most %d characters long", maxContentLength)
+	}
+
+	return ""
+}
+
+// postOptions are read from the options field of the reply form, which takes
+// the usual "sage", "noko" and "nonoko" like the e-mail field of other
+// imageboards.
+type postOptions struct {
+	// Sage replies don't bump the thread.
+	Sage bool
+	// NoNoko sends the poster back to the board instead of the thread.
+	NoNoko bool
+}
+
+func parsePostOptions(field string) postOptions {
+	var options postOptions
+
+	for _, option := range strings.FieldsFunc(strings.ToLower(field), func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		switch option {
+		case "sage":
+			options.Sage = true
+		case "noko":
+			options.NoNoko = false
+		case "nonoko":
+			options.NoNoko = true
+		case "nokosage":
+			options.Sage = true
+			options.NoNoko = false
+		case "nonokosage":
+			options.Sage = true
+			options.NoNoko = true
+		}
+	}
+
+	return options
+}
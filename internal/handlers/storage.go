@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/PawBer/FrogBoard/internal/models"
+	"github.com/PawBer/FrogBoard/pkg/filestorage"
+)
+
+type storageReportSection struct {
+	Title string
+	Keys  []string
+}
+
+// storageScan is the storage verification started from the admin pages. It
+// reads the whole store, so it runs in the background and the storage page
+// shows its progress and the report of the last scan.
+type storageScan struct {
+	sync.Mutex
+	storageScanStatus
+}
+
+type storageScanStatus struct {
+	Running  bool
+	Done     int
+	Total    int
+	Started  time.Time
+	Finished time.Time
+	Report   *filestorage.VerifyReport
+	Error    string
+}
+
+func (app *Application) renderStorage(w http.ResponseWriter, r *http.Request) {
+	requiredTemplates := []string{"storage"}
+
+	tmpl, err := app.createTemplate(requiredTemplates, r)
+	if err != nil {
+		log.Fatalf("Failed to load templates: %s", err.Error())
+	}
+
+	templateData, err := app.getTemplateData(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.storageScan.Lock()
+	scan := app.storageScan.storageScanStatus
+	app.storageScan.Unlock()
+
+	templateData["Scan"] = &scan
+
+	if report := scan.Report; report != nil {
+		templateData["Report"] = report
+		templateData["Sections"] = []storageReportSection{
+			{"Missing files", report.Missing},
+			{"Corrupt files", report.Corrupt},
+			{"Untracked files", report.Untracked},
+			{"Missing thumbnails", report.MissingThumbnails},
+			{"Videos without thumbnails, ffmpeg isn't installed", report.UnthumbnailedVideos},
+			{"Thumbnails without a file", report.UntrackedThumbnails},
+			{"Regenerated thumbnails", report.RegeneratedThumbnails},
+			{"Quarantined files", report.Quarantined},
+			{"Failures", report.Failures},
+		}
+	}
+
+	err = tmpl.ExecuteTemplate(w, "base", &templateData)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+}
+
+func (app *Application) GetStorage(w http.ResponseWriter, r *http.Request) {
+	if !app.hasPermission(r, models.Admin) {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	app.renderStorage(w, r)
+}
+
+func (app *Application) PostStorage(w http.ResponseWriter, r *http.Request) {
+	if !app.hasPermission(r, models.Admin) {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	formModel := struct {
+		RegenerateThumbnails bool `form:"regenerate-thumbnails"`
+		Quarantine           bool `form:"quarantine"`
+	}{}
+
+	r.ParseForm()
+	err := app.FormDecoder.Decode(&formModel, r.Form)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.storageScan.Lock()
+	defer app.storageScan.Unlock()
+
+	if app.storageScan.Running {
+		app.Sessions.Put(r.Context(), "flash", "The storage is already being verified")
+		http.Redirect(w, r, "/admin/storage/", http.StatusSeeOther)
+		return
+	}
+
+	app.storageScan.Running = true
+	app.storageScan.Done, app.storageScan.Total = 0, 0
+	app.storageScan.Started = time.Now().UTC()
+
+	options := filestorage.VerifyOptions{
+		RegenerateThumbnails: formModel.RegenerateThumbnails,
+		Quarantine:           formModel.Quarantine,
+		Progress: func(done, total int, key string) {
+			app.storageScan.Lock()
+			app.storageScan.Done, app.storageScan.Total = done, total
+			app.storageScan.Unlock()
+		},
+	}
+
+	go func() {
+		report, err := app.FileInfoModel.VerifyStorage(options)
+		if err != nil {
+			app.ErrorLog.Printf("Verifying the storage: %s", err)
+		} else {
+			app.InfoLog.Printf("Verified the storage, checked %d files", report.Checked)
+		}
+
+		app.storageScan.Lock()
+		defer app.storageScan.Unlock()
+
+		app.storageScan.Running = false
+		app.storageScan.Finished = time.Now().UTC()
+		app.storageScan.Report, app.storageScan.Error = nil, ""
+		if err != nil {
+			app.storageScan.Error = err.Error()
+		} else {
+			app.storageScan.Report = &report
+		}
+	}()
+
+	app.Sessions.Put(r.Context(), "flash", "Verifying the storage, reload this page to follow its progress")
+	http.Redirect(w, r, "/admin/storage/", http.StatusSeeOther)
+}
@@ -4,13 +4,13 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"net/http"
 	"strconv"
 
 	"github.com/PawBer/FrogBoard/internal/models"
+	"github.com/PawBer/FrogBoard/pkg/sanitize"
 	"github.com/dchest/captcha"
 	"github.com/go-chi/chi/v5"
 )
@@ -52,9 +52,20 @@ func (app *Application) GetPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	boards := templateData["Boards"].([]models.Board)
+	var board models.Board
+
+	for _, v := range boards {
+		if v.ID == boardId {
+			board = v
+		}
+	}
+
+	templateData["Board"] = board
 	templateData["BoardID"] = boardId
 	templateData["Thread"] = thread
 	templateData["CaptchaID"] = captchaId
+	templateData["FormName"] = app.Sessions.GetString(r.Context(), "poster-name")
 
 	if app.Sessions.Exists(r.Context(), "form-content") {
 		templateData["FormContent"] = app.Sessions.PopString(r.Context(), "form-content")
@@ -74,13 +85,56 @@ func (app *Application) PostThread(w http.ResponseWriter, r *http.Request) {
 	threadIdStr := chi.URLParam(r, "postId")
 	threadId, _ := strconv.ParseUint(threadIdStr, 10, 32)
 
+	board, err := app.BoardModel.Get(boardId)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	locked, err := app.ThreadModel.IsLocked(boardId, uint(threadId))
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if locked {
+		app.Sessions.Put(r.Context(), "flash", "This thread is locked")
+
+		url := fmt.Sprintf("/%s/%d/", boardId, threadId)
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
+
 	formModel := struct {
+		Name        string `form:"name"`
+		Options     string `form:"options"`
 		Content     string `form:"content"`
 		CaptchaId   string `form:"captcha-id"`
 		CaptchaCode string `form:"captcha-code"`
 	}{}
 
-	err := r.ParseMultipartForm(32 << 20)
+	maxPostSize := board.MaxPostSize()
+	if maxPostSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxPostSize)
+	}
+
+	err = r.ParseMultipartForm(32 << 20)
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		app.Sessions.Put(r.Context(), "flash", fmt.Sprintf("Posts on /%s/ can't be larger than %s", boardId, models.FormatSize(maxPostSize)))
+
+		url := fmt.Sprintf("/%s/%d/", boardId, threadId)
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -102,11 +156,51 @@ func (app *Application) PostThread(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var fileInfos []models.FileInfo
+	// The content isn't put back in the form, it's too long to keep in the
+	// session.
+	if message := contentTooLong(formModel.Content); message != "" {
+		app.Sessions.Put(r.Context(), "flash", message)
+
+		url := fmt.Sprintf("/%s/%d/", boardId, threadId)
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
+
+	name, tripcode, message := app.posterName(r, board, formModel.Name)
+	if message != "" {
+		app.Sessions.Put(r.Context(), "flash", message)
+
+		app.Sessions.Put(r.Context(), "form-content", formModel.Content)
+
+		url := fmt.Sprintf("/%s/%d/", boardId, threadId)
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
 
 	files := r.MultipartForm.File["files"]
 
-	for _, fileHeader := range files {
+	message, err = checkUploads(board, files, false)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if message != "" {
+		app.Sessions.Put(r.Context(), "flash", message)
+
+		app.Sessions.Put(r.Context(), "form-content", formModel.Content)
+
+		// The files break the rules of the board, the page is shown again
+		// with the message instead of redirecting so the status says so.
+		w.WriteHeader(http.StatusBadRequest)
+		app.GetPost(w, r)
+		return
+	}
+
+	spoilers := spoilerFiles(r.MultipartForm.Value["spoiler"], len(files))
+
+	var fileInfos []models.FileInfo
+
+	for i, fileHeader := range files {
 		file, err := fileHeader.Open()
 		if err != nil {
 			app.serverError(w, err)
@@ -114,28 +208,129 @@ func (app *Application) PostThread(w http.ResponseWriter, r *http.Request) {
 		}
 		defer file.Close()
 
-		buf, err := io.ReadAll(file)
-		if err != nil {
-			app.serverError(w, err)
+		fileInfo, err := app.FileInfoModel.InsertFile(board, fileHeader.Filename, file)
+		message, banErr := app.rejectBannedUpload(r, err)
+		if banErr != nil {
+			app.serverError(w, banErr)
 			return
 		}
+		if errors.Is(err, sanitize.ErrMalformedImage) {
+			message = fmt.Sprintf("%s isn't a valid image", fileHeader.Filename)
+		}
+		if message != "" {
+			app.Sessions.Put(r.Context(), "flash", message)
 
-		fileInfo, err := app.FileInfoModel.InsertFile(fileHeader.Filename, buf)
+			app.Sessions.Put(r.Context(), "form-content", formModel.Content)
+
+			url := fmt.Sprintf("/%s/%d/", boardId, threadId)
+			http.Redirect(w, r, url, http.StatusSeeOther)
+			return
+		}
 		if err != nil {
 			app.serverError(w, err)
 			return
 		}
 
+		fileInfo.Spoiler = spoilers[i]
 		fileInfos = append(fileInfos, fileInfo)
 	}
 
+	message, err = app.checkQuota(board, fileInfos)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if message != "" {
+		app.Sessions.Put(r.Context(), "flash", message)
+
+		app.Sessions.Put(r.Context(), "form-content", formModel.Content)
+
+		url := fmt.Sprintf("/%s/%d/", boardId, threadId)
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
+
+	options := parsePostOptions(formModel.Options)
+
 	host, _, _ := net.SplitHostPort(r.RemoteAddr)
-	postId, err := app.ReplyModel.Insert(boardId, uint(threadId), formModel.Content, fileInfos, host)
+	postId, err := app.ReplyModel.Insert(boardId, uint(threadId), name, tripcode, formModel.Content, options.Sage, fileInfos, host)
+	if err != nil && errors.Is(err, models.ErrThreadLocked) {
+		app.Sessions.Put(r.Context(), "flash", "This thread is locked")
+
+		app.Sessions.Put(r.Context(), "form-content", formModel.Content)
+
+		url := fmt.Sprintf("/%s/%d/", boardId, threadId)
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	url := fmt.Sprintf("/%s/%d/#p%d", boardId, postId, postId)
+	if len(fileInfos) != 0 {
+		app.pruneForQuota(board, uint(threadId))
+	}
+
+	if options.NoNoko {
+		url := fmt.Sprintf("/%s/", boardId)
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	url := fmt.Sprintf("/%s/%d/#p%d", boardId, threadId, postId)
 	http.Redirect(w, r, url, http.StatusFound)
 }
+
+func (app *Application) PostThreadFlag(w http.ResponseWriter, r *http.Request) {
+	boardId := chi.URLParam(r, "boardId")
+	threadIdStr := chi.URLParam(r, "postId")
+	threadId, _ := strconv.ParseUint(threadIdStr, 10, 32)
+
+	formModel := struct {
+		Flag  string `form:"flag"`
+		Value bool   `form:"value"`
+	}{}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.FormDecoder.Decode(&formModel, r.PostForm)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	valid := false
+	for _, flag := range models.ThreadFlags {
+		if flag == formModel.Flag {
+			valid = true
+		}
+	}
+	if !valid {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.ThreadModel.SetFlag(boardId, uint(threadId), formModel.Flag, formModel.Value)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		app.notFound(w)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	message := fmt.Sprintf("The thread is now %s", formModel.Flag)
+	if !formModel.Value {
+		message = fmt.Sprintf("The thread is no longer %s", formModel.Flag)
+	}
+	app.Sessions.Put(r.Context(), "flash", message)
+
+	url := fmt.Sprintf("/%s/%d/", boardId, threadId)
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/PawBer/FrogBoard/internal/models"
+)
+
+func sniffContentType(fileHeader *multipart.FileHeader) (string, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	return http.DetectContentType(header[:n]), nil
+}
+
+// checkUploads validates the files of a post against the upload settings of
+// the board before any of them is stored. It returns the message shown to the
+// poster when the post is refused, or an empty string when the files are fine.
+func checkUploads(board models.Board, files []*multipart.FileHeader, isThread bool) (string, error) {
+	if board.MaxFiles > 0 && len(files) > board.MaxFiles {
+		return fmt.Sprintf("Posts on /%s/ can have at most %d files", board.ID, board.MaxFiles), nil
+	}
+
+	hasImage := false
+	for _, fileHeader := range files {
+		if board.MaxFileSize > 0 && fileHeader.Size > board.MaxFileSize {
+			return fmt.Sprintf("%s is larger than the %s limit of /%s/", fileHeader.Filename, models.FormatSize(board.MaxFileSize), board.ID), nil
+		}
+
+		contentType, err := sniffContentType(fileHeader)
+		if err != nil {
+			return "", err
+		}
+
+		if !board.AllowsContentType(contentType) {
+			mediaType, _, _ := strings.Cut(contentType, ";")
+			return fmt.Sprintf("%s is a %s file, which can't be posted on /%s/", fileHeader.Filename, mediaType, board.ID), nil
+		}
+
+		if strings.HasPrefix(contentType, "image/") {
+			hasImage = true
+		}
+	}
+
+	if isThread && board.OpRequiresImage && !hasImage {
+		return fmt.Sprintf("New threads on /%s/ need an image", board.ID), nil
+	}
+
+	return "", nil
+}
+
+// checkQuota refuses the stored files of a post when they don't fit in the
+// storage quota of the board. Files already posted on the board don't count
+// again. Boards that prune only refuse files larger than the whole quota, room
+// is made for the others once the post is in, see pruneForQuota. Refused files
+// are left unreferenced for CollectGarbage to remove.
+func (app *Application) checkQuota(board models.Board, fileInfos []models.FileInfo) (string, error) {
+	if board.StorageQuota == 0 || len(fileInfos) == 0 {
+		return "", nil
+	}
+
+	var fileIds []string
+	for _, fileInfo := range fileInfos {
+		fileIds = append(fileIds, fileInfo.ID)
+	}
+
+	incoming, err := app.FileInfoModel.AddedUsage(board.ID, fileIds)
+	if err != nil {
+		return "", err
+	}
+
+	if incoming > board.StorageQuota {
+		return fmt.Sprintf("These files are larger than the %s storage quota of /%s/", models.FormatSize(board.StorageQuota), board.ID), nil
+	}
+
+	if board.QuotaAction == models.QuotaPrune {
+		return "", nil
+	}
+
+	usage, err := app.FileInfoModel.BoardUsage(board.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if usage+incoming > board.StorageQuota {
+		return fmt.Sprintf("/%s/ is out of storage space", board.ID), nil
+	}
+
+	return "", nil
+}
+
+// pruneForQuota removes the files of the oldest threads of a board that a new
+// post took over its storage quota, sparing the thread posted to. The post is
+// in either way, so failures are only logged.
+func (app *Application) pruneForQuota(board models.Board, threadId uint) {
+	if board.StorageQuota == 0 || board.QuotaAction != models.QuotaPrune {
+		return
+	}
+
+	pruned, err := app.FileInfoModel.PruneBoard(board.ID, board.StorageQuota, threadId)
+	if err != nil {
+		app.ErrorLog.Printf("Pruning the files of /%s/: %s", board.ID, err)
+		return
+	}
+
+	if pruned > 0 {
+		app.InfoLog.Printf("Removed the files of %d threads on /%s/ to stay within its storage quota", pruned, board.ID)
+	}
+}
+
+// spoilerFiles reads which of the uploaded files the poster marked as spoilers.
+// The post form sends the index of each marked file, or "all" when the
+// per-file checkboxes aren't available.
+func spoilerFiles(values []string, count int) []bool {
+	spoilers := make([]bool, count)
+
+	for _, value := range values {
+		if value == "all" {
+			for i := range spoilers {
+				spoilers[i] = true
+			}
+			continue
+		}
+
+		i, err := strconv.Atoi(value)
+		if err != nil || i < 0 || i >= count {
+			continue
+		}
+
+		spoilers[i] = true
+	}
+
+	return spoilers
+}
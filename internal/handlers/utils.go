@@ -69,6 +69,10 @@ func (app *Application) getFuncs(r *http.Request) template.FuncMap {
 		"GetPermission": func() int {
 			return app.Sessions.Get(r.Context(), "permission").(int)
 		},
+		"ThumbnailStyle": func(postType string) template.CSS {
+			width, height := app.Thumbnails.Box(postType)
+			return template.CSS(fmt.Sprintf("max-width: min(35vw, %dpx); max-height: %dpx;", width, height))
+		},
 	}
 }
 
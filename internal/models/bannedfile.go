@@ -0,0 +1,141 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/PawBer/FrogBoard/pkg/filestorage"
+	"github.com/doug-martin/goqu/v9"
+)
+
+// BannedFile is a file that can't be uploaded again, matched by its key.
+type BannedFile struct {
+	FileID    string
+	Reason    string
+	CreatedAt time.Time
+}
+
+// BannedFileError is returned by InsertFile for uploads of a banned file.
+type BannedFileError struct {
+	File BannedFile
+}
+
+func (e BannedFileError) Error() string {
+	return fmt.Sprintf("file %s is banned", e.File.FileID)
+}
+
+type BannedFileModel struct {
+	DbConn    *goqu.Database
+	FileStore filestorage.FileStore
+}
+
+func (m *BannedFileModel) Get(fileId string) (BannedFile, error) {
+	var file BannedFile
+
+	query, params, _ := goqu.From("banned_files").Select("file_id", "reason", "created_at").Where(goqu.Ex{
+		"file_id": fileId,
+	}).ToSQL()
+
+	err := m.DbConn.QueryRow(query, params...).Scan(&file.FileID, &file.Reason, &file.CreatedAt)
+	if err != nil {
+		return BannedFile{}, err
+	}
+
+	return file, nil
+}
+
+// IsBanned reports whether a file is banned, with the ban when it is.
+func (m *BannedFileModel) IsBanned(fileId string) (bool, BannedFile, error) {
+	file, err := m.Get(fileId)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return false, BannedFile{}, nil
+	}
+	if err != nil {
+		return false, BannedFile{}, err
+	}
+
+	return true, file, nil
+}
+
+func (m *BannedFileModel) GetAll() ([]BannedFile, error) {
+	var files []BannedFile
+
+	query, params, _ := goqu.From("banned_files").Select("file_id", "reason", "created_at").Order(goqu.I("created_at").Desc()).ToSQL()
+
+	rows, err := m.DbConn.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	var file BannedFile
+	for rows.Next() {
+		err := rows.Scan(&file.FileID, &file.Reason, &file.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// Ban bans a file, removes it from every post using it and deletes it from
+// the store.
+func (m *BannedFileModel) Ban(fileId, reason string) error {
+	query, params, _ := goqu.Insert("banned_files").Rows(goqu.Record{
+		"file_id":    fileId,
+		"reason":     reason,
+		"created_at": time.Now().UTC(),
+	}).ToSQL()
+
+	tx, err := m.DbConn.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(query+" ON CONFLICT (file_id) DO UPDATE SET reason = EXCLUDED.reason", params...)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = deletePostFiles(tx, goqu.Ex{"file_id": fileId})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	query, params, _ = goqu.Delete("file_infos").Where(goqu.Ex{
+		"id": fileId,
+	}).ToSQL()
+
+	_, err = tx.Exec(query, params...)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = m.FileStore.DeleteFiles(fileId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *BannedFileModel) Unban(fileId string) error {
+	query, params, _ := goqu.Delete("banned_files").Where(goqu.Ex{
+		"file_id": fileId,
+	}).ToSQL()
+
+	_, err := m.DbConn.Exec(query, params...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
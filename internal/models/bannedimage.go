@@ -0,0 +1,115 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+)
+
+// BannedImage is an image on the blocklist. Uploads whose perceptual hash is
+// close to its hash are rejected, which catches re-encoded and resized copies.
+type BannedImage struct {
+	ID        uint
+	FileID    string
+	PHash     uint64
+	Reason    string
+	CreatedAt time.Time
+}
+
+// BannedImageError is returned by InsertFile for uploads matching a banned image.
+type BannedImageError struct {
+	Image BannedImage
+}
+
+func (e BannedImageError) Error() string {
+	return fmt.Sprintf("upload matches banned image %d", e.Image.ID)
+}
+
+type BannedImageModel struct {
+	DbConn *goqu.Database
+	// MaxDistance is how many bits of the perceptual hashes may differ for an
+	// upload to match a banned image.
+	MaxDistance int
+}
+
+func (m *BannedImageModel) GetAll() ([]BannedImage, error) {
+	var images []BannedImage
+
+	query, params, _ := goqu.From("banned_images").Select("id", "file_id", "phash", "reason", "created_at").Order(goqu.I("created_at").Desc()).ToSQL()
+
+	rows, err := m.DbConn.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	var image BannedImage
+	var phash int64
+	for rows.Next() {
+		err := rows.Scan(&image.ID, &image.FileID, &phash, &image.Reason, &image.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		image.PHash = uint64(phash)
+
+		images = append(images, image)
+	}
+
+	return images, nil
+}
+
+// Match returns the banned image closest to the perceptual hash, if any is
+// within MaxDistance of it. The distances are worked out by the database, so
+// the blocklist isn't loaded for every upload.
+func (m *BannedImageModel) Match(phash uint64) (BannedImage, bool, error) {
+	distance := goqu.L("bit_count((phash # ?)::bit(64))", int64(phash))
+
+	query, params, _ := goqu.From("banned_images").Select("id", "file_id", "phash", "reason", "created_at").Where(
+		distance.Lte(m.MaxDistance),
+	).Order(distance.Asc(), goqu.I("id").Asc()).Limit(1).ToSQL()
+
+	var image BannedImage
+	var imagePHash int64
+
+	err := m.DbConn.QueryRow(query, params...).Scan(&image.ID, &image.FileID, &imagePHash, &image.Reason, &image.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return BannedImage{}, false, nil
+	}
+	if err != nil {
+		return BannedImage{}, false, err
+	}
+	image.PHash = uint64(imagePHash)
+
+	return image, true, nil
+}
+
+func (m *BannedImageModel) Insert(fileId string, phash uint64, reason string) error {
+	query, params, _ := goqu.Insert("banned_images").Rows(goqu.Record{
+		"file_id":    fileId,
+		"phash":      int64(phash),
+		"reason":     reason,
+		"created_at": time.Now().UTC(),
+	}).ToSQL()
+
+	_, err := m.DbConn.Exec(query, params...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *BannedImageModel) Delete(id uint) error {
+	query, params, _ := goqu.Delete("banned_images").Where(goqu.Ex{
+		"id": id,
+	}).ToSQL()
+
+	_, err := m.DbConn.Exec(query, params...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
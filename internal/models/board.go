@@ -2,15 +2,169 @@ package models
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
+	"unicode"
 
 	"github.com/doug-martin/goqu/v9"
 )
 
 type Board struct {
-	ID         string
-	FullName   string
-	LastPostID uint
-	BumpLimit  uint
+	ID             string
+	FullName       string
+	LastPostID     uint
+	BumpLimit      uint
+	StripMetadata  bool
+	ReencodeImages bool
+
+	// AllowedContentTypes lists the content types files posted to the board
+	// can have, like "image/png" or "video/*". Any type is allowed when it's empty.
+	AllowedContentTypes []string
+	// MaxFileSize is the largest file in bytes that can be posted, 0 means no limit.
+	MaxFileSize int64
+	// MaxFiles is the number of files a post can have, 0 means no limit.
+	MaxFiles        int
+	OpRequiresImage bool
+
+	// StorageQuota caps the size in bytes of the files posted on the board,
+	// 0 means no limit. QuotaAction decides what happens to posts that don't
+	// fit anymore.
+	StorageQuota int64
+	QuotaAction  string
+
+	// ForceAnonymous hides the names and tripcodes posters give.
+	ForceAnonymous bool
+	// PosterIDs shows an ID in posts that tells posters apart within a thread.
+	PosterIDs bool
+
+	// MaxPages is the number of pages of threads the board keeps, 0 means no
+	// limit. PruneAction decides what happens to threads pushed off the last
+	// page.
+	MaxPages    uint
+	PruneAction string
+	// ArchiveDays is the number of days archived threads are kept before
+	// they're deleted, 0 keeps them forever.
+	ArchiveDays uint
+}
+
+const (
+	// QuotaReject refuses files that don't fit in the storage quota.
+	QuotaReject = "reject"
+	// QuotaPrune removes the files of the oldest threads to make room.
+	QuotaPrune = "prune"
+)
+
+const (
+	// PruneDelete deletes threads pushed off the last page.
+	PruneDelete = "delete"
+	// PruneArchive moves threads pushed off the last page to the archive.
+	PruneArchive = "archive"
+)
+
+// ThreadsPerPage is the number of threads on a page of a board.
+const ThreadsPerPage = 10
+
+// ArchivedThreadsPerPage is the number of threads on a page of the archive of
+// a board.
+const ArchivedThreadsPerPage = 50
+
+// MaxThreads is the number of threads the board keeps, 0 means no limit.
+func (b Board) MaxThreads() uint {
+	return b.MaxPages * ThreadsPerPage
+}
+
+// ParseContentTypes splits a comma or whitespace separated list of content types.
+func ParseContentTypes(list string) []string {
+	var contentTypes []string
+
+	for _, contentType := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		contentTypes = append(contentTypes, strings.ToLower(contentType))
+	}
+
+	return contentTypes
+}
+
+// Accept returns the allowed content types in the format of the accept attribute of file inputs.
+func (b Board) Accept() string {
+	return strings.Join(b.AllowedContentTypes, ",")
+}
+
+// AllowsContentType reports whether files with the content type can be posted on the board.
+func (b Board) AllowsContentType(contentType string) bool {
+	if len(b.AllowedContentTypes) == 0 {
+		return true
+	}
+
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	category, _, _ := strings.Cut(mediaType, "/")
+
+	for _, allowed := range b.AllowedContentTypes {
+		if allowed == mediaType || allowed == category+"/*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MaxPostSize is the largest request body a post can have on the board, 0 means no limit.
+func (b Board) MaxPostSize() int64 {
+	if b.MaxFileSize == 0 || b.MaxFiles == 0 {
+		return 0
+	}
+
+	// Leave room for the text fields and the multipart framing.
+	return b.MaxFileSize*int64(b.MaxFiles) + 1<<20
+}
+
+// MaxFileSizeKiB is MaxFileSize in the unit of the board edit form.
+func (b Board) MaxFileSizeKiB() int64 {
+	return b.MaxFileSize >> 10
+}
+
+// StorageQuotaMiB is StorageQuota in the unit of the board edit form.
+func (b Board) StorageQuotaMiB() int64 {
+	return b.StorageQuota >> 20
+}
+
+// UploadLimits describes the upload settings of the board for the post forms.
+func (b Board) UploadLimits() string {
+	var limits []string
+
+	if b.MaxFiles > 0 {
+		limits = append(limits, fmt.Sprintf("up to %d files", b.MaxFiles))
+	}
+
+	if b.MaxFileSize > 0 {
+		limits = append(limits, fmt.Sprintf("%s per file", FormatSize(b.MaxFileSize)))
+	}
+
+	if len(limits) == 0 {
+		return ""
+	}
+
+	limits[0] = strings.ToUpper(limits[0][:1]) + limits[0][1:]
+
+	return strings.Join(limits, ", ")
+}
+
+// FormatSize formats a byte count for people, like "4 MiB".
+func FormatSize(size int64) string {
+	switch {
+	case size >= 1<<30 && size%(1<<30) == 0:
+		return fmt.Sprintf("%d GiB", size>>30)
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(size)/(1<<30))
+	case size >= 1<<20 && size%(1<<20) == 0:
+		return fmt.Sprintf("%d MiB", size>>20)
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%d KiB", size>>10)
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
 }
 
 type BoardModel struct {
@@ -18,25 +172,44 @@ type BoardModel struct {
 	ThreadModel *ThreadModel
 }
 
+// boardColumns are the columns scanned by scanBoard, in order.
+var boardColumns = []interface{}{
+	"id", "full_name", "last_post_id", "bump_limit", "strip_metadata", "reencode_images",
+	"allowed_content_types", "max_file_size", "max_files", "op_requires_image",
+	"storage_quota", "quota_action", "force_anonymous", "poster_ids",
+	"max_pages", "prune_action", "archive_days",
+}
+
+func scanBoard(row interface{ Scan(...any) error }) (Board, error) {
+	var board Board
+	var allowedContentTypes string
+
+	err := row.Scan(&board.ID, &board.FullName, &board.LastPostID, &board.BumpLimit, &board.StripMetadata, &board.ReencodeImages,
+		&allowedContentTypes, &board.MaxFileSize, &board.MaxFiles, &board.OpRequiresImage,
+		&board.StorageQuota, &board.QuotaAction, &board.ForceAnonymous, &board.PosterIDs,
+		&board.MaxPages, &board.PruneAction, &board.ArchiveDays)
+	if err != nil {
+		return Board{}, err
+	}
+
+	board.AllowedContentTypes = ParseContentTypes(allowedContentTypes)
+
+	return board, nil
+}
+
 func (m *BoardModel) GetBoards() ([]Board, error) {
 	var boards []Board
 
-	sql, params, _ := m.DbConn.From("boards").Select("id", "full_name", "last_post_id", "bump_limit").ToSQL()
+	sql, params, _ := m.DbConn.From("boards").Select(boardColumns...).ToSQL()
 	rows, err := m.DbConn.Query(sql, params...)
 	if err != nil {
 		return nil, err
 	}
 
-	var id, fullName string
-	var lastPostId, bumpLimit int
 	for rows.Next() {
-
-		rows.Scan(&id, &fullName, &lastPostId, &bumpLimit)
-		board := Board{
-			ID:         id,
-			FullName:   fullName,
-			LastPostID: uint(lastPostId),
-			BumpLimit:  uint(bumpLimit),
+		board, err := scanBoard(rows)
+		if err != nil {
+			return nil, err
 		}
 
 		boards = append(boards, board)
@@ -45,6 +218,14 @@ func (m *BoardModel) GetBoards() ([]Board, error) {
 	return boards, nil
 }
 
+func (m *BoardModel) Get(id string) (Board, error) {
+	query, params, _ := m.DbConn.From("boards").Select(boardColumns...).Where(goqu.Ex{
+		"id": id,
+	}).ToSQL()
+
+	return scanBoard(m.DbConn.QueryRow(query, params...))
+}
+
 func (m *BoardModel) Insert(id string, name string, bumpLimit uint) error {
 	query, params, _ := goqu.Insert("boards").Rows(
 		goqu.Record{"id": id, "full_name": name, "last_post_id": 0, "bump_limit": bumpLimit},
@@ -158,12 +339,10 @@ func (m *BoardModel) Delete(id string) error {
 		return err
 	}
 
-	query, params, _ = goqu.Delete("post_files").Where(goqu.Ex{
+	err = deletePostFiles(tx, goqu.Ex{
 		"board_id": id,
 		"post_id":  ids,
-	}).ToSQL()
-
-	_, err = tx.Exec(query, params...)
+	})
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -180,6 +359,13 @@ func (m *BoardModel) Delete(id string) error {
 		return err
 	}
 
+	// Posts on other boards citing the board's posts now cite dead posts.
+	err = killCitations(tx, goqu.Ex{"cited_board_id": id})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	tx.Commit()
 
 	return nil
@@ -187,8 +373,25 @@ func (m *BoardModel) Delete(id string) error {
 
 func (m *BoardModel) Update(board Board) error {
 	sql, params, _ := goqu.Update("boards").Set(goqu.Record{
-		"full_name":  board.FullName,
-		"bump_limit": board.BumpLimit,
+		"full_name":       board.FullName,
+		"bump_limit":      board.BumpLimit,
+		"strip_metadata":  board.StripMetadata,
+		"reencode_images": board.ReencodeImages,
+
+		"allowed_content_types": board.Accept(),
+		"max_file_size":         board.MaxFileSize,
+		"max_files":             board.MaxFiles,
+		"op_requires_image":     board.OpRequiresImage,
+
+		"storage_quota": board.StorageQuota,
+		"quota_action":  board.QuotaAction,
+
+		"force_anonymous": board.ForceAnonymous,
+		"poster_ids":      board.PosterIDs,
+
+		"max_pages":    board.MaxPages,
+		"prune_action": board.PruneAction,
+		"archive_days": board.ArchiveDays,
 	}).Where(goqu.Ex{"id": board.ID}).ToSQL()
 
 	_, err := m.DbConn.Exec(sql, params...)
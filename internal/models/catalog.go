@@ -0,0 +1,192 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+)
+
+// The orders the catalog of a board can be sorted in.
+const (
+	CatalogByBump      = "bump"
+	CatalogByCreation  = "created"
+	CatalogByReplies   = "replies"
+	CatalogByLastReply = "last-reply"
+)
+
+// CatalogSorts lists the orders of the catalog, the first one is the default.
+var CatalogSorts = []string{CatalogByBump, CatalogByCreation, CatalogByReplies, CatalogByLastReply}
+
+// CatalogThread is a thread as shown in the catalog of its board, only the
+// opening post is loaded.
+type CatalogThread struct {
+	*Thread
+	ReplyCount uint
+	// ImageCount counts the images and videos posted in the replies.
+	ImageCount uint
+	LastBump   time.Time
+	// LastReply is when the last reply was made, it's zero for threads
+	// without replies.
+	LastReply time.Time
+}
+
+// Thumbnail is the first file of the opening post, the catalog shows its
+// thumbnail. It's nil for threads without files.
+func (t CatalogThread) Thumbnail() *FileInfo {
+	if len(t.Files) == 0 {
+		return nil
+	}
+
+	return &t.Files[0]
+}
+
+// GetCatalog lists every thread of a board that isn't archived, sorted by
+// one of CatalogSorts. Sticky threads come first in bump order, like on the
+// board.
+func (m *ThreadModel) GetCatalog(boardId, sortBy string) ([]*CatalogThread, error) {
+	var threads []*CatalogThread
+
+	query, params, _ := goqu.From("threads").Select("id", "board_id", "created_at", "content", "title", "last_bump", "locked", "sticky", "cyclical").Where(goqu.Ex{
+		"board_id":    boardId,
+		"archived_at": nil,
+	}).Order(goqu.I("sticky").Desc(), goqu.I("last_bump").Desc()).ToSQL()
+
+	rows, err := m.DbConn.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	byId := map[uint]*CatalogThread{}
+	for rows.Next() {
+		thread := &CatalogThread{Thread: &Thread{}}
+
+		err := rows.Scan(&thread.ID, &thread.BoardID, &thread.CreatedAt, &thread.Content, &thread.Title, &thread.LastBump, &thread.Locked, &thread.Sticky, &thread.Cyclical)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		threads = append(threads, thread)
+		byId[thread.ID] = thread
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(threads) == 0 {
+		return threads, nil
+	}
+
+	query, params, _ = goqu.From("replies").Select("thread_id", goqu.COUNT("*"), goqu.MAX("created_at")).Where(goqu.Ex{
+		"board_id": boardId,
+	}).GroupBy("thread_id").ToSQL()
+
+	rows, err = m.DbConn.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var threadId, replyCount uint
+		var lastReply time.Time
+
+		err := rows.Scan(&threadId, &replyCount, &lastReply)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if thread, ok := byId[threadId]; ok {
+			thread.ReplyCount = replyCount
+			thread.LastReply = lastReply
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query, params, _ = goqu.From("post_files").Select("replies.thread_id", goqu.COUNT("*")).Join(
+		goqu.T("replies"),
+		goqu.On(goqu.Ex{
+			"replies.board_id": goqu.I("post_files.board_id"),
+			"replies.id":       goqu.I("post_files.post_id"),
+		}),
+	).Join(
+		goqu.T("file_infos"),
+		goqu.On(goqu.Ex{"file_infos.id": goqu.I("post_files.file_id")}),
+	).Where(
+		goqu.Ex{"post_files.board_id": boardId},
+		goqu.Or(
+			goqu.I("file_infos.content_type").Like("image/%"),
+			goqu.I("file_infos.content_type").Like("video/%"),
+		),
+	).GroupBy("replies.thread_id").ToSQL()
+
+	rows, err = m.DbConn.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var threadId, imageCount uint
+
+		err := rows.Scan(&threadId, &imageCount)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if thread, ok := byId[threadId]; ok {
+			thread.ImageCount = imageCount
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var posts []*Post
+	for _, thread := range threads {
+		posts = append(posts, &thread.Post)
+	}
+
+	err = m.FileInfoModel.GetFilesForPosts(boardId, posts...)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	sortCatalog(threads, sortBy)
+
+	return threads, nil
+}
+
+// sortCatalog sorts the threads of a catalog, which are in bump order. Ties
+// keep the newest thread first.
+func sortCatalog(threads []*CatalogThread, sortBy string) {
+	var less func(a, b *CatalogThread) bool
+
+	switch sortBy {
+	case CatalogByCreation:
+		less = func(a, b *CatalogThread) bool { return a.CreatedAt.After(b.CreatedAt) }
+	case CatalogByReplies:
+		less = func(a, b *CatalogThread) bool { return a.ReplyCount > b.ReplyCount }
+	case CatalogByLastReply:
+		less = func(a, b *CatalogThread) bool { return a.LastReply.After(b.LastReply) }
+	default:
+		return
+	}
+
+	sort.SliceStable(threads, func(i, j int) bool {
+		a, b := threads[i], threads[j]
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+
+		return a.ID > b.ID
+	})
+}
@@ -1,19 +1,35 @@
 package models
 
 import (
+	"database/sql"
+	"errors"
+
+	"github.com/PawBer/FrogBoard/pkg/markup"
 	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
 )
 
+// Citation is a link from the post PostID on BoardID to the post Cites on
+// CitedBoardID, which is another board for citations like ">>>/b/123".
 type Citation struct {
-	BoardID string
-	PostID  uint
-	Cites   uint
+	BoardID      string
+	PostID       uint
+	CitedBoardID string
+	Cites        uint
+}
+
+// CrossBoard tells if the citing post is on another board than the post it
+// cites.
+func (c Citation) CrossBoard() bool {
+	return c.BoardID != c.CitedBoardID
 }
 
 type CitationModel struct {
 	DbConn *goqu.Database
 }
 
+// GetCitationsForPosts sets the citations of posts on a board, including
+// those from posts on other boards.
 func (cm *CitationModel) GetCitationsForPosts(boardId string, posts ...*Post) error {
 	var ids []uint
 
@@ -25,19 +41,20 @@ func (cm *CitationModel) GetCitationsForPosts(boardId string, posts ...*Post) er
 		return nil
 	}
 
-	sql, params, _ := goqu.From("citations").Select("board_id", "post_id", "cites").Where(goqu.Ex{
-		"board_id": boardId,
-		"cites":    ids,
-	}).ToSQL()
+	sql, params, _ := goqu.From("citations").Select("board_id", "post_id", "cited_board_id", "cites").Where(goqu.Ex{
+		"cited_board_id": boardId,
+		"cites":          ids,
+	}).Order(goqu.I("id").Asc()).ToSQL()
 
 	rows, err := cm.DbConn.Query(sql, params...)
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
 
 	var citation Citation
 	for rows.Next() {
-		err = rows.Scan(&citation.BoardID, &citation.PostID, &citation.Cites)
+		err = rows.Scan(&citation.BoardID, &citation.PostID, &citation.CitedBoardID, &citation.Cites)
 		if err != nil {
 			return err
 		}
@@ -49,5 +66,174 @@ func (cm *CitationModel) GetCitationsForPosts(boardId string, posts ...*Post) er
 		}
 	}
 
+	return rows.Err()
+}
+
+type postKey struct {
+	boardId string
+	postId  uint
+}
+
+// existingPosts finds which of the posts cited exist.
+func existingPosts(tx *goqu.TxDatabase, citations []Citation) (map[postKey]bool, error) {
+	existing := map[postKey]bool{}
+	if len(citations) == 0 {
+		return existing, nil
+	}
+
+	cited := map[string][]uint{}
+	for _, citation := range citations {
+		cited[citation.CitedBoardID] = append(cited[citation.CitedBoardID], citation.Cites)
+	}
+
+	var conditions []exp.Expression
+	for boardId, ids := range cited {
+		conditions = append(conditions, goqu.Ex{"board_id": boardId, "id": ids})
+	}
+
+	query, params, _ := goqu.From("threads").Select("board_id", "id").Where(goqu.Or(conditions...)).UnionAll(
+		goqu.From("replies").Select("board_id", "id").Where(goqu.Or(conditions...)),
+	).ToSQL()
+
+	rows, err := tx.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var post postKey
+
+		err := rows.Scan(&post.boardId, &post.postId)
+		if err != nil {
+			return nil, err
+		}
+
+		existing[post] = true
+	}
+
+	return existing, rows.Err()
+}
+
+// renderPost renders the content of a post, linking the cited posts that
+// exist and striking through the others. It returns the citations of posts
+// that exist.
+func renderPost(tx *goqu.TxDatabase, boardId string, postId uint, content string) (string, []Citation, error) {
+	citations := GetCitations(boardId, postId, content)
+
+	existing, err := existingPosts(tx, citations)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var live []Citation
+	for _, citation := range citations {
+		if existing[postKey{citation.CitedBoardID, citation.Cites}] {
+			live = append(live, citation)
+		}
+	}
+
+	html := markup.Render(content, markup.Options{
+		BoardID: boardId,
+		Exists: func(boardId string, postId uint) bool {
+			return existing[postKey{boardId, postId}]
+		},
+	})
+
+	return html, live, nil
+}
+
+// insertCitations stores the citations of a new post.
+func insertCitations(tx *goqu.TxDatabase, citations []Citation) error {
+	if len(citations) == 0 {
+		return nil
+	}
+
+	var records []goqu.Record
+
+	for _, citation := range citations {
+		record := goqu.Record{
+			"board_id":       citation.BoardID,
+			"post_id":        citation.PostID,
+			"cited_board_id": citation.CitedBoardID,
+			"cites":          citation.Cites,
+		}
+
+		records = append(records, record)
+	}
+
+	query, params, _ := goqu.Insert("citations").Rows(records).ToSQL()
+
+	_, err := tx.Exec(query, params...)
+	return err
+}
+
+// killCitations is called when posts are deleted, with a condition matching
+// the citations of them. The posts citing them are rendered again so the
+// citations are shown as dead, and the citations are removed.
+func killCitations(tx *goqu.TxDatabase, cited goqu.Ex) error {
+	query, params, _ := goqu.Delete("citations").Where(cited).ToSQL()
+
+	rows, err := tx.Query(query+" RETURNING board_id, post_id", params...)
+	if err != nil {
+		return err
+	}
+
+	citing := map[postKey]bool{}
+	for rows.Next() {
+		var post postKey
+
+		err := rows.Scan(&post.boardId, &post.postId)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		citing[post] = true
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for post := range citing {
+		err := rerenderPost(tx, post.boardId, post.postId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rerenderPost renders the content of a thread or reply again, it does
+// nothing when the post is gone.
+func rerenderPost(tx *goqu.TxDatabase, boardId string, postId uint) error {
+	for _, table := range []string{"threads", "replies"} {
+		where := goqu.Ex{"board_id": boardId, "id": postId}
+
+		query, params, _ := goqu.From(table).Select("content").Where(where).ToSQL()
+
+		var content string
+		err := tx.QueryRow(query, params...).Scan(&content)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		html, _, err := renderPost(tx, boardId, postId, content)
+		if err != nil {
+			return err
+		}
+
+		query, params, _ = goqu.Update(table).Set(goqu.Record{"content_html": html}).Where(where).ToSQL()
+
+		_, err = tx.Exec(query, params...)
+		return err
+	}
+
 	return nil
 }
@@ -1,10 +1,17 @@
 package models
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/PawBer/FrogBoard/pkg/filestorage"
+	"github.com/PawBer/FrogBoard/pkg/sanitize"
 	"github.com/doug-martin/goqu/v9"
 )
 
@@ -12,17 +19,121 @@ type FileInfo struct {
 	ID          string
 	Name        string
 	ContentType string
+	Width       int
+	Height      int
+	Duration    time.Duration
+	// Size is the size of the file in bytes.
+	Size int64
+	// Spoiler hides the thumbnail of the file behind the spoiler image in the
+	// post it's attached to. It only changes how the post is shown, the file
+	// and its thumbnail stay available to anyone with their URL.
+	Spoiler bool
 }
 
 type FileInfoModel struct {
 	DbConn    *goqu.Database
 	FileStore filestorage.FileStore
+	// BannedFileModel and BannedImageModel, when set, reject uploads of
+	// banned files and uploads matching the image blocklist.
+	BannedFileModel  *BannedFileModel
+	BannedImageModel *BannedImageModel
 }
 
+var ErrNoPHash = errors.New("file is not an image or video with a perceptual hash")
+
 func (fi FileInfo) ContainsImage() bool {
 	return strings.Contains(fi.ContentType, "image")
 }
 
+func (fi FileInfo) ContainsVideo() bool {
+	return strings.HasPrefix(fi.ContentType, "video/")
+}
+
+// MediaDescription formats the known dimensions and duration of the file, like "1280x720, 1:05".
+func (fi FileInfo) MediaDescription() string {
+	var parts []string
+
+	if fi.Width != 0 && fi.Height != 0 {
+		parts = append(parts, fmt.Sprintf("%dx%d", fi.Width, fi.Height))
+	}
+
+	if fi.Duration != 0 {
+		seconds := int(fi.Duration.Round(time.Second).Seconds())
+		if seconds >= 3600 {
+			parts = append(parts, fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds%3600/60, seconds%60))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d:%02d", seconds/60, seconds%60))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func (fiModel *FileInfoModel) Get(fileId string) (FileInfo, error) {
+	var fileInfo FileInfo
+
+	query, params, _ := goqu.From("file_infos").Select("id", "content_type", "width", "height", "duration_ms").Where(goqu.Ex{
+		"id": fileId,
+	}).ToSQL()
+
+	var durationMs int64
+	err := fiModel.DbConn.QueryRow(query, params...).Scan(&fileInfo.ID, &fileInfo.ContentType, &fileInfo.Width, &fileInfo.Height, &durationMs)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	fileInfo.Duration = time.Duration(durationMs) * time.Millisecond
+
+	return fileInfo, nil
+}
+
+func (fiModel *FileInfoModel) GetFileIDs() ([]string, error) {
+	var fileIds []string
+
+	query, params, _ := goqu.From("file_infos").Select("id").Order(goqu.I("id").Asc()).ToSQL()
+
+	rows, err := fiModel.DbConn.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileId string
+	for rows.Next() {
+		err := rows.Scan(&fileId)
+		if err != nil {
+			return nil, err
+		}
+
+		fileIds = append(fileIds, fileId)
+	}
+
+	return fileIds, nil
+}
+
+// VerifyStorage checks the file store against the tracked files, see filestorage.Verify.
+func (fiModel *FileInfoModel) VerifyStorage(options filestorage.VerifyOptions) (filestorage.VerifyReport, error) {
+	query, params, _ := goqu.From("file_infos").Select("id", "content_type").ToSQL()
+
+	rows, err := fiModel.DbConn.Query(query, params...)
+	if err != nil {
+		return filestorage.VerifyReport{}, err
+	}
+
+	tracked := map[string]string{}
+
+	var fileId, contentType string
+	for rows.Next() {
+		err := rows.Scan(&fileId, &contentType)
+		if err != nil {
+			return filestorage.VerifyReport{}, err
+		}
+
+		tracked[fileId] = contentType
+	}
+
+	return filestorage.Verify(fiModel.FileStore, tracked, options)
+}
+
 func (fiModel *FileInfoModel) GetFilesForPosts(boardId string, posts ...*Post) error {
 	var ids []uint
 
@@ -34,7 +145,7 @@ func (fiModel *FileInfoModel) GetFilesForPosts(boardId string, posts ...*Post) e
 		return nil
 	}
 
-	query, params, _ := fiModel.DbConn.From("post_files").Select("post_id", "file_id", "file_name", "content_type").Where(goqu.Ex{
+	query, params, _ := fiModel.DbConn.From("post_files").Select("post_id", "file_id", "file_name", "content_type", "width", "height", "duration_ms", "spoiler").Where(goqu.Ex{
 		"board_id": boardId,
 		"post_id":  ids,
 	}).LeftJoin(
@@ -49,15 +160,28 @@ func (fiModel *FileInfoModel) GetFilesForPosts(boardId string, posts ...*Post) e
 
 	var postId uint
 	var fileId, fileName, contentType string
+	var width, height int
+	var durationMs int64
+	var spoiler bool
 	for rows.Next() {
-		err = rows.Scan(&postId, &fileId, &fileName, &contentType)
+		err = rows.Scan(&postId, &fileId, &fileName, &contentType, &width, &height, &durationMs, &spoiler)
 		if err != nil {
 			return err
 		}
 
+		fileInfo := FileInfo{
+			ID:          fileId,
+			Name:        fileName,
+			ContentType: contentType,
+			Width:       width,
+			Height:      height,
+			Duration:    time.Duration(durationMs) * time.Millisecond,
+			Spoiler:     spoiler,
+		}
+
 		for _, post := range posts {
 			if postId == post.ID {
-				post.Files = append(post.Files, FileInfo{ID: fileId, Name: fileName, ContentType: contentType})
+				post.Files = append(post.Files, fileInfo)
 			}
 		}
 	}
@@ -99,22 +223,155 @@ func (fiModel *FileInfoModel) GetLatestFiles() ([]map[string]interface{}, error)
 	return fileInfos, nil
 }
 
-func (fiModel *FileInfoModel) InsertFile(fileName string, file []byte) (FileInfo, error) {
-	contentType := http.DetectContentType(file)
+// InsertFile stores an upload to the board, stripping metadata from images
+// first when the board asks for it. The file's key is the hash of what ends up
+// stored, after any stripping. Banned files and images matching the blocklist
+// are refused with a BannedFileError or BannedImageError before anything is
+// stored.
+func (fiModel *FileInfoModel) InsertFile(board Board, fileName string, file io.ReadSeeker) (FileInfo, error) {
+	header := make([]byte, 512)
+
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return FileInfo{}, err
+	}
+
+	contentType := http.DetectContentType(header[:n])
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return FileInfo{}, err
+	}
+
+	var upload io.Reader = file
+
+	if board.StripMetadata && strings.Contains(contentType, "image") {
+		upload, err = sanitize.Image(file, board.ReencodeImages)
+		if err != nil {
+			return FileInfo{}, err
+		}
+	}
+
+	counter := &countingReader{reader: upload}
 
-	key, err := fiModel.FileStore.AddFile(file)
+	details, err := fiModel.FileStore.AddFile(counter, fiModel.checkUpload)
 	if err != nil {
 		return FileInfo{}, err
 	}
 
-	query, params, _ := fiModel.DbConn.Insert("file_infos").Rows(goqu.Record{"id": key, "content_type": contentType}).ToSQL()
+	var phash interface{}
+	if details.HasPHash {
+		phash = int64(details.PHash)
+	}
+
+	// The file is unreferenced until the post using it is inserted, refreshing
+	// unreferenced_at keeps CollectGarbage away from it in the meantime.
+	query, params, _ := fiModel.DbConn.Insert("file_infos").Rows(goqu.Record{
+		"id":              details.Key,
+		"content_type":    contentType,
+		"width":           details.Width,
+		"height":          details.Height,
+		"duration_ms":     details.Duration.Milliseconds(),
+		"unreferenced_at": goqu.L("NOW()"),
+		"phash":           phash,
+		"size":            counter.count,
+	}).ToSQL()
 
-	_, err = fiModel.DbConn.Exec(query+" ON CONFLICT (id) DO NOTHING", params...)
+	var inserted bool
+	err = fiModel.DbConn.QueryRow(query+" ON CONFLICT (id) DO UPDATE SET unreferenced_at = NOW(), phash = COALESCE(file_infos.phash, EXCLUDED.phash), size = COALESCE(file_infos.size, EXCLUDED.size) RETURNING (xmax = 0)", params...).Scan(&inserted)
 	if err != nil {
 		return FileInfo{}, err
 	}
 
-	return FileInfo{ID: key, Name: fileName, ContentType: contentType}, nil
+	if inserted {
+		// A garbage collection of the same file may have finished between
+		// storing the file and recording it.
+		stored, err := fiModel.FileStore.GetFile(details.Key)
+		if err != nil {
+			return FileInfo{}, fmt.Errorf("file %s was removed while it was uploaded: %w", details.Key, err)
+		}
+		stored.Close()
+	}
+
+	fileInfo := FileInfo{
+		ID:          details.Key,
+		Name:        fileName,
+		ContentType: contentType,
+		Width:       details.Width,
+		Height:      details.Height,
+		Duration:    details.Duration,
+		Size:        counter.count,
+	}
+
+	return fileInfo, nil
+}
+
+// checkUpload refuses banned files and files matching the image blocklist
+// before they are stored.
+func (fiModel *FileInfoModel) checkUpload(details filestorage.FileDetails) error {
+	if fiModel.BannedFileModel != nil {
+		banned, bannedFile, err := fiModel.BannedFileModel.IsBanned(details.Key)
+		if err != nil {
+			return err
+		}
+		if banned {
+			return BannedFileError{File: bannedFile}
+		}
+	}
+
+	if details.HasPHash && fiModel.BannedImageModel != nil {
+		image, banned, err := fiModel.BannedImageModel.Match(details.PHash)
+		if err != nil {
+			return err
+		}
+		if banned {
+			return BannedImageError{Image: image}
+		}
+	}
+
+	return nil
+}
+
+// GetPHash returns the perceptual hash of a file. Files uploaded before hashes
+// were recorded are hashed from the file store.
+func (fiModel *FileInfoModel) GetPHash(fileId string) (uint64, error) {
+	query, params, _ := goqu.From("file_infos").Select("phash").Where(goqu.Ex{
+		"id": fileId,
+	}).ToSQL()
+
+	var phash sql.NullInt64
+	err := fiModel.DbConn.QueryRow(query, params...).Scan(&phash)
+	if err != nil {
+		return 0, err
+	}
+
+	if phash.Valid {
+		return uint64(phash.Int64), nil
+	}
+
+	file, err := fiModel.FileStore.GetFile(fileId)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	hash, ok, err := filestorage.PerceptualHash(file)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrNoPHash
+	}
+
+	query, params, _ = goqu.Update("file_infos").Set(goqu.Record{
+		"phash": int64(hash),
+	}).Where(goqu.Ex{"id": fileId}).ToSQL()
+
+	_, err = fiModel.DbConn.Exec(query, params...)
+	if err != nil {
+		return 0, err
+	}
+
+	return hash, nil
 }
 
 func (fiModel *FileInfoModel) Delete(fileId string) error {
@@ -153,67 +410,167 @@ func (fiModel *FileInfoModel) Delete(fileId string) error {
 	return nil
 }
 
-func (fiModel *FileInfoModel) DeleteOrphanedFiles() error {
-	query, params, _ := goqu.From("post_files").Select("file_id").ToSQL()
+// CollectGarbage removes the files that no post has used for at least the
+// grace period and returns how many were removed. Uploads refresh the grace
+// period of the file, so a file is never removed while a post is being made
+// with it.
+func (fiModel *FileInfoModel) CollectGarbage(gracePeriod time.Duration) (int, error) {
+	query, params, _ := goqu.Delete("file_infos").Where(
+		goqu.C("ref_count").Lte(0),
+		goqu.L("unreferenced_at < NOW() - ? * INTERVAL '1 second'", int64(gracePeriod.Seconds())),
+	).ToSQL()
 
 	tx, err := fiModel.DbConn.Begin()
 	if err != nil {
-		tx.Rollback()
-		return err
+		return 0, err
 	}
 
-	rows, err := tx.Query(query, params...)
+	// The deleted rows stay locked until the files are gone, an upload of the
+	// same file waits for the commit and then records the file again.
+	rows, err := tx.Query(query+" RETURNING id", params...)
 	if err != nil {
 		tx.Rollback()
-		return err
+		return 0, err
 	}
 
-	fileIdsMap := map[string]bool{}
+	var fileIds []string
 
 	var fileId string
 	for rows.Next() {
 		err := rows.Scan(&fileId)
 		if err != nil {
 			tx.Rollback()
-			return err
+			return 0, err
+		}
+
+		fileIds = append(fileIds, fileId)
+	}
+	if err := rows.Err(); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if len(fileIds) == 0 {
+		tx.Rollback()
+		return 0, nil
+	}
+
+	err = fiModel.FileStore.DeleteFiles(fileIds...)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(fileIds), nil
+}
+
+// countRefs counts how many times each file is used. The IDs are returned
+// sorted so concurrent transactions lock the file_infos rows in the same order.
+func countRefs(fileIds []string) ([]string, map[string]int) {
+	refs := map[string]int{}
+	var ids []string
+
+	for _, id := range fileIds {
+		if refs[id] == 0 {
+			ids = append(ids, id)
 		}
+		refs[id]++
+	}
+
+	sort.Strings(ids)
+
+	return ids, refs
+}
 
-		fileIdsMap[fileId] = true
+// insertPostFiles records the files of a post and the references they gain.
+func insertPostFiles(tx *goqu.TxDatabase, boardId string, postId uint, files []FileInfo) error {
+	if len(files) == 0 {
+		return nil
 	}
 
+	var records []goqu.Record
 	var fileIds []string
-	for id := range fileIdsMap {
-		fileIds = append(fileIds, id)
+
+	for _, file := range files {
+		record := goqu.Record{
+			"board_id":  boardId,
+			"post_id":   postId,
+			"file_id":   file.ID,
+			"file_name": file.Name,
+			"spoiler":   file.Spoiler,
+		}
+
+		records = append(records, record)
+		fileIds = append(fileIds, file.ID)
 	}
 
-	query, params, _ = goqu.Delete("file_infos").Where(goqu.Ex{
-		"id": goqu.Op{"notIn": fileIds},
-	}).ToSQL()
+	query, params, _ := goqu.Insert("post_files").Rows(records).ToSQL()
 
-	rows, err = tx.Query(query+" RETURNING id", params...)
+	_, err := tx.Exec(query, params...)
+	if err != nil {
+		return err
+	}
+
+	ids, refs := countRefs(fileIds)
+	for _, id := range ids {
+		query, params, _ := goqu.Update("file_infos").Set(goqu.Record{
+			"ref_count":       goqu.L("ref_count + ?", refs[id]),
+			"unreferenced_at": nil,
+		}).Where(goqu.Ex{"id": id}).ToSQL()
+
+		_, err := tx.Exec(query, params...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deletePostFiles removes the post_files rows matching where and releases the
+// references they held. Files left without references are removed later by
+// CollectGarbage.
+func deletePostFiles(tx *goqu.TxDatabase, where goqu.Ex) error {
+	query, params, _ := goqu.Delete("post_files").Where(where).ToSQL()
+
+	rows, err := tx.Query(query+" RETURNING file_id", params...)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
-	var deletedFileIds []string
+	var fileIds []string
 
+	var fileId string
 	for rows.Next() {
 		err := rows.Scan(&fileId)
 		if err != nil {
-			tx.Rollback()
+			rows.Close()
 			return err
 		}
 
-		deletedFileIds = append(deletedFileIds, fileId)
+		fileIds = append(fileIds, fileId)
 	}
-
-	err = fiModel.FileStore.DeleteFiles(deletedFileIds...)
-	if err != nil {
-		tx.Rollback()
+	if err := rows.Err(); err != nil {
 		return err
 	}
 
-	tx.Commit()
+	ids, refs := countRefs(fileIds)
+	for _, id := range ids {
+		query, params, _ := goqu.Update("file_infos").Set(goqu.Record{
+			"ref_count":       goqu.L("GREATEST(ref_count - ?, 0)", refs[id]),
+			"unreferenced_at": goqu.L("CASE WHEN ref_count <= ? THEN NOW() ELSE unreferenced_at END", refs[id]),
+		}).Where(goqu.Ex{"id": id}).ToSQL()
+
+		_, err := tx.Exec(query, params...)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCountRefs(t *testing.T) {
+	ids, refs := countRefs([]string{"c", "a", "c", "b", "c", "a"})
+
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("countRefs returned the IDs %v, want %v", ids, want)
+	}
+	if want := map[string]int{"a": 2, "b": 1, "c": 3}; !reflect.DeepEqual(refs, want) {
+		t.Errorf("countRefs counted %v, want %v", refs, want)
+	}
+
+	if ids, refs := countRefs(nil); len(ids) != 0 || len(refs) != 0 {
+		t.Errorf("countRefs of no files returned %v, %v", ids, refs)
+	}
+}
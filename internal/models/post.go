@@ -1,14 +1,14 @@
 package models
 
 import (
-	"fmt"
-	"html"
 	"html/template"
 	"net"
-	"regexp"
-	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
+
+	"github.com/PawBer/FrogBoard/pkg/markup"
+	"github.com/doug-martin/goqu/v9"
 )
 
 type Post struct {
@@ -16,57 +16,83 @@ type Post struct {
 	BoardID   string
 	CreatedAt time.Time
 	Content   string
+	// ContentHTML caches the rendered content, it's empty for posts made
+	// before it was added.
+	ContentHTML string `json:"-"`
+	// Name and Tripcode are empty for anonymous posts, the tripcode starts
+	// with "!" or "!!" for secure tripcodes.
+	Name     string
+	Tripcode string
+	// PosterID tells the posters of a thread apart on boards that show IDs.
+	PosterID string
+	// Sage replies don't bump their thread, threads are never sage.
+	Sage      bool
 	Files     []FileInfo
 	Citations []Citation
-	PosterIP  net.IP
+	// PosterIP is only for moderators, it's never part of the JSON API.
+	PosterIP net.IP `json:"-"`
 }
 
-var PostCitationRegex = regexp.MustCompile("&gt;&gt; ([0-9]+)")
-var GreentextRegex = regexp.MustCompile("&gt;.+")
-
 func (p Post) FormatCreationDate() template.HTML {
 	return template.HTML(p.CreatedAt.UTC().Format("2006-01-02T15:04:05-0700"))
 }
 
+// PosterName is the name shown in the header of the post.
+func (p Post) PosterName() string {
+	if p.Name == "" {
+		return "Anonymous"
+	}
+
+	return p.Name
+}
+
+// Snippet is the start of the content of the post on a single line, for
+// listings of threads.
+func (p Post) Snippet() string {
+	const length = 120
+
+	content := strings.Join(strings.Fields(p.Content), " ")
+	if utf8.RuneCountInString(content) <= length {
+		return content
+	}
+
+	return strings.TrimSpace(string([]rune(content)[:length])) + "…"
+}
+
 func (p Post) FileCount() int {
 	return len(p.Files)
 }
 
+// FormatedContent is the content of the post rendered to HTML. It's rendered
+// when the post is made and kept in ContentHTML, older posts are rendered
+// when they're shown.
 func (p Post) FormatedContent() template.HTML {
-	citationLink := fmt.Sprintf(`<a data-post="$1" class="post-link text-blue-500" href="/%s/$1/">>> $1</a>`, p.BoardID)
-	afterCitations := PostCitationRegex.ReplaceAllString(html.EscapeString(p.Content), citationLink)
-
-	var formatedLines []string
-	lines := strings.Split(afterCitations, "\r\n")
-	for _, line := range lines {
-		var newLine string
-
-		if GreentextRegex.MatchString(line) {
-			newLine = `<span class="text-green-600">` + line + `</span><br>`
-		} else if line == "" {
-			newLine = `<br><br>`
-		} else {
-			newLine = line + `<br>`
-		}
-
-		formatedLines = append(formatedLines, newLine)
+	if p.ContentHTML != "" {
+		return template.HTML(p.ContentHTML)
 	}
 
-	return template.HTML(strings.Join(formatedLines, ""))
+	return template.HTML(renderContent(p.BoardID, p.Content))
 }
 
+func renderContent(boardId, content string) string {
+	return markup.Render(content, markup.Options{BoardID: boardId})
+}
+
+// GetCitations finds the posts cited in the content of a post, both on its
+// own board and on other boards.
 func GetCitations(boardId string, postId uint, content string) []Citation {
 	var citations []Citation
 
-	matches := PostCitationRegex.FindAllStringSubmatch(html.EscapeString(content), -1)
-
-	for _, match := range matches {
-		citationId, _ := strconv.ParseInt(match[1], 10, 32)
-
+	for _, cited := range markup.Citations(content) {
 		citation := Citation{
-			BoardID: boardId,
-			PostID:  postId,
-			Cites:   uint(citationId),
+			BoardID:      boardId,
+			PostID:       postId,
+			CitedBoardID: cited.BoardID,
+			Cites:        cited.PostID,
+		}
+
+		if citation.CitedBoardID == "" {
+			citation.CitedBoardID = boardId
 		}
 
 		citations = append(citations, citation)
@@ -74,3 +100,30 @@ func GetCitations(boardId string, postId uint, content string) []Citation {
 
 	return citations
 }
+
+// deletePostData removes what belonged to deleted posts: their files, their
+// citations and the citations of them.
+func deletePostData(tx *goqu.TxDatabase, boardId string, ids []uint) error {
+	err := deletePostFiles(tx, goqu.Ex{
+		"board_id": boardId,
+		"post_id":  ids,
+	})
+	if err != nil {
+		return err
+	}
+
+	query, params, _ := goqu.Delete("citations").Where(goqu.Ex{
+		"board_id": boardId,
+		"post_id":  ids,
+	}).ToSQL()
+
+	_, err = tx.Exec(query, params...)
+	if err != nil {
+		return err
+	}
+
+	return killCitations(tx, goqu.Ex{
+		"cited_board_id": boardId,
+		"cites":          ids,
+	})
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sync"
+
+	"github.com/doug-martin/goqu/v9"
+)
+
+// PosterIDModel gives posts on boards with poster IDs an ID that is the same
+// for the posts of one address within a thread. The IDs are keyed with a
+// random salt for every day, so the address can't be found by trying them
+// all and a poster gets a new ID the next day.
+type PosterIDModel struct {
+	DbConn *goqu.Database
+
+	mu    sync.Mutex
+	salts map[string][]byte
+}
+
+// daySalt returns the salt of a day, creating it the first time it's needed.
+func (m *PosterIDModel) daySalt(day string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if salt, ok := m.salts[day]; ok {
+		return salt, nil
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	query, params, _ := goqu.Insert("poster_id_salts").Rows(goqu.Record{
+		"day":  day,
+		"salt": salt,
+	}).ToSQL()
+
+	_, err := m.DbConn.Exec(query+" ON CONFLICT DO NOTHING", params...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Another process may have created the salt first.
+	query, params, _ = goqu.From("poster_id_salts").Select("salt").Where(goqu.Ex{"day": day}).ToSQL()
+
+	err = m.DbConn.QueryRow(query, params...).Scan(&salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.salts == nil {
+		m.salts = map[string][]byte{}
+	}
+	m.salts[day] = salt
+
+	return salt, nil
+}
+
+func (m *PosterIDModel) posterID(post *Post, threadId uint) error {
+	salt, err := m.daySalt(post.CreatedAt.UTC().Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, salt)
+	fmt.Fprintf(mac, "%s\x00%s\x00%d", post.PosterIP, post.BoardID, threadId)
+
+	post.PosterID = base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:8]
+
+	return nil
+}
+
+func (m *PosterIDModel) enabled(boardId string) (bool, error) {
+	query, params, _ := goqu.From("boards").Select("poster_ids").Where(goqu.Ex{"id": boardId}).ToSQL()
+
+	var enabled bool
+	err := m.DbConn.QueryRow(query, params...).Scan(&enabled)
+	if err != nil {
+		return false, err
+	}
+
+	return enabled, nil
+}
+
+// SetThreadPosterIDs sets the poster IDs of threads and their replies when
+// the board shows them.
+func (m *PosterIDModel) SetThreadPosterIDs(boardId string, threads ...*Thread) error {
+	enabled, err := m.enabled(boardId)
+	if err != nil || !enabled {
+		return err
+	}
+
+	for _, thread := range threads {
+		err := m.posterID(&thread.Post, thread.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, reply := range thread.Replies {
+			err := m.posterID(&reply.Post, thread.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// SetReplyPosterIDs sets the poster IDs of replies when the board shows them.
+func (m *PosterIDModel) SetReplyPosterIDs(boardId string, replies ...*Reply) error {
+	enabled, err := m.enabled(boardId)
+	if err != nil || !enabled {
+		return err
+	}
+
+	for _, reply := range replies {
+		err := m.posterID(&reply.Post, reply.ThreadID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -18,6 +18,7 @@ type ReplyModel struct {
 	DbConn        *goqu.Database
 	FileInfoModel *FileInfoModel
 	CitationModel *CitationModel
+	PosterIDModel *PosterIDModel
 }
 
 func (t Reply) GetType() string {
@@ -32,7 +33,7 @@ func (m *ReplyModel) GetRepliesToThreads(boardId string, threads ...*Thread) err
 		ids = append(ids, thread.ID)
 	}
 
-	query, params, _ := m.DbConn.From("replies").Select("id", "board_id", "created_at", "content", "thread_id", "poster_ip").Where(goqu.Ex{
+	query, params, _ := m.DbConn.From("replies").Select("id", "board_id", "created_at", "content", "content_html", "thread_id", "poster_ip", "name", "tripcode", "sage").Where(goqu.Ex{
 		"board_id":  boardId,
 		"thread_id": ids,
 	}).Order(goqu.I("id").Asc()).ToSQL()
@@ -44,17 +45,22 @@ func (m *ReplyModel) GetRepliesToThreads(boardId string, threads ...*Thread) err
 
 	for rows.Next() {
 		var id, threadId uint
-		var boardId, content, poster_ip string
+		var boardId, content, contentHtml, poster_ip, name, tripcode string
 		var creationTime time.Time
+		var sage bool
 
-		rows.Scan(&id, &boardId, &creationTime, &content, &threadId, &poster_ip)
+		rows.Scan(&id, &boardId, &creationTime, &content, &contentHtml, &threadId, &poster_ip, &name, &tripcode, &sage)
 		reply := &Reply{
 			Post: Post{
-				ID:        id,
-				BoardID:   boardId,
-				CreatedAt: creationTime,
-				Content:   content,
-				PosterIP:  net.ParseIP(poster_ip),
+				ID:          id,
+				BoardID:     boardId,
+				CreatedAt:   creationTime,
+				Content:     content,
+				ContentHTML: contentHtml,
+				Name:        name,
+				Tripcode:    tripcode,
+				Sage:        sage,
+				PosterIP:    net.ParseIP(poster_ip),
 			},
 			ThreadID: threadId,
 		}
@@ -139,7 +145,7 @@ func (m *ReplyModel) GetLatestReplies(boardId string, limit int, threads ...*Thr
 		goqu.Ex{"board_id": boardId, "thread_id": ids},
 	)
 
-	query, params, _ := m.DbConn.From(subquery).Select("id", "board_id", "created_at", "content", "thread_id", "poster_ip").Where(
+	query, params, _ := m.DbConn.From(subquery).Select("id", "board_id", "created_at", "content", "content_html", "thread_id", "poster_ip", "name", "tripcode", "sage").Where(
 		goqu.Ex{"ordering": goqu.Op{"lte": limit}},
 	).Order(goqu.I("ordering").Desc()).ToSQL()
 
@@ -150,17 +156,22 @@ func (m *ReplyModel) GetLatestReplies(boardId string, limit int, threads ...*Thr
 
 	for rows.Next() {
 		var id, threadId uint
-		var boardId, content, posterIp string
+		var boardId, content, contentHtml, posterIp, name, tripcode string
 		var creationTime time.Time
+		var sage bool
 
-		rows.Scan(&id, &boardId, &creationTime, &content, &threadId, &posterIp)
+		rows.Scan(&id, &boardId, &creationTime, &content, &contentHtml, &threadId, &posterIp, &name, &tripcode, &sage)
 		reply := &Reply{
 			Post: Post{
-				ID:        id,
-				BoardID:   boardId,
-				CreatedAt: creationTime,
-				Content:   content,
-				PosterIP:  net.ParseIP(posterIp),
+				ID:          id,
+				BoardID:     boardId,
+				CreatedAt:   creationTime,
+				Content:     content,
+				ContentHTML: contentHtml,
+				Name:        name,
+				Tripcode:    tripcode,
+				Sage:        sage,
+				PosterIP:    net.ParseIP(posterIp),
 			},
 			ThreadID: threadId,
 		}
@@ -197,7 +208,7 @@ func (m *ReplyModel) GetLatestReplies(boardId string, limit int, threads ...*Thr
 func (m *ReplyModel) Get(boardId string, replyId uint) (*Reply, error) {
 	reply := Reply{}
 
-	query, params, _ := m.DbConn.From("replies").Select("id", "board_id", "created_at", "content", "thread_id", "poster_ip").Where(goqu.Ex{
+	query, params, _ := m.DbConn.From("replies").Select("id", "board_id", "created_at", "content", "content_html", "thread_id", "poster_ip", "name", "tripcode", "sage").Where(goqu.Ex{
 		"board_id": boardId,
 		"id":       replyId,
 	}).ToSQL()
@@ -205,7 +216,7 @@ func (m *ReplyModel) Get(boardId string, replyId uint) (*Reply, error) {
 	row := m.DbConn.QueryRow(query, params...)
 
 	var posterIp string
-	err := row.Scan(&reply.ID, &reply.BoardID, &reply.CreatedAt, &reply.Content, &reply.ThreadID, &posterIp)
+	err := row.Scan(&reply.ID, &reply.BoardID, &reply.CreatedAt, &reply.Content, &reply.ContentHTML, &reply.ThreadID, &posterIp, &reply.Name, &reply.Tripcode, &reply.Sage)
 	if err != nil {
 		return nil, err
 	}
@@ -222,10 +233,18 @@ func (m *ReplyModel) Get(boardId string, replyId uint) (*Reply, error) {
 		return nil, err
 	}
 
+	err = m.PosterIDModel.SetReplyPosterIDs(boardId, &reply)
+	if err != nil {
+		return nil, err
+	}
+
 	return &reply, nil
 }
 
-func (m *ReplyModel) Insert(boardId string, threadId uint, content string, files []FileInfo, posterIp string) (uint, error) {
+// Insert adds a reply to a thread, bumping the thread unless the reply is
+// sage or the thread reached the bump limit of the board. Replying to a
+// locked or archived thread returns ErrThreadLocked.
+func (m *ReplyModel) Insert(boardId string, threadId uint, name, tripcode, content string, sage bool, files []FileInfo, posterIp string) (uint, error) {
 	var board Board
 
 	tx, err := m.DbConn.Begin()
@@ -244,67 +263,61 @@ func (m *ReplyModel) Insert(boardId string, threadId uint, content string, files
 		return 0, err
 	}
 
-	query, params, _ = m.DbConn.Insert("replies").Rows(goqu.Record{
-		"id":         board.LastPostID + 1,
-		"board_id":   boardId,
-		"content":    content,
-		"created_at": goqu.V("NOW()"),
-		"thread_id":  threadId,
-		"poster_ip":  posterIp,
+	query, params, _ = goqu.From("threads").Select("post_count", "locked", "cyclical", "archived_at").Where(goqu.Ex{
+		"board_id": boardId,
+		"id":       threadId,
 	}).ToSQL()
 
-	var lastInsertId uint
-	err = tx.QueryRow(query+" RETURNING id", params...).Scan(&lastInsertId)
+	var postCount uint
+	var locked, cyclical bool
+	var archivedAt sql.NullTime
+	err = tx.QueryRow(query, params...).Scan(&postCount, &locked, &cyclical, &archivedAt)
 	if err != nil {
 		tx.Rollback()
 		return 0, err
 	}
 
-	if len(files) != 0 {
-		var records []goqu.Record
-
-		for _, file := range files {
-			record := goqu.Record{
-				"board_id":  boardId,
-				"post_id":   lastInsertId,
-				"file_id":   file.ID,
-				"file_name": file.Name,
-			}
-
-			records = append(records, record)
-		}
-
-		query, params, _ := goqu.Insert("post_files").Rows(records).ToSQL()
-
-		_, err := tx.Exec(query, params...)
-		if err != nil {
-			tx.Rollback()
-			return 0, err
-		}
+	if locked || archivedAt.Valid {
+		tx.Rollback()
+		return 0, ErrThreadLocked
 	}
 
-	citations := GetCitations(boardId, lastInsertId, content)
-
-	if len(citations) != 0 {
-		var records []goqu.Record
+	contentHtml, citations, err := renderPost(tx, boardId, board.LastPostID+1, content)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
 
-		for _, citation := range citations {
-			record := goqu.Record{
-				"board_id": citation.BoardID,
-				"post_id":  citation.PostID,
-				"cites":    citation.Cites,
-			}
+	query, params, _ = m.DbConn.Insert("replies").Rows(goqu.Record{
+		"id":           board.LastPostID + 1,
+		"board_id":     boardId,
+		"content":      content,
+		"content_html": contentHtml,
+		"created_at":   goqu.V("NOW()"),
+		"thread_id":    threadId,
+		"name":         name,
+		"tripcode":     tripcode,
+		"sage":         sage,
+		"poster_ip":    posterIp,
+	}).ToSQL()
 
-			records = append(records, record)
-		}
+	var lastInsertId uint
+	err = tx.QueryRow(query+" RETURNING id", params...).Scan(&lastInsertId)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
 
-		query, params, _ := goqu.Insert("citations").Rows(records).ToSQL()
+	err = insertPostFiles(tx, boardId, lastInsertId, files)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
 
-		_, err := tx.Exec(query, params...)
-		if err != nil {
-			tx.Rollback()
-			return 0, err
-		}
+	err = insertCitations(tx, citations)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
 	}
 
 	query, params, _ = goqu.Update("boards").Set(goqu.Record{
@@ -317,30 +330,24 @@ func (m *ReplyModel) Insert(boardId string, threadId uint, content string, files
 		return 0, err
 	}
 
-	query, params, _ = goqu.From("threads").Select("post_count").Where(goqu.Ex{
-		"board_id": boardId,
-		"id":       threadId,
-	}).ToSQL()
+	postCount++
 
-	row = tx.QueryRow(query, params...)
+	if cyclical && board.BumpLimit > 0 {
+		pruned, err := pruneCyclicalThread(tx, boardId, threadId, board.BumpLimit)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
 
-	var postCount uint
-	err = row.Scan(&postCount)
-	if err != nil {
-		tx.Rollback()
-		return 0, err
+		postCount -= pruned
 	}
 
-	var record goqu.Record
-	if board.BumpLimit > postCount {
-		record = goqu.Record{
-			"last_bump":  goqu.V("NOW()"),
-			"post_count": postCount + 1,
-		}
-	} else {
-		record = goqu.Record{
-			"post_count": postCount + 1,
-		}
+	record := goqu.Record{
+		"post_count": postCount,
+	}
+	// Cyclical threads never reach the bump limit.
+	if !sage && (cyclical || board.BumpLimit >= postCount) {
+		record["last_bump"] = goqu.V("NOW()")
 	}
 
 	query, params, _ = goqu.Update("threads").Set(record).Where(goqu.Ex{
@@ -378,32 +385,69 @@ func (m *ReplyModel) Delete(boardId string, id uint) (uint, error) {
 		return 0, err
 	}
 
-	query, params, _ = goqu.Delete("post_files").Where(goqu.Ex{
-		"board_id": boardId,
-		"post_id":  id,
-	}).ToSQL()
-
-	_, err = tx.Exec(query, params...)
+	err = deletePostData(tx, boardId, []uint{id})
 	if err != nil {
 		tx.Rollback()
 		return 0, err
 	}
 
-	query, params, _ = goqu.Delete("citations").Where(goqu.Ex{
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return threadId, nil
+}
+
+// pruneCyclicalThread deletes the oldest replies of a cyclical thread so it
+// keeps at most bumpLimit replies, returning how many were deleted.
+func pruneCyclicalThread(tx *goqu.TxDatabase, boardId string, threadId, bumpLimit uint) (uint, error) {
+	query, params, _ := goqu.From("replies").Select("id").Where(goqu.Ex{
+		"board_id":  boardId,
+		"thread_id": threadId,
+	}).Order(goqu.I("id").Desc()).Offset(bumpLimit).ToSQL()
+
+	rows, err := tx.Query(query, params...)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []uint
+	for rows.Next() {
+		var id uint
+
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	query, params, _ = goqu.Delete("replies").Where(goqu.Ex{
 		"board_id": boardId,
-		"post_id":  id,
+		"id":       ids,
 	}).ToSQL()
 
 	_, err = tx.Exec(query, params...)
 	if err != nil {
-		tx.Rollback()
 		return 0, err
 	}
 
-	err = tx.Commit()
+	err = deletePostData(tx, boardId, ids)
 	if err != nil {
 		return 0, err
 	}
 
-	return threadId, nil
+	return uint(len(ids)), nil
 }
@@ -0,0 +1,319 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/doug-martin/goqu/v9"
+)
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+
+	return n, err
+}
+
+// StorageUsage is how much space the stored files take up.
+type StorageUsage struct {
+	// Boards maps board IDs to the size of the files posted on them. A file
+	// posted on several boards counts for each of them.
+	Boards map[string]int64
+	// Total is the size of every stored file, including unused files waiting
+	// for garbage collection.
+	Total int64
+	// Unmeasured is the number of files uploaded before sizes were recorded,
+	// "frogboard storage measure" fills them in.
+	Unmeasured int
+}
+
+// Describe formats the usage of a board next to its quota, like "12.5 MiB of 1 GiB".
+func (u StorageUsage) Describe(board Board) string {
+	usage := FormatSize(u.Boards[board.ID])
+
+	if board.StorageQuota == 0 {
+		return usage
+	}
+
+	return fmt.Sprintf("%s of %s", usage, FormatSize(board.StorageQuota))
+}
+
+func (u StorageUsage) TotalSize() string {
+	return FormatSize(u.Total)
+}
+
+// boardUsageQuery sums the sizes of the distinct files posted on each board,
+// where selects the post_files rows that count.
+func boardUsageQuery(where goqu.Ex) *goqu.SelectDataset {
+	postedFiles := goqu.From("post_files").Select("board_id", "file_id").Distinct().Where(where)
+
+	return goqu.From(postedFiles.As("posted")).Select(
+		goqu.I("posted.board_id"),
+		goqu.COALESCE(goqu.SUM("file_infos.size"), 0),
+	).Join(
+		goqu.T("file_infos"),
+		goqu.On(goqu.Ex{"posted.file_id": goqu.I("file_infos.id")}),
+	).GroupBy(goqu.I("posted.board_id"))
+}
+
+func (fiModel *FileInfoModel) StorageUsage() (StorageUsage, error) {
+	usage := StorageUsage{Boards: map[string]int64{}}
+
+	query, params, _ := boardUsageQuery(goqu.Ex{}).ToSQL()
+
+	rows, err := fiModel.DbConn.Query(query, params...)
+	if err != nil {
+		return StorageUsage{}, err
+	}
+
+	var boardId string
+	var size int64
+	for rows.Next() {
+		err := rows.Scan(&boardId, &size)
+		if err != nil {
+			return StorageUsage{}, err
+		}
+
+		usage.Boards[boardId] = size
+	}
+
+	query, params, _ = goqu.From("file_infos").Select(
+		goqu.COALESCE(goqu.SUM("size"), 0),
+		goqu.L("COUNT(*) - COUNT(size)"),
+	).ToSQL()
+
+	err = fiModel.DbConn.QueryRow(query, params...).Scan(&usage.Total, &usage.Unmeasured)
+	if err != nil {
+		return StorageUsage{}, err
+	}
+
+	return usage, nil
+}
+
+// BoardUsage returns the size of the files posted on a board.
+func (fiModel *FileInfoModel) BoardUsage(boardId string) (int64, error) {
+	query, params, _ := boardUsageQuery(goqu.Ex{"board_id": boardId}).ToSQL()
+
+	var size int64
+	err := fiModel.DbConn.QueryRow(query, params...).Scan(&boardId, &size)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// AddedUsage returns how much posting the files would add to the usage of a
+// board, files already posted on it don't count again.
+func (fiModel *FileInfoModel) AddedUsage(boardId string, fileIds []string) (int64, error) {
+	if len(fileIds) == 0 {
+		return 0, nil
+	}
+
+	posted := goqu.From("post_files").Select("file_id").Where(goqu.Ex{"board_id": boardId})
+
+	query, params, _ := goqu.From("file_infos").Select(goqu.COALESCE(goqu.SUM("size"), 0)).Where(
+		goqu.Ex{"id": fileIds},
+		goqu.C("id").NotIn(posted),
+	).ToSQL()
+
+	var size int64
+	err := fiModel.DbConn.QueryRow(query, params...).Scan(&size)
+	if err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// PruneBoard removes the files of the oldest threads of a board, with the
+// files of their replies, until the files left on the board take up at most
+// limit bytes. The posts themselves are kept, and the thread keepThreadId
+// keeps its files. It returns the number of threads that lost their files.
+func (fiModel *FileInfoModel) PruneBoard(boardId string, limit int64, keepThreadId uint) (int, error) {
+	usage, err := fiModel.BoardUsage(boardId)
+	if err != nil {
+		return 0, err
+	}
+	if usage <= limit {
+		return 0, nil
+	}
+
+	query, params, _ := goqu.From("threads").Select("id").Where(goqu.Ex{
+		"board_id": boardId,
+		"id":       goqu.Op{"neq": keepThreadId},
+	}).Order(goqu.I("created_at").Asc(), goqu.I("id").Asc()).ToSQL()
+
+	rows, err := fiModel.DbConn.Query(query, params...)
+	if err != nil {
+		return 0, err
+	}
+
+	var threadIds []uint
+	var threadId uint
+	for rows.Next() {
+		err := rows.Scan(&threadId)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		threadIds = append(threadIds, threadId)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var pruned int
+	for _, threadId := range threadIds {
+		prunedThread, err := fiModel.pruneThread(boardId, threadId)
+		if err != nil {
+			return pruned, err
+		}
+		if !prunedThread {
+			continue
+		}
+		pruned++
+
+		usage, err := fiModel.BoardUsage(boardId)
+		if err != nil {
+			return pruned, err
+		}
+		if usage <= limit {
+			break
+		}
+	}
+
+	return pruned, nil
+}
+
+// pruneThread removes the files of a thread and its replies, reporting whether
+// there were any.
+func (fiModel *FileInfoModel) pruneThread(boardId string, threadId uint) (bool, error) {
+	tx, err := fiModel.DbConn.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	query, params, _ := goqu.From("replies").Select("id").Where(goqu.Ex{
+		"board_id":  boardId,
+		"thread_id": threadId,
+	}).ToSQL()
+
+	rows, err := tx.Query(query, params...)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	ids := []uint{threadId}
+
+	var replyId uint
+	for rows.Next() {
+		err := rows.Scan(&replyId)
+		if err != nil {
+			rows.Close()
+			tx.Rollback()
+			return false, err
+		}
+
+		ids = append(ids, replyId)
+	}
+	if err := rows.Err(); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	query, params, _ = goqu.From("post_files").Select(goqu.COUNT(goqu.Star())).Where(goqu.Ex{
+		"board_id": boardId,
+		"post_id":  ids,
+	}).ToSQL()
+
+	var fileCount int
+	err = tx.QueryRow(query, params...).Scan(&fileCount)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if fileCount == 0 {
+		tx.Rollback()
+		return false, nil
+	}
+
+	err = deletePostFiles(tx, goqu.Ex{
+		"board_id": boardId,
+		"post_id":  ids,
+	})
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetUnmeasuredFileIDs returns the files uploaded before sizes were recorded.
+func (fiModel *FileInfoModel) GetUnmeasuredFileIDs() ([]string, error) {
+	var fileIds []string
+
+	query, params, _ := goqu.From("file_infos").Select("id").Where(goqu.C("size").IsNull()).Order(goqu.I("id").Asc()).ToSQL()
+
+	rows, err := fiModel.DbConn.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileId string
+	for rows.Next() {
+		err := rows.Scan(&fileId)
+		if err != nil {
+			return nil, err
+		}
+
+		fileIds = append(fileIds, fileId)
+	}
+
+	return fileIds, nil
+}
+
+// Measure records the size of a stored file and returns it.
+func (fiModel *FileInfoModel) Measure(fileId string) (int64, error) {
+	file, err := fiModel.FileStore.GetFile(fileId)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	query, params, _ := goqu.Update("file_infos").Set(goqu.Record{
+		"size": size,
+	}).Where(goqu.Ex{"id": fileId}).ToSQL()
+
+	_, err = fiModel.DbConn.Exec(query, params...)
+	if err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql"
 	"errors"
+	"html/template"
 	"net"
 	"time"
 
@@ -11,24 +12,47 @@ import (
 
 type Thread struct {
 	Post
-	Title   string
-	Replies []*Reply
+	Title string
+	// Locked threads can't be replied to, sticky threads are shown above the
+	// others and cyclical threads lose their oldest replies instead of
+	// reaching the bump limit.
+	Locked   bool
+	Sticky   bool
+	Cyclical bool
+	// Archived threads were pushed off the last page of their board, they
+	// are kept read-only in its archive.
+	Archived   bool
+	ArchivedAt time.Time
+	Replies    []*Reply
 }
 
+// ThreadFlags are the flags of threads moderators can set.
+var ThreadFlags = []string{"locked", "sticky", "cyclical"}
+
+// ErrThreadLocked is returned when replying to a locked or archived thread.
+var ErrThreadLocked = errors.New("the thread is locked")
+
 type ThreadModel struct {
 	DbConn        *goqu.Database
 	FileInfoModel *FileInfoModel
 	CitationModel *CitationModel
 	ReplyModel    *ReplyModel
+	PosterIDModel *PosterIDModel
 }
 
 func (t Thread) GetType() string {
 	return "thread"
 }
 
+func (t Thread) FormatArchiveDate() template.HTML {
+	return template.HTML(t.ArchivedAt.UTC().Format("2006-01-02T15:04:05-0700"))
+}
+
+// GetThreadCount counts the threads of a board, without the archived ones.
 func (m *ThreadModel) GetThreadCount(boardId string) (uint, error) {
 	query, params, _ := goqu.From("threads").Select(goqu.COUNT("*")).Where(goqu.Ex{
-		"board_id": boardId,
+		"board_id":    boardId,
+		"archived_at": nil,
 	}).ToSQL()
 
 	var count uint
@@ -73,9 +97,10 @@ func (m *ThreadModel) GetLatestFromEveryBoard() ([]*Thread, error) {
 func (m *ThreadModel) GetLatest(boardId string, pageNumber, itemsPerPage uint) ([]*Thread, error) {
 	var threads []*Thread
 
-	query, params, _ := goqu.From("threads").Select("id", "board_id", "created_at", "content", "title", "poster_ip").Where(goqu.Ex{
-		"board_id": boardId,
-	}).Order(goqu.I("last_bump").Desc()).Limit(itemsPerPage).Offset(pageNumber * itemsPerPage).ToSQL()
+	query, params, _ := goqu.From("threads").Select("id", "board_id", "created_at", "content", "content_html", "title", "poster_ip", "name", "tripcode", "locked", "sticky", "cyclical").Where(goqu.Ex{
+		"board_id":    boardId,
+		"archived_at": nil,
+	}).Order(goqu.I("sticky").Desc(), goqu.I("last_bump").Desc()).Limit(itemsPerPage).Offset(pageNumber * itemsPerPage).ToSQL()
 
 	rows, err := m.DbConn.Query(query, params...)
 	if err != nil {
@@ -84,19 +109,26 @@ func (m *ThreadModel) GetLatest(boardId string, pageNumber, itemsPerPage uint) (
 
 	for rows.Next() {
 		var id uint
-		var boardId, content, title, poster_ip string
+		var boardId, content, contentHtml, title, poster_ip, name, tripcode string
 		var creationTime time.Time
+		var locked, sticky, cyclical bool
 
-		rows.Scan(&id, &boardId, &creationTime, &content, &title, &poster_ip)
+		rows.Scan(&id, &boardId, &creationTime, &content, &contentHtml, &title, &poster_ip, &name, &tripcode, &locked, &sticky, &cyclical)
 		thread := &Thread{
 			Post: Post{
-				ID:        id,
-				BoardID:   boardId,
-				CreatedAt: creationTime,
-				Content:   content,
-				PosterIP:  net.ParseIP(poster_ip),
+				ID:          id,
+				BoardID:     boardId,
+				CreatedAt:   creationTime,
+				Content:     content,
+				ContentHTML: contentHtml,
+				Name:        name,
+				Tripcode:    tripcode,
+				PosterIP:    net.ParseIP(poster_ip),
 			},
-			Title: title,
+			Title:    title,
+			Locked:   locked,
+			Sticky:   sticky,
+			Cyclical: cyclical,
 		}
 
 		threads = append(threads, thread)
@@ -122,13 +154,18 @@ func (m *ThreadModel) GetLatest(boardId string, pageNumber, itemsPerPage uint) (
 		return nil, err
 	}
 
+	err = m.PosterIDModel.SetThreadPosterIDs(boardId, threads...)
+	if err != nil {
+		return nil, err
+	}
+
 	return threads, nil
 }
 
 func (m *ThreadModel) Get(boardId string, threadId uint) (*Thread, error) {
 	var thread Thread
 
-	query, params, _ := m.DbConn.From("threads").Select("id", "board_id", "created_at", "content", "title", "poster_ip").Where(goqu.Ex{
+	query, params, _ := m.DbConn.From("threads").Select("id", "board_id", "created_at", "content", "content_html", "title", "poster_ip", "name", "tripcode", "locked", "sticky", "cyclical", "archived_at").Where(goqu.Ex{
 		"board_id": boardId,
 		"id":       threadId,
 	}).ToSQL()
@@ -136,11 +173,15 @@ func (m *ThreadModel) Get(boardId string, threadId uint) (*Thread, error) {
 	row := m.DbConn.QueryRow(query, params...)
 
 	var posterIp string
-	err := row.Scan(&thread.ID, &thread.BoardID, &thread.CreatedAt, &thread.Content, &thread.Title, &posterIp)
+	var archivedAt sql.NullTime
+	err := row.Scan(&thread.ID, &thread.BoardID, &thread.CreatedAt, &thread.Content, &thread.ContentHTML, &thread.Title, &posterIp, &thread.Name, &thread.Tripcode, &thread.Locked, &thread.Sticky, &thread.Cyclical, &archivedAt)
 	if err != nil {
 		return nil, err
 	}
 
+	thread.Archived = archivedAt.Valid
+	thread.ArchivedAt = archivedAt.Time
+
 	thread.PosterIP = net.ParseIP(posterIp)
 
 	err = m.FileInfoModel.GetFilesForPosts(boardId, &thread.Post)
@@ -158,10 +199,56 @@ func (m *ThreadModel) Get(boardId string, threadId uint) (*Thread, error) {
 		return nil, err
 	}
 
+	err = m.PosterIDModel.SetThreadPosterIDs(boardId, &thread)
+	if err != nil {
+		return nil, err
+	}
+
 	return &thread, nil
 }
 
-func (m *ThreadModel) Insert(boardId, title, content string, files []FileInfo, posterIp string) (uint, error) {
+// IsLocked tells if a thread is locked, archived threads are locked too. It
+// returns sql.ErrNoRows when the thread doesn't exist.
+func (m *ThreadModel) IsLocked(boardId string, threadId uint) (bool, error) {
+	query, params, _ := goqu.From("threads").Select("locked", "archived_at").Where(goqu.Ex{
+		"board_id": boardId,
+		"id":       threadId,
+	}).ToSQL()
+
+	var locked bool
+	var archivedAt sql.NullTime
+	err := m.DbConn.QueryRow(query, params...).Scan(&locked, &archivedAt)
+	if err != nil {
+		return false, err
+	}
+
+	return locked || archivedAt.Valid, nil
+}
+
+// SetFlag sets one of ThreadFlags on a thread.
+func (m *ThreadModel) SetFlag(boardId string, threadId uint, flag string, value bool) error {
+	query, params, _ := goqu.Update("threads").Set(goqu.Record{flag: value}).Where(goqu.Ex{
+		"board_id": boardId,
+		"id":       threadId,
+	}).ToSQL()
+
+	result, err := m.DbConn.Exec(query, params...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (m *ThreadModel) Insert(boardId, title, name, tripcode, content string, files []FileInfo, posterIp string) (uint, error) {
 	var board Board
 
 	tx, err := m.DbConn.Begin()
@@ -180,15 +267,24 @@ func (m *ThreadModel) Insert(boardId, title, content string, files []FileInfo, p
 		return 0, err
 	}
 
+	contentHtml, citations, err := renderPost(tx, boardId, board.LastPostID+1, content)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
 	sql, params, _ = m.DbConn.Insert("threads").Rows(goqu.Record{
-		"id":         board.LastPostID + 1,
-		"board_id":   boardId,
-		"content":    content,
-		"created_at": goqu.V("NOW()"),
-		"title":      title,
-		"last_bump":  goqu.V("NOW()"),
-		"post_count": 0,
-		"poster_ip":  posterIp,
+		"id":           board.LastPostID + 1,
+		"board_id":     boardId,
+		"content":      content,
+		"content_html": contentHtml,
+		"created_at":   goqu.V("NOW()"),
+		"title":        title,
+		"name":         name,
+		"tripcode":     tripcode,
+		"last_bump":    goqu.V("NOW()"),
+		"post_count":   0,
+		"poster_ip":    posterIp,
 	}).ToSQL()
 
 	var lastInsertId uint
@@ -198,51 +294,16 @@ func (m *ThreadModel) Insert(boardId, title, content string, files []FileInfo, p
 		return 0, err
 	}
 
-	if len(files) != 0 {
-		var records []goqu.Record
-
-		for _, file := range files {
-			record := goqu.Record{
-				"board_id":  boardId,
-				"post_id":   lastInsertId,
-				"file_id":   file.ID,
-				"file_name": file.Name,
-			}
-
-			records = append(records, record)
-		}
-
-		query, params, _ := goqu.Insert("post_files").Rows(records).ToSQL()
-
-		_, err := tx.Exec(query, params...)
-		if err != nil {
-			tx.Rollback()
-			return 0, err
-		}
+	err = insertPostFiles(tx, boardId, lastInsertId, files)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
 	}
 
-	citations := GetCitations(boardId, lastInsertId, content)
-
-	if len(citations) != 0 {
-		var records []goqu.Record
-
-		for _, citation := range citations {
-			record := goqu.Record{
-				"board_id": citation.BoardID,
-				"post_id":  citation.PostID,
-				"cites":    citation.Cites,
-			}
-
-			records = append(records, record)
-		}
-
-		query, params, _ := goqu.Insert("citations").Rows(records).ToSQL()
-
-		_, err := tx.Exec(query, params...)
-		if err != nil {
-			tx.Rollback()
-			return 0, err
-		}
+	err = insertCitations(tx, citations)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
 	}
 
 	sql, params, _ = goqu.Update("boards").Set(goqu.Record{
@@ -264,25 +325,34 @@ func (m *ThreadModel) Insert(boardId, title, content string, files []FileInfo, p
 }
 
 func (m *ThreadModel) Delete(boardId string, threadIds ...uint) error {
-	query, params, _ := goqu.Delete("threads").Where(goqu.Ex{"board_id": boardId, "id": threadIds}).ToSQL()
-
 	tx, err := m.DbConn.Begin()
 	if err != nil {
 		return err
 	}
 
-	result, err := tx.Exec(query, params...)
+	err = deleteThreads(tx, boardId, threadIds)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
+
+	return tx.Commit()
+}
+
+// deleteThreads deletes threads of a board with their replies, returning
+// sql.ErrNoRows when none of them exist.
+func deleteThreads(tx *goqu.TxDatabase, boardId string, threadIds []uint) error {
+	query, params, _ := goqu.Delete("threads").Where(goqu.Ex{"board_id": boardId, "id": threadIds}).ToSQL()
+
+	result, err := tx.Exec(query, params...)
+	if err != nil {
+		return err
+	}
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 	if rowsAffected == 0 {
-		tx.Rollback()
 		return sql.ErrNoRows
 	}
 
@@ -293,7 +363,6 @@ func (m *ThreadModel) Delete(boardId string, threadIds ...uint) error {
 
 	rows, err := tx.Query(query, params...)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
@@ -304,18 +373,13 @@ func (m *ThreadModel) Delete(boardId string, threadIds ...uint) error {
 	for rows.Next() {
 		err := rows.Scan(&replyId)
 		if err != nil {
-			tx.Rollback()
+			rows.Close()
 			return err
 		}
 
 		ids = append(ids, replyId)
 	}
 
-	if len(ids) == 0 {
-		tx.Commit()
-		return nil
-	}
-
 	query, params, _ = goqu.Delete("replies").Where(goqu.Ex{
 		"board_id":  boardId,
 		"thread_id": threadIds,
@@ -323,33 +387,181 @@ func (m *ThreadModel) Delete(boardId string, threadIds ...uint) error {
 
 	_, err = tx.Exec(query, params...)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
-	query, params, _ = goqu.Delete("post_files").Where(goqu.Ex{
-		"board_id": boardId,
-		"post_id":  ids,
-	}).ToSQL()
+	return deletePostData(tx, boardId, ids)
+}
 
-	_, err = tx.Exec(query, params...)
+// Prune takes the threads pushed off the last page of a board off it,
+// deleting or archiving them as the board says. Sticky threads are never
+// pruned. It returns the number of threads pruned.
+func (m *ThreadModel) Prune(board Board) (int, error) {
+	if board.MaxPages == 0 {
+		return 0, nil
+	}
+
+	query, params, _ := goqu.From("threads").Select("id", "sticky").Where(goqu.Ex{
+		"board_id":    board.ID,
+		"archived_at": nil,
+	}).Order(goqu.I("sticky").Desc(), goqu.I("last_bump").Desc()).Offset(board.MaxThreads()).ToSQL()
+
+	rows, err := m.DbConn.Query(query, params...)
+	if err != nil {
+		return 0, err
+	}
+
+	var threadIds []uint
+	for rows.Next() {
+		var threadId uint
+		var sticky bool
+
+		err := rows.Scan(&threadId, &sticky)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		if !sticky {
+			threadIds = append(threadIds, threadId)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(threadIds) == 0 {
+		return 0, nil
+	}
+
+	tx, err := m.DbConn.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	if board.PruneAction == PruneArchive {
+		query, params, _ = goqu.Update("threads").Set(goqu.Record{
+			"archived_at": goqu.V("NOW()"),
+		}).Where(goqu.Ex{
+			"board_id": board.ID,
+			"id":       threadIds,
+		}).ToSQL()
+
+		_, err = tx.Exec(query, params...)
+	} else {
+		err = deleteThreads(tx, board.ID, threadIds)
+	}
 	if err != nil {
 		tx.Rollback()
-		return err
+		return 0, err
 	}
 
-	query, params, _ = goqu.Delete("citations").Where(goqu.Ex{
-		"board_id": boardId,
-		"post_id":  ids,
-	}).ToSQL()
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
 
-	_, err = tx.Exec(query, params...)
+	return len(threadIds), nil
+}
+
+// GetArchivedCount counts the archived threads of a board.
+func (m *ThreadModel) GetArchivedCount(boardId string) (uint, error) {
+	query, params, _ := goqu.From("threads").Select(goqu.COUNT("*")).Where(
+		goqu.Ex{"board_id": boardId},
+		goqu.C("archived_at").IsNotNull(),
+	).ToSQL()
+
+	var count uint
+	err := m.DbConn.QueryRow(query, params...).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// GetArchived lists a page of the archived threads of a board, the most
+// recently archived first. Only the opening posts are loaded.
+func (m *ThreadModel) GetArchived(boardId string, pageNumber, itemsPerPage uint) ([]*Thread, error) {
+	var threads []*Thread
+
+	query, params, _ := goqu.From("threads").Select("id", "board_id", "created_at", "content", "title", "archived_at").Where(
+		goqu.Ex{"board_id": boardId},
+		goqu.C("archived_at").IsNotNull(),
+	).Order(goqu.I("archived_at").Desc(), goqu.I("id").Desc()).Limit(itemsPerPage).Offset(pageNumber * itemsPerPage).ToSQL()
+
+	rows, err := m.DbConn.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		thread := &Thread{Archived: true}
+
+		err := rows.Scan(&thread.ID, &thread.BoardID, &thread.CreatedAt, &thread.Content, &thread.Title, &thread.ArchivedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		threads = append(threads, thread)
+	}
+
+	return threads, rows.Err()
+}
+
+// ExpireArchived deletes the threads that have been in the archive of a board
+// for longer than it keeps them. It returns the number of threads deleted.
+func (m *ThreadModel) ExpireArchived(board Board) (int, error) {
+	if board.ArchiveDays == 0 {
+		return 0, nil
+	}
+
+	query, params, _ := goqu.From("threads").Select("id").Where(
+		goqu.Ex{"board_id": board.ID},
+		goqu.L("archived_at < NOW() - make_interval(days => ?)", board.ArchiveDays),
+	).ToSQL()
+
+	rows, err := m.DbConn.Query(query, params...)
+	if err != nil {
+		return 0, err
+	}
+
+	var threadIds []uint
+	for rows.Next() {
+		var threadId uint
+
+		err := rows.Scan(&threadId)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		threadIds = append(threadIds, threadId)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(threadIds) == 0 {
+		return 0, nil
+	}
+
+	tx, err := m.DbConn.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	err = deleteThreads(tx, board.ID, threadIds)
 	if err != nil {
 		tx.Rollback()
-		return err
+		return 0, err
 	}
 
-	tx.Commit()
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
 
-	return nil
+	return len(threadIds), nil
 }
@@ -0,0 +1,247 @@
+package filestorage
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// EncryptedFileStore encrypts files and thumbnails before handing them to
+// another store and decrypts them when they're read. Files keep the hash of
+// their plain content as key, so uploads of the same file are still stored
+// once. Files the store holds in plain form, from before encryption was
+// turned on, are read as they are until RotateKey encrypts them.
+type EncryptedFileStore struct {
+	store      FileStore
+	thumbnails ThumbnailPolicy
+	// keys starts with the key new files are encrypted with.
+	keys []*encryptionKey
+}
+
+func NewEncryptedStore(store FileStore, thumbnails ThumbnailPolicy, config EncryptionConfig) (*EncryptedFileStore, error) {
+	keys, err := config.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncryptedFileStore{
+		store:      store,
+		thumbnails: thumbnails.WithDefaults(),
+		keys:       keys,
+	}, nil
+}
+
+// put encrypts a file into a temporary file and stores that, the wrapped
+// stores size their uploads by seeking.
+func (fs *EncryptedFileStore) put(key string, file io.Reader, thumbnail bool) error {
+	tmp, err := os.CreateTemp("", "encrypt-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := encryptFile(tmp, file, fs.keys[0]); err != nil {
+		return err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if thumbnail {
+		return fs.store.PutFileThumbnail(key, tmp)
+	}
+
+	return fs.store.PutFile(key, tmp)
+}
+
+// decrypt wraps a stored file in a reader of its plain content.
+func (fs *EncryptedFileStore) decrypt(stored io.ReadSeekCloser) (io.ReadSeekCloser, error) {
+	header, err := readEncryptionHeader(stored)
+	if err != nil {
+		stored.Close()
+		return nil, err
+	}
+
+	if header == nil {
+		if _, err := stored.Seek(0, io.SeekStart); err != nil {
+			stored.Close()
+			return nil, err
+		}
+
+		return stored, nil
+	}
+
+	reader, err := newDecryptingReader(stored, header, fs.keys)
+	if err != nil {
+		stored.Close()
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// AddFile hashes and inspects the upload in plain form, the wrapped store only
+// ever sees the encrypted file.
+func (fs *EncryptedFileStore) AddFile(file io.Reader, check UploadCheck) (FileDetails, error) {
+	tmp, key, err := spoolFile("", file)
+	if err != nil {
+		return FileDetails{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	contentType, err := detectContentType(tmp)
+	if err != nil {
+		return FileDetails{}, err
+	}
+
+	exists, err := fs.store.Exists(key, false)
+	if err != nil {
+		return FileDetails{}, err
+	}
+
+	details, thumbnail, err := processMedia(tmp, contentType, fs.thumbnails, !exists)
+	if err != nil {
+		return FileDetails{}, err
+	}
+	details.Key = key
+
+	if check != nil {
+		if err := check(details); err != nil {
+			return FileDetails{}, err
+		}
+	}
+
+	if exists {
+		return details, nil
+	}
+
+	// The thumbnail goes first so that an existing original always has one.
+	if thumbnail != nil {
+		if err := fs.put(key, bytes.NewReader(thumbnail), true); err != nil {
+			return FileDetails{}, err
+		}
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return FileDetails{}, err
+	}
+
+	if err := fs.put(key, tmp, false); err != nil {
+		return FileDetails{}, err
+	}
+
+	return details, nil
+}
+
+func (fs *EncryptedFileStore) GetFile(key string) (io.ReadSeekCloser, error) {
+	stored, err := fs.store.GetFile(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.decrypt(stored)
+}
+
+func (fs *EncryptedFileStore) GetFileThumbnail(key string) (io.ReadSeekCloser, error) {
+	stored, err := fs.store.GetFileThumbnail(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.decrypt(stored)
+}
+
+// RegenerateThumbnail renders the thumbnail from the decrypted file. Thumbnails
+// the policy no longer asks for are left in place, the wrapped store can't
+// remove a thumbnail without its file.
+func (fs *EncryptedFileStore) RegenerateThumbnail(key string) error {
+	original, err := fs.GetFile(key)
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+
+	thumbnail, err := renderThumbnail(original, fs.thumbnails)
+	if err != nil {
+		return err
+	}
+
+	if thumbnail == nil {
+		return nil
+	}
+
+	return fs.put(key, bytes.NewReader(thumbnail), true)
+}
+
+func (fs *EncryptedFileStore) DeleteFiles(keys ...string) error {
+	return fs.store.DeleteFiles(keys...)
+}
+
+func (fs *EncryptedFileStore) Walk(fn func(key string, thumbnail bool) error) error {
+	return fs.store.Walk(fn)
+}
+
+func (fs *EncryptedFileStore) Quarantine(key string) error {
+	return fs.store.Quarantine(key)
+}
+
+func (fs *EncryptedFileStore) Exists(key string, thumbnail bool) (bool, error) {
+	return fs.store.Exists(key, thumbnail)
+}
+
+func (fs *EncryptedFileStore) PutFile(key string, file io.ReadSeeker) error {
+	return fs.put(key, file, false)
+}
+
+func (fs *EncryptedFileStore) PutFileThumbnail(key string, thumbnail io.ReadSeeker) error {
+	return fs.put(key, thumbnail, true)
+}
+
+// RotateKey encrypts a stored file, or its thumbnail, with the current key
+// when it's stored in plain form or with a previous key. It reports whether
+// the file had to be encrypted again.
+func (fs *EncryptedFileStore) RotateKey(key string, thumbnail bool) (bool, error) {
+	var stored io.ReadSeekCloser
+	var err error
+
+	if thumbnail {
+		stored, err = fs.store.GetFileThumbnail(key)
+	} else {
+		stored, err = fs.store.GetFile(key)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	header, err := readEncryptionHeader(stored)
+	if err != nil {
+		stored.Close()
+		return false, err
+	}
+
+	if header != nil && bytes.Equal(header[8:16], fs.keys[0].id[:]) {
+		stored.Close()
+		return false, nil
+	}
+
+	// Stored files are read again from the start, whatever form they're in.
+	if _, err := stored.Seek(0, io.SeekStart); err != nil {
+		stored.Close()
+		return false, err
+	}
+
+	plain, err := fs.decrypt(stored)
+	if err != nil {
+		return false, err
+	}
+	defer plain.Close()
+
+	if err := fs.put(key, plain, thumbnail); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
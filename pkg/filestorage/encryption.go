@@ -0,0 +1,360 @@
+package filestorage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Encrypted files start with a header naming the key they were encrypted with,
+// followed by the file split into chunks that are sealed separately, so any
+// part of a file can be read without decrypting all of it. The header and
+// whether a chunk is the last one are authenticated with every chunk, which
+// keeps chunks from being reordered, swapped between files or cut off.
+//
+//	magic [8]byte | key id [8]byte | nonce prefix [8]byte | chunk size uint32
+var encryptionMagic = []byte("FROGENC1")
+
+const (
+	encryptionHeaderSize = 28
+	encryptionChunkSize  = 64 << 10
+)
+
+var ErrUnknownKey = errors.New("file is encrypted with a key that isn't configured")
+var ErrCorruptEncryptedFile = errors.New("encrypted file is corrupt")
+
+// EncryptionConfig holds the keys files are encrypted with, each a base64
+// encoded 32 byte key given either directly or as the path of a file holding
+// it. New files are encrypted with Key, the previous keys are only used to
+// read files that haven't been rotated to Key yet.
+type EncryptionConfig struct {
+	Key              string
+	KeyFile          string
+	PreviousKeys     []string
+	PreviousKeyFiles []string
+}
+
+type encryptionKey struct {
+	id   [8]byte
+	aead cipher.AEAD
+}
+
+// GenerateEncryptionKey returns a new random key, base64 encoded like the
+// keys in EncryptionConfig.
+func GenerateEncryptionKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func parseEncryptionKey(encoded string) (*encryptionKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("key isn't valid base64: %w", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("key is %d bytes long instead of 32", len(raw))
+	}
+
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &encryptionKey{aead: aead}
+
+	sum := sha256.Sum256(raw)
+	copy(key.id[:], sum[:8])
+
+	return key, nil
+}
+
+func readEncryptionKeyFile(path string) (*encryptionKey, error) {
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parseEncryptionKey(string(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return key, nil
+}
+
+// keys returns the current key followed by the previous keys.
+func (c EncryptionConfig) keys() ([]*encryptionKey, error) {
+	var keys []*encryptionKey
+
+	switch {
+	case c.Key != "" && c.KeyFile != "":
+		return nil, errors.New("only one of key and keyfile can be set")
+	case c.Key != "":
+		key, err := parseEncryptionKey(c.Key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	case c.KeyFile != "":
+		key, err := readEncryptionKeyFile(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	default:
+		return nil, errors.New("no encryption key is set")
+	}
+
+	for _, encoded := range c.PreviousKeys {
+		key, err := parseEncryptionKey(encoded)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	for _, path := range c.PreviousKeyFiles {
+		key, err := readEncryptionKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func chunkNonce(prefix []byte, index uint32) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[8:], index)
+
+	return nonce
+}
+
+func chunkAdditionalData(header []byte, final bool) []byte {
+	data := append([]byte{}, header...)
+	if final {
+		return append(data, 1)
+	}
+
+	return append(data, 0)
+}
+
+// encryptFile writes the encrypted form of src to dst.
+func encryptFile(dst io.Writer, src io.Reader, key *encryptionKey) error {
+	header := make([]byte, encryptionHeaderSize)
+	copy(header, encryptionMagic)
+	copy(header[8:], key.id[:])
+	if _, err := rand.Read(header[16:24]); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(header[24:], encryptionChunkSize)
+
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(src)
+	chunk := make([]byte, encryptionChunkSize)
+
+	for index := uint32(0); ; index++ {
+		n, err := io.ReadFull(reader, chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		_, err = reader.Peek(1)
+		final := err == io.EOF
+		if err != nil && !final {
+			return err
+		}
+
+		sealed := key.aead.Seal(nil, chunkNonce(header[16:24], index), chunk[:n], chunkAdditionalData(header, final))
+		if _, err := dst.Write(sealed); err != nil {
+			return err
+		}
+
+		if final {
+			return nil
+		}
+	}
+}
+
+// decryptingReader reads an encrypted file as if it was stored in plain form,
+// decrypting one chunk at a time.
+type decryptingReader struct {
+	src    io.ReadSeekCloser
+	key    *encryptionKey
+	header []byte
+
+	chunkSize int64
+	chunks    int64
+	size      int64
+	offset    int64
+
+	chunk      []byte
+	chunkIndex int64
+}
+
+// readEncryptionHeader reads the header of a stored file, returning nil for
+// files stored in plain form.
+func readEncryptionHeader(src io.ReadSeeker) ([]byte, error) {
+	header := make([]byte, encryptionHeaderSize)
+
+	n, err := io.ReadFull(src, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	if n < encryptionHeaderSize || !bytes.Equal(header[:8], encryptionMagic) {
+		return nil, nil
+	}
+
+	return header, nil
+}
+
+func newDecryptingReader(src io.ReadSeekCloser, header []byte, keys []*encryptionKey) (*decryptingReader, error) {
+	var key *encryptionKey
+	for _, candidate := range keys {
+		if bytes.Equal(candidate.id[:], header[8:16]) {
+			key = candidate
+			break
+		}
+	}
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+
+	chunkSize := int64(binary.BigEndian.Uint32(header[24:]))
+	if chunkSize == 0 {
+		return nil, ErrCorruptEncryptedFile
+	}
+
+	storedSize, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	sealedChunkSize := chunkSize + int64(key.aead.Overhead())
+	body := storedSize - encryptionHeaderSize
+
+	chunks := body / sealedChunkSize
+	size := chunks * chunkSize
+	if rest := body % sealedChunkSize; rest != 0 {
+		if rest < int64(key.aead.Overhead()) {
+			return nil, ErrCorruptEncryptedFile
+		}
+		chunks++
+		size += rest - int64(key.aead.Overhead())
+	}
+	if chunks == 0 {
+		return nil, ErrCorruptEncryptedFile
+	}
+
+	reader := &decryptingReader{
+		src:        src,
+		key:        key,
+		header:     header,
+		chunkSize:  chunkSize,
+		chunks:     chunks,
+		size:       size,
+		chunkIndex: -1,
+	}
+
+	// Opening the last chunk catches files that were cut off, even when the
+	// cut leaves a partial chunk that reads would never reach.
+	if err := reader.loadChunk(chunks - 1); err != nil {
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+func (r *decryptingReader) loadChunk(index int64) error {
+	if index == r.chunkIndex {
+		return nil
+	}
+
+	sealedChunkSize := r.chunkSize + int64(r.key.aead.Overhead())
+
+	if _, err := r.src.Seek(encryptionHeaderSize+index*sealedChunkSize, io.SeekStart); err != nil {
+		return err
+	}
+
+	sealed := make([]byte, sealedChunkSize)
+	n, err := io.ReadFull(r.src, sealed)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	final := index == r.chunks-1
+	chunk, err := r.key.aead.Open(nil, chunkNonce(r.header[16:24], uint32(index)), sealed[:n], chunkAdditionalData(r.header, final))
+	if err != nil {
+		return ErrCorruptEncryptedFile
+	}
+
+	r.chunk = chunk
+	r.chunkIndex = index
+
+	return nil
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	index := r.offset / r.chunkSize
+	if err := r.loadChunk(index); err != nil {
+		return 0, err
+	}
+
+	n := copy(p, r.chunk[r.offset-index*r.chunkSize:])
+	r.offset += int64(n)
+
+	return n, nil
+}
+
+func (r *decryptingReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	r.offset = offset
+
+	return offset, nil
+}
+
+func (r *decryptingReader) Close() error {
+	return r.src.Close()
+}
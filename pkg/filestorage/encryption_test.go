@@ -0,0 +1,185 @@
+package filestorage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func newEncryptedTestStore(t *testing.T, store FileStore, config EncryptionConfig) *EncryptedFileStore {
+	t.Helper()
+
+	encrypted, err := NewEncryptedStore(store, ThumbnailPolicy{}, config)
+	if err != nil {
+		t.Fatalf("NewEncryptedStore: %s", err)
+	}
+
+	return encrypted
+}
+
+func generateKey(t *testing.T) string {
+	t.Helper()
+
+	key, err := GenerateEncryptionKey()
+	if err != nil {
+		t.Fatalf("GenerateEncryptionKey: %s", err)
+	}
+
+	return key
+}
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	inner := NewFileSystemStore(t.TempDir(), ThumbnailPolicy{})
+	store := newEncryptedTestStore(t, inner, EncryptionConfig{Key: generateKey(t)})
+
+	// Sizes around the chunk boundaries.
+	for _, size := range []int{0, 1, encryptionChunkSize - 1, encryptionChunkSize, encryptionChunkSize + 1, 3*encryptionChunkSize + 100} {
+		content := make([]byte, size)
+		rand.Read(content)
+
+		details, err := store.AddFile(bytes.NewReader(content), nil)
+		if err != nil {
+			t.Fatalf("AddFile of %d bytes: %s", size, err)
+		}
+
+		if details.Key != keyOf(string(content)) {
+			t.Errorf("%d bytes: key isn't the sha1 of the plain content", size)
+		}
+
+		stored, _ := inner.GetFile(details.Key)
+		raw, _ := io.ReadAll(stored)
+		if !bytes.HasPrefix(raw, encryptionMagic) || bytes.Equal(raw, content) {
+			t.Errorf("%d bytes: the file isn't stored encrypted", size)
+		}
+		// Shorter contents could turn up in the ciphertext by chance.
+		if size >= 16 && bytes.Contains(raw, content) {
+			t.Errorf("%d bytes: the file is stored in plain form", size)
+		}
+
+		file, err := store.GetFile(details.Key)
+		if err != nil {
+			t.Fatalf("GetFile of %d bytes: %s", size, err)
+		}
+
+		got, err := io.ReadAll(file)
+		if err != nil || !bytes.Equal(got, content) {
+			t.Errorf("%d bytes: read back %d bytes, %v", size, len(got), err)
+		}
+
+		end, err := file.Seek(0, io.SeekEnd)
+		if err != nil || end != int64(size) {
+			t.Errorf("%d bytes: seeking to the end returned %d, %v", size, end, err)
+		}
+
+		if size > encryptionChunkSize+10 {
+			offset := int64(encryptionChunkSize - 10)
+			file.Seek(offset, io.SeekStart)
+
+			part := make([]byte, 20)
+			if _, err := io.ReadFull(file, part); err != nil || !bytes.Equal(part, content[offset:offset+20]) {
+				t.Errorf("%d bytes: reading across a chunk boundary failed: %v", size, err)
+			}
+		}
+
+		file.Close()
+	}
+}
+
+func TestEncryptedStoreDetectsTampering(t *testing.T) {
+	inner := NewFileSystemStore(t.TempDir(), ThumbnailPolicy{})
+	store := newEncryptedTestStore(t, inner, EncryptionConfig{Key: generateKey(t)})
+
+	details, err := store.AddFile(strings.NewReader(strings.Repeat("frog", encryptionChunkSize)), nil)
+	if err != nil {
+		t.Fatalf("AddFile: %s", err)
+	}
+
+	stored, _ := inner.GetFile(details.Key)
+	raw, _ := io.ReadAll(stored)
+
+	for name, tampered := range map[string][]byte{
+		"flipped bit": append(append([]byte{}, raw[:100]...), append([]byte{raw[100] ^ 1}, raw[101:]...)...),
+		"cut off":     raw[:len(raw)-encryptionChunkSize],
+	} {
+		inner.PutFile(details.Key, bytes.NewReader(tampered))
+
+		file, err := store.GetFile(details.Key)
+		if err == nil {
+			_, err = io.ReadAll(file)
+			file.Close()
+		}
+
+		if !errors.Is(err, ErrCorruptEncryptedFile) {
+			t.Errorf("%s: reading returned %v, want ErrCorruptEncryptedFile", name, err)
+		}
+	}
+}
+
+func TestEncryptedStoreRotateKey(t *testing.T) {
+	inner := NewFileSystemStore(t.TempDir(), ThumbnailPolicy{})
+
+	oldKey := generateKey(t)
+	newKey := generateKey(t)
+
+	plain := keyOf("stored before encryption")
+	inner.PutFile(plain, strings.NewReader("stored before encryption"))
+
+	oldStore := newEncryptedTestStore(t, inner, EncryptionConfig{Key: oldKey})
+	details, err := oldStore.AddFile(strings.NewReader("encrypted with the old key"), nil)
+	if err != nil {
+		t.Fatalf("AddFile: %s", err)
+	}
+
+	// Files in plain form are read as they are.
+	file, err := oldStore.GetFile(plain)
+	if got := readAll(t, file, err); got != "stored before encryption" {
+		t.Errorf("reading a plain file returned %q", got)
+	}
+
+	store := newEncryptedTestStore(t, inner, EncryptionConfig{Key: newKey, PreviousKeys: []string{oldKey}})
+
+	for _, key := range []string{plain, details.Key} {
+		rotated, err := store.RotateKey(key, false)
+		if err != nil || !rotated {
+			t.Errorf("RotateKey of %s returned %t, %v", key, rotated, err)
+		}
+
+		rotated, err = store.RotateKey(key, false)
+		if err != nil || rotated {
+			t.Errorf("rotating %s again returned %t, %v, want it to be skipped", key, rotated, err)
+		}
+	}
+
+	newOnly := newEncryptedTestStore(t, inner, EncryptionConfig{Key: newKey})
+
+	file, err = newOnly.GetFile(details.Key)
+	if got := readAll(t, file, err); got != "encrypted with the old key" {
+		t.Errorf("reading a rotated file returned %q", got)
+	}
+
+	file, err = newOnly.GetFile(plain)
+	if got := readAll(t, file, err); got != "stored before encryption" {
+		t.Errorf("reading a file encrypted by rotation returned %q", got)
+	}
+
+	oldOnly := newEncryptedTestStore(t, inner, EncryptionConfig{Key: oldKey})
+	if _, err := oldOnly.GetFile(details.Key); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("reading with only the old key returned %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestEncryptionConfigErrors(t *testing.T) {
+	for name, config := range map[string]EncryptionConfig{
+		"no key":     {},
+		"both keys":  {Key: generateKey(t), KeyFile: "/dev/null"},
+		"short key":  {Key: "c2hvcnQ="},
+		"not base64": {Key: "not a key!"},
+	} {
+		if _, err := NewEncryptedStore(NewFileSystemStore(t.TempDir(), ThumbnailPolicy{}), ThumbnailPolicy{}, config); err == nil {
+			t.Errorf("%s: NewEncryptedStore succeeded", name)
+		}
+	}
+}
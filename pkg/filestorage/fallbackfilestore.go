@@ -0,0 +1,126 @@
+package filestorage
+
+import (
+	"io"
+)
+
+// FallbackFileStore serves files from a primary store and reads the files it
+// doesn't have from a fallback store. It keeps a board running while its files
+// are migrated from the fallback store to the primary one. New files only go
+// to the primary store, deletions go to both.
+type FallbackFileStore struct {
+	primary  FileStore
+	fallback FileStore
+}
+
+func NewFallbackStore(primary, fallback FileStore) *FallbackFileStore {
+	return &FallbackFileStore{
+		primary:  primary,
+		fallback: fallback,
+	}
+}
+
+func (fs *FallbackFileStore) AddFile(file io.Reader, check UploadCheck) (FileDetails, error) {
+	return fs.primary.AddFile(file, check)
+}
+
+func (fs *FallbackFileStore) GetFile(key string) (io.ReadSeekCloser, error) {
+	file, err := fs.primary.GetFile(key)
+	if err == nil {
+		return file, nil
+	}
+
+	file, fallbackErr := fs.fallback.GetFile(key)
+	if fallbackErr != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (fs *FallbackFileStore) GetFileThumbnail(key string) (io.ReadSeekCloser, error) {
+	thumbnail, err := fs.primary.GetFileThumbnail(key)
+	if err == nil {
+		return thumbnail, nil
+	}
+
+	thumbnail, fallbackErr := fs.fallback.GetFileThumbnail(key)
+	if fallbackErr != nil {
+		return nil, err
+	}
+
+	return thumbnail, nil
+}
+
+func (fs *FallbackFileStore) RegenerateThumbnail(key string) error {
+	exists, err := fs.primary.Exists(key, false)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return fs.primary.RegenerateThumbnail(key)
+	}
+
+	return fs.fallback.RegenerateThumbnail(key)
+}
+
+func (fs *FallbackFileStore) DeleteFiles(keys ...string) error {
+	if err := fs.primary.DeleteFiles(keys...); err != nil {
+		return err
+	}
+
+	return fs.fallback.DeleteFiles(keys...)
+}
+
+func (fs *FallbackFileStore) Walk(fn func(key string, thumbnail bool) error) error {
+	seen := map[string]bool{}
+	seenThumbnails := map[string]bool{}
+
+	visit := func(key string, thumbnail bool) error {
+		if thumbnail {
+			if seenThumbnails[key] {
+				return nil
+			}
+			seenThumbnails[key] = true
+		} else {
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+		}
+
+		return fn(key, thumbnail)
+	}
+
+	if err := fs.primary.Walk(visit); err != nil {
+		return err
+	}
+
+	return fs.fallback.Walk(visit)
+}
+
+func (fs *FallbackFileStore) Quarantine(key string) error {
+	if err := fs.primary.Quarantine(key); err != nil {
+		return err
+	}
+
+	return fs.fallback.Quarantine(key)
+}
+
+func (fs *FallbackFileStore) Exists(key string, thumbnail bool) (bool, error) {
+	exists, err := fs.primary.Exists(key, thumbnail)
+	if err != nil || exists {
+		return exists, err
+	}
+
+	return fs.fallback.Exists(key, thumbnail)
+}
+
+func (fs *FallbackFileStore) PutFile(key string, file io.ReadSeeker) error {
+	return fs.primary.PutFile(key, file)
+}
+
+func (fs *FallbackFileStore) PutFileThumbnail(key string, thumbnail io.ReadSeeker) error {
+	return fs.primary.PutFileThumbnail(key, thumbnail)
+}
@@ -0,0 +1,80 @@
+package filestorage
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func keyOf(content string) string {
+	sum := sha1.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func readAll(t *testing.T, file io.ReadCloser, err error) string {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("opening file: %s", err)
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("reading file: %s", err)
+	}
+
+	return string(content)
+}
+
+// writeStoreFile writes a file in the layout of a file system store, suffix is
+// ".thumb" for thumbnails.
+func writeStoreFile(t *testing.T, directory, key, suffix, content string) {
+	t.Helper()
+
+	shard := fmt.Sprintf("%s/%s", directory, key[0:2])
+	if err := os.MkdirAll(shard, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(fmt.Sprintf("%s/%s%s", shard, key[2:], suffix), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddFileCheck(t *testing.T) {
+	s3Store, _ := newS3TestStore(t, "files")
+
+	stores := map[string]FileStore{
+		"s3":        s3Store,
+		"fs":        NewFileSystemStore(t.TempDir(), ThumbnailPolicy{}),
+		"memory":    NewMemoryStore(ThumbnailPolicy{}),
+		"encrypted": newEncryptedTestStore(t, NewMemoryStore(ThumbnailPolicy{}), EncryptionConfig{Key: generateKey(t)}),
+	}
+
+	refused := errors.New("refused")
+
+	for name, store := range stores {
+		var checked FileDetails
+		_, err := store.AddFile(strings.NewReader("refused file"), func(details FileDetails) error {
+			checked = details
+			return refused
+		})
+		if !errors.Is(err, refused) {
+			t.Errorf("%s: AddFile returned %v, want the error of the check", name, err)
+		}
+
+		if checked.Key != keyOf("refused file") {
+			t.Errorf("%s: the check was given the key %q", name, checked.Key)
+		}
+
+		if exists, _ := store.Exists(keyOf("refused file"), false); exists {
+			t.Errorf("%s: the refused file was stored", name)
+		}
+	}
+}
@@ -1,8 +1,60 @@
 package filestorage
 
+import (
+	"encoding/hex"
+	"io"
+	"time"
+)
+
+// FileDetails is what a store learned about a file while adding it. The
+// dimensions, duration and perceptual hash are only known for images and videos.
+type FileDetails struct {
+	Key      string
+	Width    int
+	Height   int
+	Duration time.Duration
+	// PHash is the perceptual hash of the image or first video frame, only
+	// set when HasPHash is.
+	PHash    uint64
+	HasPHash bool
+}
+
+// UploadCheck can refuse a file being added once its key and details are
+// known, before anything is stored. AddFile returns its error as it is.
+type UploadCheck func(FileDetails) error
+
 type FileStore interface {
-	AddFile([]byte) (string, error)
-	GetFile(string) ([]byte, error)
-	GetFileThumbnail(string) ([]byte, error)
+	// AddFile stores a file under the hash of its content, running the check,
+	// when it isn't nil, first.
+	AddFile(io.Reader, UploadCheck) (FileDetails, error)
+	GetFile(string) (io.ReadSeekCloser, error)
+	GetFileThumbnail(string) (io.ReadSeekCloser, error)
+	// RegenerateThumbnail renders the thumbnail of a stored file again, for
+	// example after the thumbnail policy changed.
+	RegenerateThumbnail(string) error
 	DeleteFiles(...string) error
+	// Walk calls the function with the key of every stored file, and with
+	// thumbnail set for every stored thumbnail, stopping at the first error.
+	Walk(func(key string, thumbnail bool) error) error
+	// Quarantine moves a file and its thumbnail out of the store, keeping
+	// them around for inspection.
+	Quarantine(string) error
+	// Exists reports whether the file, or its thumbnail, is stored.
+	Exists(key string, thumbnail bool) (bool, error)
+	// PutFile stores a file under a key as it is, without hashing it or
+	// rendering a thumbnail. It's used to copy files between stores.
+	PutFile(key string, file io.ReadSeeker) error
+	// PutFileThumbnail stores the thumbnail of a file as it is.
+	PutFileThumbnail(key string, thumbnail io.ReadSeeker) error
+}
+
+// isKey reports whether name looks like a file key, the hex encoded sha1 of
+// the file.
+func isKey(name string) bool {
+	if len(name) != 40 {
+		return false
+	}
+
+	_, err := hex.DecodeString(name)
+	return err == nil
 }
@@ -1,39 +1,44 @@
 package filestorage
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"net/http"
+	"io"
 	"os"
 	"strings"
 	"sync"
-
-	"github.com/h2non/bimg"
 )
 
 type FSFileStore struct {
 	sync.Mutex
 	directoryPath string
+	thumbnails    ThumbnailPolicy
 }
 
-func NewFileSystemStore(path string) *FSFileStore {
+func NewFileSystemStore(path string, thumbnails ThumbnailPolicy) *FSFileStore {
 	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
 		os.Mkdir(path, 0755)
 	}
 
 	return &FSFileStore{
 		directoryPath: path,
+		thumbnails:    thumbnails.WithDefaults(),
 	}
 }
 
-func (fs *FSFileStore) AddFile(file []byte) (string, error) {
-	hash := sha1.New()
-	hash.Write(file)
-	hashSlice := hash.Sum(nil)
+func (fs *FSFileStore) AddFile(file io.Reader, check UploadCheck) (FileDetails, error) {
+	// The temporary file lives inside the store so it can be renamed into place.
+	tmp, hexString, err := spoolFile(fs.directoryPath, file)
+	if err != nil {
+		return FileDetails{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	hexString := hex.EncodeToString(hashSlice)
+	contentType, err := detectContentType(tmp)
+	if err != nil {
+		return FileDetails{}, err
+	}
 
 	directoryPath := fmt.Sprintf("%s/%s", fs.directoryPath, hexString[0:2])
 	if _, err := os.Stat(directoryPath); errors.Is(err, os.ErrNotExist) {
@@ -42,54 +47,56 @@ func (fs *FSFileStore) AddFile(file []byte) (string, error) {
 
 	filePath := fmt.Sprintf("%s/%s/%s", fs.directoryPath, hexString[0:2], hexString[2:])
 
-	_, err := os.Stat(filePath)
-	if err == nil {
-		return hexString, nil
-	}
+	_, err = os.Stat(filePath)
+	exists := err == nil
 
-	if err := os.WriteFile(filePath, file, 0755); err != nil {
-		return "", err
+	details, thumbnail, err := processMedia(tmp, contentType, fs.thumbnails, !exists)
+	if err != nil {
+		return FileDetails{}, err
 	}
+	details.Key = hexString
 
-	contentType := http.DetectContentType(file)
-	if strings.Contains(contentType, "image") {
-		size, err := bimg.Size(file)
-		if err != nil {
-			return "", err
+	if check != nil {
+		if err := check(details); err != nil {
+			return FileDetails{}, err
 		}
+	}
 
-		var resizedImage []byte
-		if size.Width < 600 {
-			resizedImage = file
-		} else {
-			resizedImage, err = bimg.NewImage(file).Resize(size.Width/3, size.Height/3)
-			if err != nil {
-				return "", err
-			}
-		}
+	if exists {
+		return details, nil
+	}
+
+	if err := tmp.Chmod(0644); err != nil {
+		return FileDetails{}, err
+	}
+
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return FileDetails{}, err
+	}
 
+	if thumbnail != nil {
 		thumbPath := fmt.Sprintf("%s/%s/%s.thumb", fs.directoryPath, hexString[0:2], hexString[2:])
 		_, err = os.Stat(thumbPath)
 		if err == nil {
-			return hexString, nil
+			return details, nil
 		}
 
-		if err := os.WriteFile(thumbPath, resizedImage, 0755); err != nil {
-			return "", err
+		if err := os.WriteFile(thumbPath, thumbnail, 0644); err != nil {
+			return FileDetails{}, err
 		}
 	}
 
-	return hexString, nil
+	return details, nil
 }
 
-func (fs *FSFileStore) GetFile(key string) ([]byte, error) {
+func (fs *FSFileStore) GetFile(key string) (io.ReadSeekCloser, error) {
 	directoryPath := fmt.Sprintf("%s/%s", fs.directoryPath, key[0:2])
 	if _, err := os.Stat(directoryPath); errors.Is(err, os.ErrNotExist) {
 		return nil, err
 	}
 
 	filePath := fmt.Sprintf("%s/%s/%s", fs.directoryPath, key[0:2], key[2:])
-	file, err := os.ReadFile(filePath)
+	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
@@ -97,14 +104,14 @@ func (fs *FSFileStore) GetFile(key string) ([]byte, error) {
 	return file, nil
 }
 
-func (fs *FSFileStore) GetFileThumbnail(key string) ([]byte, error) {
+func (fs *FSFileStore) GetFileThumbnail(key string) (io.ReadSeekCloser, error) {
 	directoryPath := fmt.Sprintf("%s/%s", fs.directoryPath, key[0:2])
 	if _, err := os.Stat(directoryPath); errors.Is(err, os.ErrNotExist) {
 		return nil, err
 	}
 
 	filePath := fmt.Sprintf("%s/%s/%s.thumb", fs.directoryPath, key[0:2], key[2:])
-	file, err := os.ReadFile(filePath)
+	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
@@ -112,33 +119,146 @@ func (fs *FSFileStore) GetFileThumbnail(key string) ([]byte, error) {
 	return file, nil
 }
 
-func (fs *FSFileStore) DeleteFiles(keys ...string) error {
-	for _, key := range keys {
-		directoryPath := fmt.Sprintf("%s/%s", fs.directoryPath, key[0:2])
-		if _, err := os.Stat(directoryPath); errors.Is(err, os.ErrNotExist) {
-			return nil
+func (fs *FSFileStore) RegenerateThumbnail(key string) error {
+	original, err := fs.GetFile(key)
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+
+	thumbnail, err := renderThumbnail(original, fs.thumbnails)
+	if err != nil {
+		return err
+	}
+
+	thumbPath := fmt.Sprintf("%s/%s/%s.thumb", fs.directoryPath, key[0:2], key[2:])
+
+	if thumbnail == nil {
+		if err := os.Remove(thumbPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
 		}
 
+		return nil
+	}
+
+	return os.WriteFile(thumbPath, thumbnail, 0644)
+}
+
+func (fs *FSFileStore) DeleteFiles(keys ...string) error {
+	for _, key := range keys {
 		filePath := fmt.Sprintf("%s/%s/%s", fs.directoryPath, key[0:2], key[2:])
-		_, err := os.Stat(filePath)
-		if err == nil {
-			if err := os.Remove(filePath); err != nil {
+
+		// Files without a thumbnail, or already deleted files, are fine.
+		for _, path := range []string{filePath, filePath + ".thumb"} {
+			err := os.Remove(path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
 				return err
 			}
-		} else {
+		}
+	}
+
+	return nil
+}
+
+func (fs *FSFileStore) Walk(fn func(key string, thumbnail bool) error) error {
+	shards, err := os.ReadDir(fs.directoryPath)
+	if err != nil {
+		return err
+	}
+
+	for _, shard := range shards {
+		// Skips temporary uploads and the quarantine.
+		if !shard.IsDir() || len(shard.Name()) != 2 {
+			continue
+		}
+
+		entries, err := os.ReadDir(fmt.Sprintf("%s/%s", fs.directoryPath, shard.Name()))
+		if err != nil {
 			return err
 		}
 
-		filePath = fmt.Sprintf("%s/%s/%s.thumb", fs.directoryPath, key[0:2], key[2:])
-		_, err = os.Stat(filePath)
-		if err == nil {
-			if err := os.Remove(filePath); err != nil {
+		for _, entry := range entries {
+			name, thumbnail := strings.CutSuffix(entry.Name(), ".thumb")
+			key := shard.Name() + name
+
+			if entry.IsDir() || !isKey(key) {
+				continue
+			}
+
+			if err := fn(key, thumbnail); err != nil {
 				return err
 			}
-		} else {
+		}
+	}
+
+	return nil
+}
+
+func (fs *FSFileStore) Quarantine(key string) error {
+	quarantinePath := fmt.Sprintf("%s/quarantine", fs.directoryPath)
+	if err := os.MkdirAll(quarantinePath, 0755); err != nil {
+		return err
+	}
+
+	filePath := fmt.Sprintf("%s/%s/%s", fs.directoryPath, key[0:2], key[2:])
+
+	for _, suffix := range []string{"", ".thumb"} {
+		err := os.Rename(filePath+suffix, fmt.Sprintf("%s/%s%s", quarantinePath, key, suffix))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
 			return err
 		}
 	}
 
 	return nil
 }
+
+func (fs *FSFileStore) Exists(key string, thumbnail bool) (bool, error) {
+	filePath := fmt.Sprintf("%s/%s/%s", fs.directoryPath, key[0:2], key[2:])
+	if thumbnail {
+		filePath += ".thumb"
+	}
+
+	_, err := os.Stat(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// put writes a file through a temporary file, so a partially copied file is
+// never visible under its final path.
+func (fs *FSFileStore) put(filePath string, file io.Reader) error {
+	tmp, err := os.CreateTemp(fs.directoryPath, "upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, file); err != nil {
+		return err
+	}
+
+	if err := tmp.Chmod(0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filePath)
+}
+
+func (fs *FSFileStore) PutFile(key string, file io.ReadSeeker) error {
+	if err := os.MkdirAll(fmt.Sprintf("%s/%s", fs.directoryPath, key[0:2]), 0755); err != nil {
+		return err
+	}
+
+	return fs.put(fmt.Sprintf("%s/%s/%s", fs.directoryPath, key[0:2], key[2:]), file)
+}
+
+func (fs *FSFileStore) PutFileThumbnail(key string, thumbnail io.ReadSeeker) error {
+	if err := os.MkdirAll(fmt.Sprintf("%s/%s", fs.directoryPath, key[0:2]), 0755); err != nil {
+		return err
+	}
+
+	return fs.put(fmt.Sprintf("%s/%s/%s.thumb", fs.directoryPath, key[0:2], key[2:]), thumbnail)
+}
@@ -0,0 +1,192 @@
+package filestorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/h2non/bimg"
+)
+
+var (
+	findFFmpegOnce sync.Once
+	ffmpegPath     string
+	ffprobePath    string
+)
+
+// mediaTimeout bounds how long ffprobe and ffmpeg may take on one file, so a
+// file crafted to stall them can't hold up an upload forever.
+var mediaTimeout = 30 * time.Second
+
+// findFFmpeg looks up the optional ffmpeg and ffprobe executables. Either path
+// is left empty when the executable isn't installed.
+func findFFmpeg() (string, string) {
+	findFFmpegOnce.Do(func() {
+		ffmpegPath, _ = exec.LookPath("ffmpeg")
+		ffprobePath, _ = exec.LookPath("ffprobe")
+	})
+
+	return ffmpegPath, ffprobePath
+}
+
+func isVideo(contentType string) bool {
+	return strings.HasPrefix(contentType, "video/")
+}
+
+func isImage(contentType string) bool {
+	return strings.Contains(contentType, "image")
+}
+
+// processMedia reads the dimensions, duration and perceptual hash of images
+// and videos and, when thumbnail is set, renders a thumbnail for them. Other
+// files get empty details and no thumbnail.
+func processMedia(file *os.File, contentType string, policy ThumbnailPolicy, thumbnail bool) (FileDetails, []byte, error) {
+	if isImage(contentType) {
+		// Images are decoded by libvips from memory, unlike other uploads they
+		// need to be read in full.
+		buf, err := io.ReadAll(file)
+		if err != nil {
+			return FileDetails{}, nil, err
+		}
+
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return FileDetails{}, nil, err
+		}
+
+		size, err := bimg.Size(buf)
+		if err != nil {
+			return FileDetails{}, nil, err
+		}
+
+		details := FileDetails{Width: size.Width, Height: size.Height}
+
+		if hash, err := perceptualHash(buf); err == nil {
+			details.PHash, details.HasPHash = hash, true
+		}
+
+		if !thumbnail {
+			return details, nil, nil
+		}
+
+		thumb, err := makeThumbnail(buf, policy)
+		if err != nil {
+			return FileDetails{}, nil, err
+		}
+
+		return details, thumb, nil
+	}
+
+	if isVideo(contentType) {
+		details := probeVideo(file.Name())
+
+		frame := extractVideoFrame(file.Name())
+		if frame == nil {
+			return details, nil, nil
+		}
+
+		if hash, err := perceptualHash(frame); err == nil {
+			details.PHash, details.HasPHash = hash, true
+		}
+
+		if !thumbnail {
+			return details, nil, nil
+		}
+
+		thumb, err := makeThumbnail(frame, policy)
+		if err != nil {
+			return FileDetails{}, nil, err
+		}
+
+		return details, thumb, nil
+	}
+
+	return FileDetails{}, nil, nil
+}
+
+// probeVideo asks ffprobe for the size of the first video stream and the
+// duration of the file. Missing ffprobe, files it can't read and files it
+// doesn't finish reading within mediaTimeout yield empty details.
+func probeVideo(path string) FileDetails {
+	_, ffprobe := findFFmpeg()
+	if ffprobe == "" {
+		return FileDetails{}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mediaTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, ffprobe,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration",
+		"-of", "json",
+		path,
+	).Output()
+	if err != nil {
+		return FileDetails{}
+	}
+
+	probe := struct {
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}{}
+
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return FileDetails{}
+	}
+
+	var details FileDetails
+	if len(probe.Streams) != 0 {
+		details.Width = probe.Streams[0].Width
+		details.Height = probe.Streams[0].Height
+	}
+
+	seconds, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err == nil {
+		details.Duration = time.Duration(seconds * float64(time.Second))
+	}
+
+	return details
+}
+
+// extractVideoFrame renders the first frame of a video as a JPEG. It returns
+// nil when ffmpeg isn't installed or can't decode the file within mediaTimeout.
+func extractVideoFrame(path string) []byte {
+	ffmpeg, _ := findFFmpeg()
+	if ffmpeg == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mediaTimeout)
+	defer cancel()
+
+	var frame bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, ffmpeg,
+		"-v", "error",
+		"-i", path,
+		"-frames:v", "1",
+		"-f", "image2",
+		"-c:v", "mjpeg",
+		"pipe:1",
+	)
+	cmd.Stdout = &frame
+
+	if err := cmd.Run(); err != nil || frame.Len() == 0 {
+		return nil
+	}
+
+	return frame.Bytes()
+}
@@ -0,0 +1,36 @@
+package filestorage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVideoToolsTimeOut(t *testing.T) {
+	// A stand in for ffmpeg and ffprobe that never finishes.
+	stalled := filepath.Join(t.TempDir(), "stalled")
+	if err := os.WriteFile(stalled, []byte("#!/bin/sh\nexec sleep 10\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	findFFmpeg()
+	previousFFmpeg, previousFFprobe, previousTimeout := ffmpegPath, ffprobePath, mediaTimeout
+	ffmpegPath, ffprobePath, mediaTimeout = stalled, stalled, 100*time.Millisecond
+	t.Cleanup(func() {
+		ffmpegPath, ffprobePath, mediaTimeout = previousFFmpeg, previousFFprobe, previousTimeout
+	})
+
+	start := time.Now()
+
+	if details := probeVideo("video.mp4"); details != (FileDetails{}) {
+		t.Errorf("probing a stalled video returned %+v", details)
+	}
+	if frame := extractVideoFrame("video.mp4"); frame != nil {
+		t.Error("extracting a frame from a stalled video returned a frame")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the stalled tools ran for %s", elapsed)
+	}
+}
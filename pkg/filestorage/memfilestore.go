@@ -0,0 +1,245 @@
+package filestorage
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// MemoryFileStore keeps files and thumbnails in memory. It's meant for tests,
+// everything is gone when the process exits.
+type MemoryFileStore struct {
+	sync.Mutex
+	thumbnails ThumbnailPolicy
+
+	files                 map[string][]byte
+	thumbnailFiles        map[string][]byte
+	quarantined           map[string][]byte
+	quarantinedThumbnails map[string][]byte
+}
+
+func NewMemoryStore(thumbnails ThumbnailPolicy) *MemoryFileStore {
+	return &MemoryFileStore{
+		thumbnails:            thumbnails.WithDefaults(),
+		files:                 map[string][]byte{},
+		thumbnailFiles:        map[string][]byte{},
+		quarantined:           map[string][]byte{},
+		quarantinedThumbnails: map[string][]byte{},
+	}
+}
+
+// memoryFile reads a stored file, which is never modified in place.
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error {
+	return nil
+}
+
+func (fs *MemoryFileStore) AddFile(file io.Reader, check UploadCheck) (FileDetails, error) {
+	// Media is inspected from a temporary file like in the other stores,
+	// ffmpeg needs a path to read videos from.
+	tmp, key, err := spoolFile("", file)
+	if err != nil {
+		return FileDetails{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	contentType, err := detectContentType(tmp)
+	if err != nil {
+		return FileDetails{}, err
+	}
+
+	exists, _ := fs.Exists(key, false)
+
+	details, thumbnail, err := processMedia(tmp, contentType, fs.thumbnails, !exists)
+	if err != nil {
+		return FileDetails{}, err
+	}
+	details.Key = key
+
+	if check != nil {
+		if err := check(details); err != nil {
+			return FileDetails{}, err
+		}
+	}
+
+	if exists {
+		return details, nil
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return FileDetails{}, err
+	}
+
+	content, err := io.ReadAll(tmp)
+	if err != nil {
+		return FileDetails{}, err
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	if thumbnail != nil {
+		fs.thumbnailFiles[key] = thumbnail
+	}
+	fs.files[key] = content
+
+	return details, nil
+}
+
+func (fs *MemoryFileStore) get(files map[string][]byte, key string) (io.ReadSeekCloser, error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	content, ok := files[key]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: key, Err: os.ErrNotExist}
+	}
+
+	return memoryFile{bytes.NewReader(content)}, nil
+}
+
+func (fs *MemoryFileStore) GetFile(key string) (io.ReadSeekCloser, error) {
+	return fs.get(fs.files, key)
+}
+
+func (fs *MemoryFileStore) GetFileThumbnail(key string) (io.ReadSeekCloser, error) {
+	return fs.get(fs.thumbnailFiles, key)
+}
+
+func (fs *MemoryFileStore) RegenerateThumbnail(key string) error {
+	original, err := fs.GetFile(key)
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+
+	thumbnail, err := renderThumbnail(original, fs.thumbnails)
+	if err != nil {
+		return err
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	if thumbnail == nil {
+		delete(fs.thumbnailFiles, key)
+		return nil
+	}
+
+	fs.thumbnailFiles[key] = thumbnail
+
+	return nil
+}
+
+func (fs *MemoryFileStore) DeleteFiles(keys ...string) error {
+	fs.Lock()
+	defer fs.Unlock()
+
+	for _, key := range keys {
+		delete(fs.files, key)
+		delete(fs.thumbnailFiles, key)
+	}
+
+	return nil
+}
+
+func (fs *MemoryFileStore) Walk(fn func(key string, thumbnail bool) error) error {
+	// The keys are collected first, fn may change the store.
+	fs.Lock()
+
+	var keys, thumbnailKeys []string
+	for key := range fs.files {
+		keys = append(keys, key)
+	}
+	for key := range fs.thumbnailFiles {
+		thumbnailKeys = append(thumbnailKeys, key)
+	}
+
+	fs.Unlock()
+
+	sort.Strings(keys)
+	sort.Strings(thumbnailKeys)
+
+	for _, key := range keys {
+		if err := fn(key, false); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range thumbnailKeys {
+		if err := fn(key, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fs *MemoryFileStore) Quarantine(key string) error {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if content, ok := fs.files[key]; ok {
+		fs.quarantined[key] = content
+		delete(fs.files, key)
+	}
+
+	if thumbnail, ok := fs.thumbnailFiles[key]; ok {
+		fs.quarantinedThumbnails[key] = thumbnail
+		delete(fs.thumbnailFiles, key)
+	}
+
+	return nil
+}
+
+// IsQuarantined reports whether a file was moved out of the store by Quarantine.
+func (fs *MemoryFileStore) IsQuarantined(key string) bool {
+	fs.Lock()
+	defer fs.Unlock()
+
+	_, ok := fs.quarantined[key]
+
+	return ok
+}
+
+func (fs *MemoryFileStore) Exists(key string, thumbnail bool) (bool, error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	var ok bool
+	if thumbnail {
+		_, ok = fs.thumbnailFiles[key]
+	} else {
+		_, ok = fs.files[key]
+	}
+
+	return ok, nil
+}
+
+func (fs *MemoryFileStore) put(files map[string][]byte, key string, file io.Reader) error {
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	files[key] = content
+
+	return nil
+}
+
+func (fs *MemoryFileStore) PutFile(key string, file io.ReadSeeker) error {
+	return fs.put(fs.files, key, file)
+}
+
+func (fs *MemoryFileStore) PutFileThumbnail(key string, thumbnail io.ReadSeeker) error {
+	return fs.put(fs.thumbnailFiles, key, thumbnail)
+}
@@ -0,0 +1,99 @@
+package filestorage
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMemoryStoreAddFile(t *testing.T) {
+	store := NewMemoryStore(ThumbnailPolicy{})
+
+	details, err := store.AddFile(strings.NewReader("hello frog"), nil)
+	if err != nil {
+		t.Fatalf("AddFile: %s", err)
+	}
+
+	if details.Key != keyOf("hello frog") {
+		t.Errorf("key is %s, want the sha1 of the content %s", details.Key, keyOf("hello frog"))
+	}
+
+	file, err := store.GetFile(details.Key)
+	if got := readAll(t, file, err); got != "hello frog" {
+		t.Errorf("GetFile returned %q", got)
+	}
+
+	again, err := store.AddFile(strings.NewReader("hello frog"), nil)
+	if err != nil {
+		t.Fatalf("AddFile of the same content: %s", err)
+	}
+	if again.Key != details.Key {
+		t.Errorf("the same content was stored under %s and %s", details.Key, again.Key)
+	}
+
+	// Text files don't get a thumbnail.
+	if exists, _ := store.Exists(details.Key, true); exists {
+		t.Error("a thumbnail was stored for a text file")
+	}
+	if _, err := store.GetFileThumbnail(details.Key); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("GetFileThumbnail returned %v, want a not exist error", err)
+	}
+
+	if err := store.DeleteFiles(details.Key, keyOf("never stored")); err != nil {
+		t.Fatalf("DeleteFiles: %s", err)
+	}
+
+	if exists, _ := store.Exists(details.Key, false); exists {
+		t.Error("the file still exists after DeleteFiles")
+	}
+	if _, err := store.GetFile(details.Key); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("GetFile of a deleted file returned %v, want a not exist error", err)
+	}
+}
+
+func TestMemoryStoreWalkAndQuarantine(t *testing.T) {
+	store := NewMemoryStore(ThumbnailPolicy{})
+
+	first := keyOf("first")
+	second := keyOf("second")
+
+	store.PutFile(first, strings.NewReader("first"))
+	store.PutFile(second, strings.NewReader("second"))
+	store.PutFileThumbnail(second, strings.NewReader("thumbnail"))
+
+	var walked []string
+	err := store.Walk(func(key string, thumbnail bool) error {
+		if thumbnail {
+			key += ".thumb"
+		}
+		walked = append(walked, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %s", err)
+	}
+
+	want := map[string]bool{first: true, second: true, second + ".thumb": true}
+	if len(walked) != len(want) {
+		t.Fatalf("Walk visited %v, want %d entries", walked, len(want))
+	}
+	for _, key := range walked {
+		if !want[key] {
+			t.Errorf("Walk visited unexpected %s", key)
+		}
+	}
+
+	if err := store.Quarantine(second); err != nil {
+		t.Fatalf("Quarantine: %s", err)
+	}
+
+	if !store.IsQuarantined(second) {
+		t.Error("the file isn't quarantined")
+	}
+	for _, thumbnail := range []bool{false, true} {
+		if exists, _ := store.Exists(second, thumbnail); exists {
+			t.Errorf("the quarantined file is still stored (thumbnail %t)", thumbnail)
+		}
+	}
+}
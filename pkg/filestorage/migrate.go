@@ -0,0 +1,59 @@
+package filestorage
+
+import (
+	"errors"
+)
+
+var ErrFileNotFound = errors.New("file not found in the source store")
+
+// CopyFile copies a file and its thumbnail from one store to another. Files
+// the destination already has are skipped, so an interrupted migration can be
+// picked up again. The thumbnail is copied before the file, a file in the
+// destination always has its thumbnail. It reports whether anything was copied.
+func CopyFile(source, destination FileStore, key string) (bool, error) {
+	exists, err := destination.Exists(key, false)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+
+	exists, err = source.Exists(key, false)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, ErrFileNotFound
+	}
+
+	hasThumbnail, err := source.Exists(key, true)
+	if err != nil {
+		return false, err
+	}
+
+	if hasThumbnail {
+		thumbnail, err := source.GetFileThumbnail(key)
+		if err != nil {
+			return false, err
+		}
+
+		err = destination.PutFileThumbnail(key, thumbnail)
+		thumbnail.Close()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	file, err := source.GetFile(key)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	if err := destination.PutFile(key, file); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
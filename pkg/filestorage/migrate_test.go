@@ -0,0 +1,83 @@
+package filestorage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCopyFile(t *testing.T) {
+	source := NewFileSystemStore(t.TempDir(), ThumbnailPolicy{})
+	destination := NewFileSystemStore(t.TempDir(), ThumbnailPolicy{})
+
+	key := keyOf("video")
+	source.PutFile(key, strings.NewReader("video"))
+	source.PutFileThumbnail(key, strings.NewReader("thumbnail"))
+
+	copied, err := CopyFile(source, destination, key)
+	if err != nil || !copied {
+		t.Fatalf("CopyFile returned %t, %v", copied, err)
+	}
+
+	file, err := destination.GetFile(key)
+	if got := readAll(t, file, err); got != "video" {
+		t.Errorf("the copied file is %q", got)
+	}
+
+	thumbnail, err := destination.GetFileThumbnail(key)
+	if got := readAll(t, thumbnail, err); got != "thumbnail" {
+		t.Errorf("the copied thumbnail is %q", got)
+	}
+
+	copied, err = CopyFile(source, destination, key)
+	if err != nil || copied {
+		t.Errorf("copying again returned %t, %v, want the file to be skipped", copied, err)
+	}
+
+	_, err = CopyFile(source, destination, keyOf("never stored"))
+	if !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("copying a missing file returned %v, want ErrFileNotFound", err)
+	}
+}
+
+func TestFallbackStore(t *testing.T) {
+	primary := NewFileSystemStore(t.TempDir(), ThumbnailPolicy{})
+	fallback := NewFileSystemStore(t.TempDir(), ThumbnailPolicy{})
+	store := NewFallbackStore(primary, fallback)
+
+	old := keyOf("old")
+	fallback.PutFile(old, strings.NewReader("old"))
+
+	file, err := store.GetFile(old)
+	if got := readAll(t, file, err); got != "old" {
+		t.Errorf("reading through to the fallback store returned %q", got)
+	}
+
+	details, err := store.AddFile(strings.NewReader("new"), nil)
+	if err != nil {
+		t.Fatalf("AddFile: %s", err)
+	}
+
+	if exists, _ := primary.Exists(details.Key, false); !exists {
+		t.Error("the new file isn't in the primary store")
+	}
+	if exists, _ := fallback.Exists(details.Key, false); exists {
+		t.Error("the new file was written to the fallback store")
+	}
+
+	var walked []string
+	store.Walk(func(key string, thumbnail bool) error {
+		walked = append(walked, key)
+		return nil
+	})
+	if len(walked) != 2 {
+		t.Errorf("Walk visited %v, want the files of both stores", walked)
+	}
+
+	if err := store.DeleteFiles(old); err != nil {
+		t.Fatalf("DeleteFiles: %s", err)
+	}
+	if exists, _ := store.Exists(old, false); exists {
+		t.Error("the file is still in the fallback store after DeleteFiles")
+	}
+}
@@ -0,0 +1,97 @@
+package filestorage
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	_ "image/png"
+	"io"
+	"math/bits"
+	"os"
+
+	"github.com/h2non/bimg"
+)
+
+// perceptualHash computes the dHash of an image. The image is shrunk to 9x8
+// greyscale pixels and every bit records whether a pixel is brighter than its
+// right neighbour, so re-encoded and resized copies of an image get the same
+// or a very close hash.
+func perceptualHash(buf []byte) (uint64, error) {
+	small, err := bimg.NewImage(buf).Process(bimg.Options{
+		Width:          9,
+		Height:         8,
+		Force:          true,
+		Interpretation: bimg.InterpretationBW,
+		Type:           bimg.PNG,
+		StripMetadata:  true,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(small))
+	if err != nil {
+		return 0, err
+	}
+
+	bounds := img.Bounds()
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+			right := color.GrayModel.Convert(img.At(bounds.Min.X+x+1, bounds.Min.Y+y)).(color.Gray).Y
+
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash, nil
+}
+
+// PerceptualHash computes the perceptual hash of an image, or of the first
+// frame of a video. It reports false for other files and videos ffmpeg can't
+// decode.
+func PerceptualHash(file io.Reader) (uint64, bool, error) {
+	tmp, _, err := spoolFile("", file)
+	if err != nil {
+		return 0, false, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	contentType, err := detectContentType(tmp)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var still []byte
+	if isImage(contentType) {
+		still, err = io.ReadAll(tmp)
+		if err != nil {
+			return 0, false, err
+		}
+	} else if isVideo(contentType) {
+		still = extractVideoFrame(tmp.Name())
+	}
+
+	if still == nil {
+		return 0, false, nil
+	}
+
+	hash, err := perceptualHash(still)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return hash, true, nil
+}
+
+// HashDistance counts the bits two perceptual hashes differ in, 0 for copies
+// of the same image and up to 64 for unrelated ones.
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,315 @@
+package filestorage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+type S3FileStore struct {
+	client     *minio.Client
+	bucket     string
+	prefix     string
+	thumbnails ThumbnailPolicy
+}
+
+func NewS3Store(config S3Config, thumbnails ThumbnailPolicy) (*S3FileStore, error) {
+	// Path style lookups keep the store usable with MinIO and other S3 stand-ins
+	// that don't resolve bucket subdomains.
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure:       config.UseSSL,
+		Region:       config.Region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	exists, err := client.BucketExists(ctx, config.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		err = client.MakeBucket(ctx, config.Bucket, minio.MakeBucketOptions{Region: config.Region})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &S3FileStore{
+		client:     client,
+		bucket:     config.Bucket,
+		prefix:     config.Prefix,
+		thumbnails: thumbnails.WithDefaults(),
+	}, nil
+}
+
+func (s3 *S3FileStore) objectName(key string) string {
+	return path.Join(s3.prefix, key[0:2], key[2:])
+}
+
+func (s3 *S3FileStore) thumbnailName(key string) string {
+	return s3.objectName(key) + ".thumb"
+}
+
+func (s3 *S3FileStore) exists(objectName string) (bool, error) {
+	_, err := s3.client.StatObject(context.Background(), s3.bucket, objectName, minio.StatObjectOptions{})
+	if err == nil {
+		return true, nil
+	}
+
+	if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	return false, err
+}
+
+func (s3 *S3FileStore) put(objectName string, file io.Reader, size int64, contentType string) error {
+	_, err := s3.client.PutObject(context.Background(), s3.bucket, objectName, file, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+
+	return err
+}
+
+func (s3 *S3FileStore) get(objectName string) (io.ReadSeekCloser, error) {
+	object, err := s3.client.GetObject(context.Background(), s3.bucket, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// GetObject is lazy, missing objects only show up once the object is used.
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, err
+	}
+
+	return object, nil
+}
+
+func (s3 *S3FileStore) AddFile(file io.Reader, check UploadCheck) (FileDetails, error) {
+	tmp, key, err := spoolFile("", file)
+	if err != nil {
+		return FileDetails{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	contentType, err := detectContentType(tmp)
+	if err != nil {
+		return FileDetails{}, err
+	}
+
+	exists, err := s3.exists(s3.objectName(key))
+	if err != nil {
+		return FileDetails{}, err
+	}
+
+	details, thumbnail, err := processMedia(tmp, contentType, s3.thumbnails, !exists)
+	if err != nil {
+		return FileDetails{}, err
+	}
+	details.Key = key
+
+	if check != nil {
+		if err := check(details); err != nil {
+			return FileDetails{}, err
+		}
+	}
+
+	if exists {
+		return details, nil
+	}
+
+	// The thumbnail goes first so that an existing original always has one.
+	if thumbnail != nil {
+		err := s3.put(s3.thumbnailName(key), bytes.NewReader(thumbnail), int64(len(thumbnail)), http.DetectContentType(thumbnail))
+		if err != nil {
+			return FileDetails{}, err
+		}
+	}
+
+	stat, err := tmp.Stat()
+	if err != nil {
+		return FileDetails{}, err
+	}
+
+	if err := s3.put(s3.objectName(key), tmp, stat.Size(), contentType); err != nil {
+		return FileDetails{}, err
+	}
+
+	return details, nil
+}
+
+func (s3 *S3FileStore) GetFile(key string) (io.ReadSeekCloser, error) {
+	return s3.get(s3.objectName(key))
+}
+
+func (s3 *S3FileStore) GetFileThumbnail(key string) (io.ReadSeekCloser, error) {
+	return s3.get(s3.thumbnailName(key))
+}
+
+func (s3 *S3FileStore) RegenerateThumbnail(key string) error {
+	original, err := s3.GetFile(key)
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+
+	thumbnail, err := renderThumbnail(original, s3.thumbnails)
+	if err != nil {
+		return err
+	}
+
+	if thumbnail == nil {
+		return s3.client.RemoveObject(context.Background(), s3.bucket, s3.thumbnailName(key), minio.RemoveObjectOptions{})
+	}
+
+	return s3.put(s3.thumbnailName(key), bytes.NewReader(thumbnail), int64(len(thumbnail)), http.DetectContentType(thumbnail))
+}
+
+func (s3 *S3FileStore) DeleteFiles(keys ...string) error {
+	ctx := context.Background()
+
+	for _, key := range keys {
+		err := s3.client.RemoveObject(ctx, s3.bucket, s3.objectName(key), minio.RemoveObjectOptions{})
+		if err != nil {
+			return err
+		}
+
+		err = s3.client.RemoveObject(ctx, s3.bucket, s3.thumbnailName(key), minio.RemoveObjectOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s3 *S3FileStore) Walk(fn func(key string, thumbnail bool) error) error {
+	prefix := s3.prefix
+	if prefix != "" {
+		prefix = strings.TrimSuffix(prefix, "/") + "/"
+	}
+
+	objects := s3.client.ListObjects(context.Background(), s3.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	})
+
+	for object := range objects {
+		if object.Err != nil {
+			return object.Err
+		}
+
+		// Skips the quarantine and anything else not laid out like a file.
+		shard, name, found := strings.Cut(strings.TrimPrefix(object.Key, prefix), "/")
+		if !found || len(shard) != 2 {
+			continue
+		}
+
+		name, thumbnail := strings.CutSuffix(name, ".thumb")
+		key := shard + name
+
+		if !isKey(key) {
+			continue
+		}
+
+		if err := fn(key, thumbnail); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s3 *S3FileStore) Quarantine(key string) error {
+	ctx := context.Background()
+
+	for _, suffix := range []string{"", ".thumb"} {
+		objectName := s3.objectName(key) + suffix
+
+		exists, err := s3.exists(objectName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		_, err = s3.client.CopyObject(ctx, minio.CopyDestOptions{
+			Bucket: s3.bucket,
+			Object: path.Join(s3.prefix, "quarantine", key+suffix),
+		}, minio.CopySrcOptions{
+			Bucket: s3.bucket,
+			Object: objectName,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = s3.client.RemoveObject(ctx, s3.bucket, objectName, minio.RemoveObjectOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s3 *S3FileStore) Exists(key string, thumbnail bool) (bool, error) {
+	if thumbnail {
+		return s3.exists(s3.thumbnailName(key))
+	}
+
+	return s3.exists(s3.objectName(key))
+}
+
+// putSeeker uploads a file of a known size, sniffing its content type.
+func (s3 *S3FileStore) putSeeker(objectName string, file io.ReadSeeker) error {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	contentType, err := detectContentType(file)
+	if err != nil {
+		return err
+	}
+
+	return s3.put(objectName, file, size, contentType)
+}
+
+func (s3 *S3FileStore) PutFile(key string, file io.ReadSeeker) error {
+	return s3.putSeeker(s3.objectName(key), file)
+}
+
+func (s3 *S3FileStore) PutFileThumbnail(key string, thumbnail io.ReadSeeker) error {
+	return s3.putSeeker(s3.thumbnailName(key), thumbnail)
+}
@@ -0,0 +1,348 @@
+package filestorage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-process stand-in for S3 with just what S3FileStore uses:
+// path style buckets and single part uploads, downloads, copies, deletes and
+// listings.
+type fakeS3 struct {
+	sync.Mutex
+	buckets map[string]map[string][]byte
+}
+
+// fakeModTime is when every object of the fake was last modified.
+var fakeModTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newFakeS3(t *testing.T) (*fakeS3, string) {
+	fake := &fakeS3{buckets: map[string]map[string][]byte{}}
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return fake, strings.TrimPrefix(server.URL, "http://")
+}
+
+// objectNames lists the objects of a bucket, sorted.
+func (f *fakeS3) objectNames(bucket string) []string {
+	f.Lock()
+	defer f.Unlock()
+
+	var names []string
+	for name := range f.buckets[bucket] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	bucketName, objectName, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	bucket, bucketExists := f.buckets[bucketName]
+
+	if objectName == "" {
+		switch r.Method {
+		case http.MethodHead:
+			if !bucketExists {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			f.buckets[bucketName] = map[string][]byte{}
+		case http.MethodGet:
+			f.list(w, r, bucketName, bucket)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+		return
+	}
+
+	if !bucketExists {
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			_, sourceName, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+			content, ok := bucket[sourceName]
+			if !ok {
+				s3Error(w, http.StatusNotFound, "NoSuchKey")
+				return
+			}
+
+			bucket[objectName] = content
+			fmt.Fprintf(w, `<CopyObjectResult><LastModified>%s</LastModified><ETag>"etag"</ETag></CopyObjectResult>`, time.Now().UTC().Format(time.RFC3339))
+			return
+		}
+
+		content, err := readPayload(r)
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+
+		bucket[objectName] = content
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		content, ok := bucket[objectName]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Content-Type", http.DetectContentType(content))
+		http.ServeContent(w, r, objectName, fakeModTime, bytes.NewReader(content))
+	case http.MethodDelete:
+		delete(bucket, objectName)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request, bucketName string, bucket map[string][]byte) {
+	type object struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+	}
+
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		MaxKeys     int
+		IsTruncated bool
+		Contents    []object
+	}{Name: bucketName, Prefix: r.URL.Query().Get("prefix"), MaxKeys: 1000}
+
+	var names []string
+	for name := range bucket {
+		if strings.HasPrefix(name, result.Prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		result.Contents = append(result.Contents, object{
+			Key:          name,
+			LastModified: fakeModTime.Format(time.RFC3339),
+			ETag:         `"etag"`,
+			Size:         len(bucket[name]),
+			StorageClass: "STANDARD",
+		})
+	}
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+// readPayload reads the body of an upload, which the client sends in signed
+// chunks over plain HTTP.
+func readPayload(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	var content []byte
+	body := bufio.NewReader(r.Body)
+
+	for {
+		header, err := body.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return content, nil
+		}
+
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(body, chunk); err != nil {
+			return nil, err
+		}
+
+		content = append(content, chunk[:size]...)
+	}
+}
+
+func newS3TestStore(t *testing.T, prefix string) (*S3FileStore, *fakeS3) {
+	t.Helper()
+
+	fake, endpoint := newFakeS3(t)
+
+	store, err := NewS3Store(S3Config{
+		Endpoint:  endpoint,
+		Region:    "us-east-1",
+		Bucket:    "frogboard",
+		Prefix:    prefix,
+		AccessKey: "access",
+		SecretKey: "secret",
+	}, ThumbnailPolicy{})
+	if err != nil {
+		t.Fatalf("NewS3Store: %s", err)
+	}
+
+	return store, fake
+}
+
+func TestS3Store(t *testing.T) {
+	store, fake := newS3TestStore(t, "files")
+
+	details, err := store.AddFile(strings.NewReader("hello frog"), nil)
+	if err != nil {
+		t.Fatalf("AddFile: %s", err)
+	}
+
+	key := details.Key
+	if key != keyOf("hello frog") {
+		t.Errorf("the key %s isn't the sha1 of the content", key)
+	}
+
+	// Adding the same file again keeps the stored one.
+	if again, err := store.AddFile(strings.NewReader("hello frog"), nil); err != nil || again.Key != key {
+		t.Errorf("adding the file again returned %+v, %v", again, err)
+	}
+
+	if err := store.PutFileThumbnail(key, strings.NewReader("thumbnail")); err != nil {
+		t.Fatalf("PutFileThumbnail: %s", err)
+	}
+
+	other := keyOf("other")
+	if err := store.PutFile(other, strings.NewReader("other")); err != nil {
+		t.Fatalf("PutFile: %s", err)
+	}
+
+	want := []string{
+		fmt.Sprintf("files/%s/%s", key[0:2], key[2:]),
+		fmt.Sprintf("files/%s/%s.thumb", key[0:2], key[2:]),
+		fmt.Sprintf("files/%s/%s", other[0:2], other[2:]),
+	}
+	sort.Strings(want)
+
+	if got := fake.objectNames("frogboard"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("the bucket holds %v, want %v", got, want)
+	}
+
+	file, err := store.GetFile(key)
+	if got := readAll(t, file, err); got != "hello frog" {
+		t.Errorf("GetFile returned %q", got)
+	}
+
+	file, err = store.GetFile(key)
+	if err != nil {
+		t.Fatalf("GetFile: %s", err)
+	}
+	file.Seek(6, io.SeekStart)
+	if got := readAll(t, file, nil); got != "frog" {
+		t.Errorf("reading after a seek returned %q", got)
+	}
+
+	thumbnail, err := store.GetFileThumbnail(key)
+	if got := readAll(t, thumbnail, err); got != "thumbnail" {
+		t.Errorf("GetFileThumbnail returned %q", got)
+	}
+
+	if _, err := store.GetFileThumbnail(other); err == nil {
+		t.Error("getting a thumbnail that was never stored succeeded")
+	}
+
+	// Objects not laid out like files are skipped.
+	fake.buckets["frogboard"]["files/readme.txt"] = []byte("not a file")
+	fake.buckets["frogboard"]["elsewhere/"+key[0:2]+"/"+key[2:]] = []byte("outside the prefix")
+
+	var walked []string
+	err = store.Walk(func(key string, thumbnail bool) error {
+		walked = append(walked, fmt.Sprintf("%s %t", key, thumbnail))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %s", err)
+	}
+	sort.Strings(walked)
+
+	wantWalked := []string{key + " false", key + " true", other + " false"}
+	sort.Strings(wantWalked)
+	if fmt.Sprint(walked) != fmt.Sprint(wantWalked) {
+		t.Errorf("Walk visited %v, want %v", walked, wantWalked)
+	}
+
+	if err := store.Quarantine(other); err != nil {
+		t.Fatalf("Quarantine: %s", err)
+	}
+	if exists, _ := store.Exists(other, false); exists {
+		t.Error("the quarantined file is still in the store")
+	}
+
+	if err := store.DeleteFiles(key); err != nil {
+		t.Fatalf("DeleteFiles: %s", err)
+	}
+	for _, thumbnail := range []bool{false, true} {
+		if exists, err := store.Exists(key, thumbnail); exists || err != nil {
+			t.Errorf("Exists(thumbnail: %t) after DeleteFiles returned %t, %v", thumbnail, exists, err)
+		}
+	}
+
+	want = []string{"elsewhere/" + key[0:2] + "/" + key[2:], "files/quarantine/" + other, "files/readme.txt"}
+	if got := fake.objectNames("frogboard"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("the bucket holds %v after deleting, want %v", got, want)
+	}
+}
+
+func TestS3StoreWithoutPrefix(t *testing.T) {
+	store, fake := newS3TestStore(t, "")
+
+	details, err := store.AddFile(strings.NewReader("no prefix"), nil)
+	if err != nil {
+		t.Fatalf("AddFile: %s", err)
+	}
+
+	want := []string{details.Key[0:2] + "/" + details.Key[2:]}
+	if got := fake.objectNames("frogboard"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("the bucket holds %v, want %v", got, want)
+	}
+
+	var walked []string
+	store.Walk(func(key string, thumbnail bool) error {
+		walked = append(walked, key)
+		return nil
+	})
+	if len(walked) != 1 || walked[0] != details.Key {
+		t.Errorf("Walk visited %v", walked)
+	}
+}
@@ -0,0 +1,141 @@
+package filestorage
+
+import (
+	"io"
+	"math"
+	"os"
+
+	"github.com/h2non/bimg"
+)
+
+// ThumbnailPolicy describes how thumbnails are rendered. Thumbnails are shared
+// by every post using a file, so they are rendered to fit the larger of the
+// thread and reply boxes and the smaller box is applied when they're displayed.
+type ThumbnailPolicy struct {
+	MaxWidth       int
+	MaxHeight      int
+	ReplyMaxWidth  int
+	ReplyMaxHeight int
+	// Format is either "webp", "jpeg" or "png".
+	Format  string
+	Quality int
+}
+
+var DefaultThumbnailPolicy = ThumbnailPolicy{
+	MaxWidth:       250,
+	MaxHeight:      250,
+	ReplyMaxWidth:  150,
+	ReplyMaxHeight: 150,
+	Format:         "webp",
+	Quality:        80,
+}
+
+// WithDefaults fills in every unset field from DefaultThumbnailPolicy.
+func (p ThumbnailPolicy) WithDefaults() ThumbnailPolicy {
+	if p.MaxWidth <= 0 {
+		p.MaxWidth = DefaultThumbnailPolicy.MaxWidth
+	}
+	if p.MaxHeight <= 0 {
+		p.MaxHeight = DefaultThumbnailPolicy.MaxHeight
+	}
+	if p.ReplyMaxWidth <= 0 {
+		p.ReplyMaxWidth = DefaultThumbnailPolicy.ReplyMaxWidth
+	}
+	if p.ReplyMaxHeight <= 0 {
+		p.ReplyMaxHeight = DefaultThumbnailPolicy.ReplyMaxHeight
+	}
+	if p.Format == "" {
+		p.Format = DefaultThumbnailPolicy.Format
+	}
+	if p.Quality <= 0 || p.Quality > 100 {
+		p.Quality = DefaultThumbnailPolicy.Quality
+	}
+
+	return p
+}
+
+// Box returns the bounding box thumbnails are displayed in for a post of the
+// given type, "thread" or "reply".
+func (p ThumbnailPolicy) Box(postType string) (int, int) {
+	if postType == "reply" {
+		return p.ReplyMaxWidth, p.ReplyMaxHeight
+	}
+
+	return p.MaxWidth, p.MaxHeight
+}
+
+func (p ThumbnailPolicy) imageType() bimg.ImageType {
+	switch p.Format {
+	case "jpeg", "jpg":
+		return bimg.JPEG
+	case "png":
+		return bimg.PNG
+	default:
+		return bimg.WEBP
+	}
+}
+
+// fit scales width and height down to fit in the larger of the policy's boxes.
+func (p ThumbnailPolicy) fit(width, height int) (int, int) {
+	maxWidth := p.MaxWidth
+	if p.ReplyMaxWidth > maxWidth {
+		maxWidth = p.ReplyMaxWidth
+	}
+
+	maxHeight := p.MaxHeight
+	if p.ReplyMaxHeight > maxHeight {
+		maxHeight = p.ReplyMaxHeight
+	}
+
+	scale := math.Min(float64(maxWidth)/float64(width), float64(maxHeight)/float64(height))
+	if scale >= 1 {
+		return width, height
+	}
+
+	fittedWidth := int(math.Max(math.Round(float64(width)*scale), 1))
+	fittedHeight := int(math.Max(math.Round(float64(height)*scale), 1))
+
+	return fittedWidth, fittedHeight
+}
+
+func makeThumbnail(image []byte, policy ThumbnailPolicy) ([]byte, error) {
+	size, err := bimg.Size(image)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := policy.fit(size.Width, size.Height)
+
+	return bimg.NewImage(image).Process(bimg.Options{
+		Width:         width,
+		Height:        height,
+		Type:          policy.imageType(),
+		Quality:       policy.Quality,
+		StripMetadata: true,
+		// Only used when flattening transparent images into JPEGs.
+		Background: bimg.Color{R: 255, G: 255, B: 255},
+	})
+}
+
+// renderThumbnail makes a thumbnail for an already stored original. It returns
+// nil when the file doesn't get a thumbnail.
+func renderThumbnail(original io.Reader, policy ThumbnailPolicy) ([]byte, error) {
+	tmp, _, err := spoolFile("", original)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	contentType, err := detectContentType(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	_, thumbnail, err := processMedia(tmp, contentType, policy, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return thumbnail, nil
+}
@@ -0,0 +1,43 @@
+package filestorage
+
+import "testing"
+
+func TestThumbnailFit(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        ThumbnailPolicy
+		width, height int
+		wantW, wantH  int
+	}{
+		{"smaller than the box", ThumbnailPolicy{MaxWidth: 250, MaxHeight: 200}, 100, 100, 100, 100},
+		{"wide", ThumbnailPolicy{MaxWidth: 250, MaxHeight: 200}, 1000, 500, 250, 125},
+		{"tall", ThumbnailPolicy{MaxWidth: 250, MaxHeight: 200}, 500, 1000, 100, 200},
+		{"very wide", ThumbnailPolicy{MaxWidth: 250, MaxHeight: 200}, 5000, 10, 250, 1},
+		// Thumbnails fit the larger of the thread and reply boxes.
+		{"larger reply box", ThumbnailPolicy{MaxWidth: 100, MaxHeight: 100, ReplyMaxWidth: 300, ReplyMaxHeight: 200}, 1000, 1000, 200, 200},
+		{"wider reply box", ThumbnailPolicy{MaxWidth: 100, MaxHeight: 400, ReplyMaxWidth: 300, ReplyMaxHeight: 50}, 1200, 600, 300, 150},
+	}
+
+	for _, test := range tests {
+		width, height := test.policy.WithDefaults().fit(test.width, test.height)
+		if width != test.wantW || height != test.wantH {
+			t.Errorf("%s: fit(%d, %d) = %d, %d, want %d, %d", test.name, test.width, test.height, width, height, test.wantW, test.wantH)
+		}
+	}
+}
+
+func TestThumbnailBox(t *testing.T) {
+	policy := ThumbnailPolicy{MaxWidth: 250, MaxHeight: 200, ReplyMaxWidth: 150, ReplyMaxHeight: 100}
+
+	if width, height := policy.Box("thread"); width != 250 || height != 200 {
+		t.Errorf("the thread box is %dx%d", width, height)
+	}
+	if width, height := policy.Box("reply"); width != 150 || height != 100 {
+		t.Errorf("the reply box is %dx%d", width, height)
+	}
+
+	defaults := ThumbnailPolicy{}.WithDefaults()
+	if width, height := defaults.Box("reply"); width != DefaultThumbnailPolicy.ReplyMaxWidth || height != DefaultThumbnailPolicy.ReplyMaxHeight {
+		t.Errorf("the default reply box is %dx%d", width, height)
+	}
+}
@@ -0,0 +1,49 @@
+package filestorage
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+)
+
+// spoolFile copies an upload into a temporary file inside dir, hashing it on
+// the way so the whole file never has to be held in memory. The returned file
+// is positioned at its start and should be closed and removed by the caller.
+func spoolFile(dir string, file io.Reader) (*os.File, string, error) {
+	tmp, err := os.CreateTemp(dir, "upload-*")
+	if err != nil {
+		return nil, "", err
+	}
+
+	hash := sha1.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), file); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, "", err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, "", err
+	}
+
+	return tmp, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func detectContentType(file io.ReadSeeker) (string, error) {
+	header := make([]byte, 512)
+
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return http.DetectContentType(header[:n]), nil
+}
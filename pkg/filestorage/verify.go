@@ -0,0 +1,185 @@
+package filestorage
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// VerifyOptions choose what Verify repairs on top of reporting problems.
+type VerifyOptions struct {
+	// RegenerateThumbnails renders the missing thumbnails of intact files.
+	RegenerateThumbnails bool
+	// Quarantine moves files whose content doesn't match their key out of the store.
+	Quarantine bool
+	// Progress, when set, is called before each stored file is rehashed.
+	Progress func(done, total int, key string)
+}
+
+// VerifyReport lists the problems Verify found, each as a list of file keys.
+type VerifyReport struct {
+	// Checked is the number of stored files that were rehashed.
+	Checked int
+	// Missing files are tracked but not stored.
+	Missing []string
+	// Corrupt files are stored but their content doesn't hash to their key.
+	Corrupt []string
+	// Untracked files are stored but not tracked.
+	Untracked []string
+	// MissingThumbnails belong to tracked images and videos.
+	MissingThumbnails []string
+	// UnthumbnailedVideos are videos without a thumbnail while ffmpeg, which
+	// renders video thumbnails, isn't installed. They aren't problems of the
+	// store.
+	UnthumbnailedVideos []string
+	// UntrackedThumbnails are stored without their file.
+	UntrackedThumbnails []string
+
+	RegeneratedThumbnails []string
+	Quarantined           []string
+	// Failures describe files that couldn't be read and repairs that didn't work.
+	Failures []string
+}
+
+// OK reports whether the store had no problems.
+func (r VerifyReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Corrupt) == 0 && len(r.Untracked) == 0 &&
+		len(r.MissingThumbnails) == 0 && len(r.UntrackedThumbnails) == 0
+}
+
+// hashFile returns the key a stored file should have.
+func hashFile(store FileStore, key string) (string, error) {
+	file, err := store.GetFile(key)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha1.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Verify checks every file in the store against tracked, the content types of
+// the files that should be stored keyed by their key. Thumbnails are expected
+// for images, and for videos when ffmpeg is installed.
+func Verify(store FileStore, tracked map[string]string, options VerifyOptions) (VerifyReport, error) {
+	var report VerifyReport
+
+	stored := map[string]bool{}
+	thumbnails := map[string]bool{}
+
+	err := store.Walk(func(key string, thumbnail bool) error {
+		if thumbnail {
+			thumbnails[key] = true
+		} else {
+			stored[key] = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		return VerifyReport{}, err
+	}
+
+	var keys []string
+	for key := range stored {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	corrupt := map[string]bool{}
+
+	for i, key := range keys {
+		if options.Progress != nil {
+			options.Progress(i, len(keys), key)
+		}
+
+		hash, err := hashFile(store, key)
+		// Encrypted files that fail authentication are as good as corrupt.
+		if errors.Is(err, ErrCorruptEncryptedFile) {
+			err = nil
+		}
+		if err != nil {
+			report.Failures = append(report.Failures, fmt.Sprintf("reading %s: %s", key, err.Error()))
+			continue
+		}
+		report.Checked++
+
+		if hash != key {
+			corrupt[key] = true
+			report.Corrupt = append(report.Corrupt, key)
+		}
+
+		if _, ok := tracked[key]; !ok {
+			report.Untracked = append(report.Untracked, key)
+		}
+	}
+
+	ffmpeg, _ := findFFmpeg()
+
+	var trackedKeys []string
+	for key := range tracked {
+		trackedKeys = append(trackedKeys, key)
+	}
+	sort.Strings(trackedKeys)
+
+	for _, key := range trackedKeys {
+		if !stored[key] {
+			report.Missing = append(report.Missing, key)
+			continue
+		}
+
+		contentType := tracked[key]
+		switch {
+		case thumbnails[key]:
+		case isVideo(contentType) && ffmpeg == "":
+			report.UnthumbnailedVideos = append(report.UnthumbnailedVideos, key)
+		case isImage(contentType) || isVideo(contentType):
+			report.MissingThumbnails = append(report.MissingThumbnails, key)
+		}
+	}
+
+	var thumbnailKeys []string
+	for key := range thumbnails {
+		if !stored[key] {
+			thumbnailKeys = append(thumbnailKeys, key)
+		}
+	}
+	sort.Strings(thumbnailKeys)
+	report.UntrackedThumbnails = thumbnailKeys
+
+	if options.RegenerateThumbnails {
+		for _, key := range report.MissingThumbnails {
+			if corrupt[key] {
+				continue
+			}
+
+			if err := store.RegenerateThumbnail(key); err != nil {
+				report.Failures = append(report.Failures, fmt.Sprintf("regenerating thumbnail of %s: %s", key, err.Error()))
+				continue
+			}
+
+			report.RegeneratedThumbnails = append(report.RegeneratedThumbnails, key)
+		}
+	}
+
+	if options.Quarantine {
+		for _, key := range report.Corrupt {
+			if err := store.Quarantine(key); err != nil {
+				report.Failures = append(report.Failures, fmt.Sprintf("quarantining %s: %s", key, err.Error()))
+				continue
+			}
+
+			report.Quarantined = append(report.Quarantined, key)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,104 @@
+package filestorage
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	directory := t.TempDir()
+	store := NewFileSystemStore(directory, ThumbnailPolicy{})
+
+	intact := keyOf("intact")
+	corrupt := keyOf("corrupt")
+	untracked := keyOf("untracked")
+	missing := keyOf("missing")
+	image := keyOf("image")
+	orphanThumbnail := keyOf("orphan")
+
+	writeStoreFile(t, directory, intact, "", "intact")
+	writeStoreFile(t, directory, corrupt, "", "bit rot")
+	writeStoreFile(t, directory, untracked, "", "untracked")
+	writeStoreFile(t, directory, image, "", "image")
+	writeStoreFile(t, directory, orphanThumbnail, ".thumb", "thumbnail")
+
+	tracked := map[string]string{
+		intact:  "text/plain; charset=utf-8",
+		corrupt: "text/plain; charset=utf-8",
+		missing: "text/plain; charset=utf-8",
+		image:   "image/png",
+	}
+
+	report, err := Verify(store, tracked, VerifyOptions{Quarantine: true})
+	if err != nil {
+		t.Fatalf("Verify: %s", err)
+	}
+
+	want := VerifyReport{
+		Checked:             4,
+		Missing:             []string{missing},
+		Corrupt:             []string{corrupt},
+		Untracked:           []string{untracked},
+		MissingThumbnails:   []string{image},
+		UntrackedThumbnails: []string{orphanThumbnail},
+		Quarantined:         []string{corrupt},
+	}
+
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("Verify reported\n%+v\nwant\n%+v", report, want)
+	}
+
+	if report.OK() {
+		t.Error("the report is OK despite the problems")
+	}
+
+	if _, err := os.Stat(fmt.Sprintf("%s/quarantine/%s", directory, corrupt)); err != nil {
+		t.Errorf("the corrupt file wasn't quarantined: %s", err)
+	}
+}
+
+func TestVerifyOK(t *testing.T) {
+	store := NewFileSystemStore(t.TempDir(), ThumbnailPolicy{})
+
+	details, err := store.AddFile(strings.NewReader("fine"), nil)
+	if err != nil {
+		t.Fatalf("AddFile: %s", err)
+	}
+
+	report, err := Verify(store, map[string]string{details.Key: "text/plain; charset=utf-8"}, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("Verify: %s", err)
+	}
+
+	if !report.OK() || report.Checked != 1 {
+		t.Errorf("Verify reported %+v for an intact store", report)
+	}
+}
+
+func TestVerifyVideosWithoutFFmpeg(t *testing.T) {
+	findFFmpeg()
+	previousFFmpeg := ffmpegPath
+	ffmpegPath = ""
+	t.Cleanup(func() { ffmpegPath = previousFFmpeg })
+
+	directory := t.TempDir()
+	store := NewFileSystemStore(directory, ThumbnailPolicy{})
+
+	video := keyOf("video")
+	writeStoreFile(t, directory, video, "", "video")
+
+	report, err := Verify(store, map[string]string{video: "video/webm"}, VerifyOptions{RegenerateThumbnails: true})
+	if err != nil {
+		t.Fatalf("Verify: %s", err)
+	}
+
+	if !report.OK() || !reflect.DeepEqual(report.UnthumbnailedVideos, []string{video}) {
+		t.Errorf("Verify reported %+v for a video that can't get a thumbnail", report)
+	}
+	if len(report.RegeneratedThumbnails) != 0 || len(report.Failures) != 0 {
+		t.Errorf("Verify tried to render the thumbnail of the video: %+v", report)
+	}
+}
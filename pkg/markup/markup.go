@@ -0,0 +1,61 @@
+// Package markup renders the content of posts to HTML.
+//
+// Lines starting with ">" are quotes, ">>123" and ">>>/board/123" cite posts
+// and http and https URLs become links. Text can be **bold**, *italic*,
+// ~~struck through~~, `code` or hidden in a [spoiler][/spoiler], which may
+// span several lines. Lines between two "```" lines are a code block. All
+// other text is escaped, so the HTML can be put in a page as is.
+package markup
+
+import (
+	"strings"
+)
+
+// Options are what the HTML of a post depends on besides its content.
+type Options struct {
+	// BoardID is the board of the post, citations without a board are to
+	// posts on it.
+	BoardID string
+	// Exists tells if a cited post exists, citations of posts that don't are
+	// struck through instead of linked. All posts exist when it's nil.
+	Exists func(boardId string, postId uint) bool
+}
+
+// Render renders the content of a post to HTML.
+func Render(content string, options Options) string {
+	var b strings.Builder
+	render(&b, parse(content), options)
+
+	return b.String()
+}
+
+// Citation is a post cited in the content of a post. BoardID is empty for
+// posts on the same board.
+type Citation struct {
+	BoardID string
+	PostID  uint
+}
+
+// Citations lists the posts cited in the content of a post, in the order they
+// are cited. Citations in code aren't included.
+func Citations(content string) []Citation {
+	var citations []Citation
+
+	var walk func(nodes []node)
+	walk = func(nodes []node) {
+		for _, n := range nodes {
+			if n.kind == citationNode {
+				citations = append(citations, Citation{BoardID: n.board, PostID: n.post})
+			}
+			walk(n.children)
+		}
+	}
+
+	for _, block := range parse(content) {
+		for _, line := range block.lines {
+			walk(line.nodes)
+		}
+	}
+
+	return citations
+}
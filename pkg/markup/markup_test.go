@@ -0,0 +1,218 @@
+package markup
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+var testOptions = Options{BoardID: "b"}
+
+// TestGolden renders every testdata/*.txt and compares it with the .html
+// file next to it. Run the tests with -update after changing the output on
+// purpose and check the diff of the golden files.
+func TestGolden(t *testing.T) {
+	inputs, err := filepath.Glob("testdata/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".txt")
+
+		t.Run(name, func(t *testing.T) {
+			content, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := Render(string(content), testOptions)
+			checkSafe(t, got)
+
+			golden := strings.TrimSuffix(input, ".txt") + ".html"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("reading the golden file, run the tests with -update to create it: %s", err)
+			}
+
+			if got != string(want) {
+				t.Errorf("rendering %s\ngot:\n%s\nwant:\n%s", input, got, want)
+			}
+		})
+	}
+}
+
+func TestCitations(t *testing.T) {
+	content := ">>1 >> 2\n>>>/a/3 `>>4`\n```\n>>5\n```\n[spoiler]**>>6**[/spoiler] >>99999999999"
+
+	want := []Citation{
+		{PostID: 1},
+		{PostID: 2},
+		{BoardID: "a", PostID: 3},
+		{PostID: 6},
+	}
+
+	if got := Citations(content); !reflect.DeepEqual(got, want) {
+		t.Errorf("Citations() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDeadCitations(t *testing.T) {
+	options := Options{
+		BoardID: "b",
+		Exists: func(boardId string, postId uint) bool {
+			return boardId == "b" && postId == 1
+		},
+	}
+
+	got := Render(">>1 >>2 >>>/a/1", options)
+	checkSafe(t, got)
+
+	want := `<a data-board="b" data-post="1" class="post-link text-blue-500" href="/b/1/">&gt;&gt; 1</a> ` +
+		`<span class="dead-link line-through text-gray-500">&gt;&gt; 2</span> ` +
+		`<span class="dead-link line-through text-gray-500">&gt;&gt;&gt;/a/1</span>`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// longRuns are lines of markers that used to take quadratic time to parse.
+var longRuns = []string{
+	strings.Repeat("*", 200000),
+	"a" + strings.Repeat("*", 200000),
+	strings.Repeat("a*", 100000),
+	strings.Repeat("**a", 70000),
+	strings.Repeat("~", 200000),
+	strings.Repeat("`", 200000),
+}
+
+func TestLongRuns(t *testing.T) {
+	for _, content := range longRuns {
+		start := time.Now()
+		checkSafe(t, Render(content, testOptions))
+
+		// Parsing is linear and takes milliseconds, the bound only catches
+		// it going quadratic again.
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("rendering %q... took %s", content[:10], elapsed)
+		}
+	}
+}
+
+func BenchmarkLongRuns(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		for _, content := range longRuns {
+			Render(content, testOptions)
+		}
+	}
+}
+
+func FuzzRender(f *testing.F) {
+	inputs, _ := filepath.Glob("testdata/*.txt")
+	for _, input := range inputs {
+		content, err := os.ReadFile(input)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(content))
+	}
+
+	f.Fuzz(func(t *testing.T, content string) {
+		checkSafe(t, Render(content, testOptions))
+	})
+}
+
+// The tags and attributes the renderer may output, anything else in the
+// HTML means some of the content wasn't escaped.
+var allowedTags = map[string][]string{
+	"a":      {"class", "data-board", "data-post", "href", "rel", "target"},
+	"br":     nil,
+	"code":   {"class"},
+	"em":     nil,
+	"pre":    {"class"},
+	"s":      nil,
+	"span":   {"class"},
+	"strong": nil,
+}
+
+var tagRegex = regexp.MustCompile(`<(/?)([a-z]+)((?: [a-z-]+="[^"<>]*")*)>`)
+var attributeRegex = regexp.MustCompile(` ([a-z-]+)="([^"<>]*)"`)
+
+func checkSafe(t *testing.T, output string) {
+	t.Helper()
+
+	var open []string
+
+	last := 0
+	for _, match := range tagRegex.FindAllStringSubmatchIndex(output, -1) {
+		if text := output[last:match[0]]; strings.ContainsAny(text, "<>") {
+			t.Fatalf("unescaped text %q in %q", text, output)
+		}
+		last = match[1]
+
+		closing := output[match[2]:match[3]] == "/"
+		tag := output[match[4]:match[5]]
+		attributes := output[match[6]:match[7]]
+
+		allowed, ok := allowedTags[tag]
+		if !ok {
+			t.Fatalf("unexpected tag <%s> in %q", tag, output)
+		}
+
+		for _, attribute := range attributeRegex.FindAllStringSubmatch(attributes, -1) {
+			if !contains(allowed, attribute[1]) {
+				t.Fatalf("unexpected attribute %s on <%s> in %q", attribute[1], tag, output)
+			}
+
+			if attribute[1] == "href" {
+				href := attribute[2]
+				if !strings.HasPrefix(href, "http://") && !strings.HasPrefix(href, "https://") && (!strings.HasPrefix(href, "/") || strings.HasPrefix(href, "//")) {
+					t.Fatalf("unexpected link %q in %q", href, output)
+				}
+			}
+		}
+
+		switch {
+		case tag == "br":
+		case closing:
+			if len(open) == 0 || open[len(open)-1] != tag {
+				t.Fatalf("unbalanced </%s> in %q", tag, output)
+			}
+			open = open[:len(open)-1]
+		default:
+			open = append(open, tag)
+		}
+	}
+
+	if text := output[last:]; strings.ContainsAny(text, "<>") {
+		t.Fatalf("unescaped text %q in %q", text, output)
+	}
+
+	if len(open) != 0 {
+		t.Fatalf("unclosed tags %v in %q", open, output)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
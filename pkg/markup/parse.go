@@ -0,0 +1,461 @@
+package markup
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type nodeKind int
+
+const (
+	textNode nodeKind = iota
+	codeNode
+	boldNode
+	italicNode
+	strikeNode
+	spoilerNode
+	linkNode
+	citationNode
+)
+
+// node is a piece of a line. Text, code and links only have text, the
+// formatting nodes have children and citations have the post they cite.
+type node struct {
+	kind     nodeKind
+	text     string
+	board    string
+	post     uint
+	children []node
+}
+
+// line is a line of text, quote lines start with ">" and are greentext.
+type line struct {
+	quote bool
+	nodes []node
+}
+
+// block is either a fenced code block, kept as is, or lines of text.
+type block struct {
+	code  bool
+	raw   []string
+	lines []line
+}
+
+const (
+	fence        = "```"
+	spoilerOpen  = "[spoiler]"
+	spoilerClose = "[/spoiler]"
+)
+
+var crossCitationRegex = regexp.MustCompile(`^>>>/([0-9A-Za-z_-]+)/([0-9]+)`)
+var citationRegex = regexp.MustCompile(`^>> ?([0-9]+)`)
+
+// parse splits the content of a post into blocks. A fence that is never
+// closed starts a code block running to the end of the post.
+func parse(content string) []block {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.ReplaceAll(content, "\r", "\n")
+
+	var blocks []block
+	var text []string
+
+	flush := func() {
+		if len(text) != 0 {
+			blocks = append(blocks, block{lines: parseLines(text)})
+			text = nil
+		}
+	}
+
+	lines := strings.Split(content, "\n")
+	for i := 0; i < len(lines); i++ {
+		if !isFence(lines[i]) {
+			text = append(text, lines[i])
+			continue
+		}
+
+		flush()
+
+		end := len(lines)
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimSpace(lines[j]) == fence {
+				end = j
+				break
+			}
+		}
+
+		blocks = append(blocks, block{code: true, raw: lines[i+1 : end]})
+		i = end
+	}
+	flush()
+
+	return blocks
+}
+
+// isFence tells if a line opens a code block. What follows the backticks is
+// the language, which can't contain backticks so "```code```" stays inline.
+func isFence(line string) bool {
+	return strings.HasPrefix(line, fence) && !strings.Contains(line[len(fence):], "`")
+}
+
+// parseLines parses consecutive lines of text. Spoilers can span lines, they
+// are closed at the end of each line and opened again on the next one so the
+// lines can be parsed on their own.
+func parseLines(text []string) []line {
+	opened := make([]bool, len(text))
+	closed := make([]bool, len(text))
+
+	pending := -1
+	for i, l := range text {
+		for _, open := range spoilerMarkers(l) {
+			if open && pending == -1 {
+				pending = i
+			} else if !open && pending != -1 {
+				for j := pending; j < i; j++ {
+					closed[j] = true
+					opened[j+1] = true
+				}
+				pending = -1
+			}
+		}
+	}
+
+	lines := make([]line, len(text))
+	for i, l := range text {
+		s := l
+		if opened[i] {
+			s = spoilerOpen + s
+		}
+		if closed[i] {
+			s += spoilerClose
+		}
+
+		lines[i] = line{
+			quote: strings.HasPrefix(l, ">") && !isCitation(l),
+			nodes: parseInline(s),
+		}
+	}
+
+	return lines
+}
+
+// spoilerMarkers lists the spoiler tags of a line outside of inline code,
+// true for opening ones.
+func spoilerMarkers(s string) []bool {
+	var markers []bool
+
+	codeEnds := codeSpans(s)
+
+	for i := 0; i < len(s); {
+		if end, ok := codeEnds[i]; ok {
+			i = end
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(s[i:], spoilerOpen):
+			markers = append(markers, true)
+			i += len(spoilerOpen)
+		case strings.HasPrefix(s[i:], spoilerClose):
+			markers = append(markers, false)
+			i += len(spoilerClose)
+		default:
+			i++
+		}
+	}
+
+	return markers
+}
+
+func isCitation(s string) bool {
+	return crossCitationRegex.MatchString(s) || citationRegex.MatchString(s)
+}
+
+// codeSpans finds the inline code of a line and maps where every span starts
+// to where it ends. A span is closed by the next run of as many backticks as
+// it was opened with, runs without one are text.
+func codeSpans(s string) map[int]int {
+	type run struct{ start, end int }
+
+	var runs []run
+	for i := 0; i < len(s); i++ {
+		if s[i] == '`' {
+			end := i
+			for end < len(s) && s[end] == '`' {
+				end++
+			}
+
+			runs = append(runs, run{i, end})
+			i = end - 1
+		}
+	}
+
+	// next holds the index of the next run as long as each run.
+	next := make([]int, len(runs))
+	last := map[int]int{}
+	for i := len(runs) - 1; i >= 0; i-- {
+		length := runs[i].end - runs[i].start
+
+		next[i] = -1
+		if j, ok := last[length]; ok {
+			next[i] = j
+		}
+		last[length] = i
+	}
+
+	spans := map[int]int{}
+	for i := 0; i < len(runs); i++ {
+		if next[i] != -1 {
+			spans[runs[i].start] = runs[next[i]].end
+			i = next[i]
+		}
+	}
+
+	return spans
+}
+
+// inlineParser parses the formatting of a line. Every element is only parsed
+// once its end is found and its content is parsed on its own, so elements
+// always nest and unmatched markers stay text.
+//
+// Where code spans are and where elements can be closed is worked out once
+// for the whole line, so lines full of unmatched markers don't take
+// quadratic time to parse.
+type inlineParser struct {
+	s        string
+	codeEnds map[int]int
+	// closers holds for every closing marker and position of the line the
+	// first position from there where the marker can close an element.
+	closers map[string][]int
+	// starRuns holds for every "*" of the line where its run of stars starts.
+	starRuns []int
+}
+
+func parseInline(s string) []node {
+	p := &inlineParser{s: s, codeEnds: codeSpans(s), closers: map[string][]int{}, starRuns: make([]int, len(s))}
+
+	for i := 0; i < len(s); i++ {
+		p.starRuns[i] = i
+		if i > 0 && s[i] == '*' && s[i-1] == '*' {
+			p.starRuns[i] = p.starRuns[i-1]
+		}
+	}
+
+	inCode := make([]bool, len(s))
+	for start, end := range p.codeEnds {
+		for i := start; i < end; i++ {
+			inCode[i] = true
+		}
+	}
+
+	for _, close := range []string{spoilerClose, "**", "~~", "*"} {
+		closers := make([]int, len(s)+1)
+		closers[len(s)] = -1
+
+		for j := len(s) - 1; j >= 0; j-- {
+			closers[j] = closers[j+1]
+			if !inCode[j] && p.closes(j, close) {
+				closers[j] = j
+			}
+		}
+
+		p.closers[close] = closers
+	}
+
+	return p.parse(0, len(s))
+}
+
+// closes tells if the marker at j can close an element. Bold, italic and
+// strikethrough can't end with a space, so "2 * 3 * 4" isn't italic. A "*"
+// only closes italics if it isn't part of a "**", in a run of stars those
+// are taken from its start.
+func (p *inlineParser) closes(j int, close string) bool {
+	s := p.s
+
+	if !strings.HasPrefix(s[j:], close) {
+		return false
+	}
+	if close == spoilerClose {
+		return true
+	}
+	if j == 0 || unicode.IsSpace(rune(s[j-1])) {
+		return false
+	}
+
+	if close == "*" {
+		return (j-p.starRuns[j])%2 == 0 && (j+1 == len(s) || s[j+1] != '*')
+	}
+
+	return true
+}
+
+func (p *inlineParser) parse(from, to int) []node {
+	var nodes []node
+	var text strings.Builder
+
+	for i := from; i < to; {
+		if n, end, ok := p.parseElement(i, to); ok {
+			if text.Len() != 0 {
+				nodes = append(nodes, node{kind: textNode, text: text.String()})
+				text.Reset()
+			}
+
+			nodes = append(nodes, n)
+			i = end
+			continue
+		}
+
+		// A run of backticks without a closing run is text as a whole.
+		if p.s[i] == '`' {
+			end := skipRun(p.s, i, to, '`')
+			text.WriteString(p.s[i:end])
+			i = end
+			continue
+		}
+
+		_, size := utf8.DecodeRuneInString(p.s[i:to])
+		text.WriteString(p.s[i : i+size])
+		i += size
+	}
+
+	if text.Len() != 0 {
+		nodes = append(nodes, node{kind: textNode, text: text.String()})
+	}
+
+	return nodes
+}
+
+// parseElement parses the element starting at i, returning where it ends.
+func (p *inlineParser) parseElement(i, to int) (node, int, bool) {
+	rest := p.s[i:to]
+
+	switch {
+	case rest[0] == '`':
+		if end, ok := p.codeEnds[i]; ok {
+			length := skipRun(p.s, i, to, '`') - i
+			return node{kind: codeNode, text: p.s[i+length : end-length]}, end, true
+		}
+	case strings.HasPrefix(rest, spoilerOpen):
+		return p.parseDelimited(i, to, spoilerOpen, spoilerClose, spoilerNode)
+	case strings.HasPrefix(rest, "**"):
+		return p.parseDelimited(i, to, "**", "**", boldNode)
+	case strings.HasPrefix(rest, "~~"):
+		return p.parseDelimited(i, to, "~~", "~~", strikeNode)
+	case rest[0] == '*':
+		return p.parseDelimited(i, to, "*", "*", italicNode)
+	case rest[0] == '>':
+		return parseCitation(rest, i)
+	case rest[0] == 'h':
+		return parseLink(p.s[:to], i)
+	}
+
+	return node{}, 0, false
+}
+
+// parseDelimited parses formatting between an opening and closing marker.
+// Only spoilers can be empty, multiline spoilers are empty on blank lines.
+// The others can't start with a space either.
+func (p *inlineParser) parseDelimited(i, to int, open, close string, kind nodeKind) (node, int, bool) {
+	start := i + len(open)
+	if start >= to {
+		return node{}, 0, false
+	}
+
+	from := start
+	if kind != spoilerNode {
+		if unicode.IsSpace(rune(p.s[start])) {
+			return node{}, 0, false
+		}
+		from++
+	}
+
+	end := p.closers[close][from]
+	if end == -1 || end+len(close) > to {
+		return node{}, 0, false
+	}
+
+	return node{kind: kind, children: p.parse(start, end)}, end + len(close), true
+}
+
+func skipRun(s string, i, to int, c byte) int {
+	for i < to && s[i] == c {
+		i++
+	}
+
+	return i
+}
+
+// parseCitation parses ">>123", ">> 123" and ">>>/board/123" at the start of
+// s, which starts at i in the line.
+func parseCitation(s string, i int) (node, int, bool) {
+	if match := crossCitationRegex.FindStringSubmatch(s); match != nil {
+		id, err := strconv.ParseUint(match[2], 10, 31)
+		if err == nil {
+			return node{kind: citationNode, board: match[1], post: uint(id)}, i + len(match[0]), true
+		}
+	}
+
+	if match := citationRegex.FindStringSubmatch(s); match != nil {
+		id, err := strconv.ParseUint(match[1], 10, 31)
+		if err == nil {
+			return node{kind: citationNode, post: uint(id)}, i + len(match[0]), true
+		}
+	}
+
+	return node{}, 0, false
+}
+
+// parseLink parses an http or https URL starting a word. Punctuation at the
+// end is left out of the link, like the full stop ending a sentence, and so
+// are closing brackets that weren't opened in the URL. Something that looks
+// like a URL but isn't one is text as a whole.
+func parseLink(s string, i int) (node, int, bool) {
+	rest := s[i:]
+	if !strings.HasPrefix(rest, "http://") && !strings.HasPrefix(rest, "https://") {
+		return node{}, 0, false
+	}
+
+	if i > 0 {
+		previous, _ := utf8.DecodeLastRuneInString(s[:i])
+		if unicode.IsLetter(previous) || unicode.IsDigit(previous) {
+			return node{}, 0, false
+		}
+	}
+
+	end := strings.IndexFunc(rest, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune("<>\"`", r)
+	})
+	if end == -1 {
+		end = len(rest)
+	}
+
+	raw := rest[:end]
+	parentheses := strings.Count(raw, "(") - strings.Count(raw, ")")
+	brackets := strings.Count(raw, "[") - strings.Count(raw, "]")
+
+	for len(raw) != 0 {
+		last := raw[len(raw)-1]
+
+		switch {
+		case strings.IndexByte(".,:;!?'*~", last) != -1:
+		case last == ')' && parentheses < 0:
+			parentheses++
+		case last == ']' && brackets < 0:
+			brackets++
+		default:
+			u, err := url.Parse(raw)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return node{kind: textNode, text: rest[:end]}, i + end, true
+			}
+
+			return node{kind: linkNode, text: raw}, i + len(raw), true
+		}
+
+		raw = raw[:len(raw)-1]
+	}
+
+	return node{kind: textNode, text: rest[:end]}, i + end, true
+}
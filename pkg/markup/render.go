@@ -0,0 +1,83 @@
+package markup
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+func render(b *strings.Builder, blocks []block, options Options) {
+	for _, block := range blocks {
+		if block.code {
+			b.WriteString(`<pre class="bg-gray-100 rounded p-2 my-1 overflow-x-auto whitespace-pre text-sm font-mono"><code>`)
+			b.WriteString(html.EscapeString(strings.Join(block.raw, "\n")))
+			b.WriteString(`</code></pre>`)
+			continue
+		}
+
+		for i, line := range block.lines {
+			if i != 0 {
+				b.WriteString("<br>")
+			}
+
+			if line.quote {
+				b.WriteString(`<span class="text-green-600">`)
+			}
+			renderNodes(b, line.nodes, options)
+			if line.quote {
+				b.WriteString(`</span>`)
+			}
+		}
+	}
+}
+
+func renderNodes(b *strings.Builder, nodes []node, options Options) {
+	for _, n := range nodes {
+		switch n.kind {
+		case textNode:
+			b.WriteString(html.EscapeString(n.text))
+		case codeNode:
+			b.WriteString(`<code class="bg-gray-100 rounded px-1 font-mono">`)
+			b.WriteString(html.EscapeString(n.text))
+			b.WriteString(`</code>`)
+		case boldNode:
+			renderWrapped(b, "<strong>", "</strong>", n.children, options)
+		case italicNode:
+			renderWrapped(b, "<em>", "</em>", n.children, options)
+		case strikeNode:
+			renderWrapped(b, "<s>", "</s>", n.children, options)
+		case spoilerNode:
+			if len(n.children) != 0 {
+				renderWrapped(b, `<span class="bg-black text-black hover:text-white">`, `</span>`, n.children, options)
+			}
+		case linkNode:
+			link := html.EscapeString(n.text)
+			fmt.Fprintf(b, `<a class="text-blue-500 underline" href="%s" rel="nofollow noopener" target="_blank">%s</a>`, link, link)
+		case citationNode:
+			renderCitation(b, n, options)
+		}
+	}
+}
+
+func renderWrapped(b *strings.Builder, open, close string, children []node, options Options) {
+	b.WriteString(open)
+	renderNodes(b, children, options)
+	b.WriteString(close)
+}
+
+func renderCitation(b *strings.Builder, n node, options Options) {
+	board := n.board
+	text := fmt.Sprintf("&gt;&gt;&gt;/%s/%d", board, n.post)
+	if board == "" {
+		board = options.BoardID
+		text = fmt.Sprintf("&gt;&gt; %d", n.post)
+	}
+
+	if options.Exists != nil && !options.Exists(board, n.post) {
+		fmt.Fprintf(b, `<span class="dead-link line-through text-gray-500">%s</span>`, text)
+		return
+	}
+
+	board = html.EscapeString(board)
+	fmt.Fprintf(b, `<a data-board="%s" data-post="%d" class="post-link text-blue-500" href="/%s/%d/">%s</a>`, board, n.post, board, n.post, text)
+}
@@ -0,0 +1,191 @@
+package sanitize
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/h2non/bimg"
+)
+
+var jpegSignature = []byte{0xFF, 0xD8}
+
+const (
+	markerSOS   = 0xDA
+	markerAPP0  = 0xE0
+	markerAPP1  = 0xE1
+	markerAPP14 = 0xEE
+	markerAPP15 = 0xEF
+	markerCOM   = 0xFE
+)
+
+// keepJPEGSegment reports whether a marker segment is needed to decode the
+// image. JFIF (APP0) and Adobe (APP14) segments describe the colour transform,
+// every other application segment and comments only carry metadata.
+func keepJPEGSegment(marker byte) bool {
+	if marker == markerCOM {
+		return false
+	}
+
+	if marker >= markerAPP0 && marker <= markerAPP15 {
+		return marker == markerAPP0 || marker == markerAPP14
+	}
+
+	return true
+}
+
+// readJPEGHeader reads the marker segments before the image data, up to and
+// including the start of scan marker. Only this part of a JPEG is held in
+// memory, the image data after it is streamed.
+func readJPEGHeader(src *bufio.Reader) ([]byte, error) {
+	var header bytes.Buffer
+
+	if _, err := io.CopyN(&header, src, int64(len(jpegSignature))); err != nil {
+		return nil, ErrMalformedImage
+	}
+
+	for {
+		b, err := src.ReadByte()
+		if err != nil || b != 0xFF {
+			return nil, ErrMalformedImage
+		}
+
+		// Markers may be preceded by any number of fill bytes.
+		marker := byte(0xFF)
+		for marker == 0xFF {
+			marker, err = src.ReadByte()
+			if err != nil {
+				return nil, ErrMalformedImage
+			}
+		}
+
+		header.Write([]byte{0xFF, marker})
+
+		if marker == markerSOS {
+			return header.Bytes(), nil
+		}
+
+		length := make([]byte, 2)
+		if _, err := io.ReadFull(src, length); err != nil {
+			return nil, ErrMalformedImage
+		}
+
+		segmentLength := int64(binary.BigEndian.Uint16(length))
+		if segmentLength < 2 {
+			return nil, ErrMalformedImage
+		}
+
+		header.Write(length)
+		if _, err := io.CopyN(&header, src, segmentLength-2); err != nil {
+			return nil, ErrMalformedImage
+		}
+	}
+}
+
+// jpegSegments calls fn with every marker segment of a header read by
+// readJPEGHeader, including the two marker bytes. It returns the offset of the
+// start of scan marker.
+func jpegSegments(header []byte, fn func(marker byte, segment []byte)) int {
+	offset := len(jpegSignature)
+
+	for {
+		marker := header[offset+1]
+		if marker == markerSOS {
+			return offset
+		}
+
+		length := int(binary.BigEndian.Uint16(header[offset+2:]))
+
+		fn(marker, header[offset:offset+2+length])
+		offset += 2 + length
+	}
+}
+
+// stripJPEG streams a JPEG without its metadata segments. JPEGs relying on
+// their EXIF orientation are encoded again instead, as the orientation would
+// be lost.
+func stripJPEG(file io.ReadSeeker) (io.Reader, error) {
+	src := bufio.NewReader(file)
+
+	header, err := readJPEGHeader(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if jpegOrientation(header) > 1 {
+		return reencodeImage(file, bimg.JPEG)
+	}
+
+	var stripped bytes.Buffer
+	stripped.Grow(len(header))
+	stripped.Write(jpegSignature)
+
+	scanOffset := jpegSegments(header, func(marker byte, segment []byte) {
+		if keepJPEGSegment(marker) {
+			stripped.Write(segment)
+		}
+	})
+
+	stripped.Write(header[scanOffset:])
+
+	return io.MultiReader(&stripped, src), nil
+}
+
+// jpegOrientation returns the EXIF orientation of the image, or 0 when the
+// header doesn't have one.
+func jpegOrientation(header []byte) int {
+	orientation := 0
+
+	jpegSegments(header, func(marker byte, segment []byte) {
+		if marker != markerAPP1 || orientation != 0 {
+			return
+		}
+
+		payload := segment[4:]
+		if !bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return
+		}
+
+		orientation = exifOrientation(payload[6:])
+	})
+
+	return orientation
+}
+
+// exifOrientation reads the orientation tag from the first IFD of a TIFF
+// structure, the format EXIF data is stored in.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifdOffset := int(order.Uint32(tiff[4:8]))
+	if ifdOffset+2 > len(tiff) {
+		return 0
+	}
+
+	entryCount := int(order.Uint16(tiff[ifdOffset:]))
+	for i := 0; i < entryCount; i++ {
+		entry := ifdOffset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,95 @@
+package sanitize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+
+// pngMetadataChunks only carry metadata and are safe to leave out.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"iCCP": true,
+	"tIME": true,
+}
+
+// pngReader streams a PNG one chunk at a time, leaving out metadata chunks and
+// anything after the IEND chunk.
+type pngReader struct {
+	src io.Reader
+	// chunk is the rest of the chunk being copied.
+	chunk io.Reader
+	done  bool
+}
+
+func stripPNG(src io.Reader) io.Reader {
+	return &pngReader{
+		src:   src,
+		chunk: &exactReader{src: src, n: int64(len(pngSignature))},
+	}
+}
+
+func (r *pngReader) Read(p []byte) (int, error) {
+	for {
+		if r.chunk != nil {
+			n, err := r.chunk.Read(p)
+			if errors.Is(err, io.EOF) {
+				r.chunk = nil
+				err = nil
+			}
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		if r.done {
+			return 0, io.EOF
+		}
+
+		if err := r.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (r *pngReader) nextChunk() error {
+	// Every chunk is its length, type, data and a CRC.
+	header := make([]byte, 8)
+
+	_, err := io.ReadFull(r.src, header)
+	if errors.Is(err, io.EOF) {
+		r.done = true
+		return nil
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrMalformedImage
+	}
+	if err != nil {
+		return err
+	}
+
+	length := int64(binary.BigEndian.Uint32(header))
+	chunkType := string(header[4:8])
+
+	rest := &exactReader{src: r.src, n: length + 4}
+
+	if chunkType == "IEND" {
+		r.done = true
+	}
+
+	if pngMetadataChunks[chunkType] {
+		_, err := io.Copy(io.Discard, rest)
+		return err
+	}
+
+	r.chunk = io.MultiReader(bytes.NewReader(header), rest)
+
+	return nil
+}
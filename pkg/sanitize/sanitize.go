@@ -0,0 +1,113 @@
+package sanitize
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/h2non/bimg"
+)
+
+var ErrMalformedImage = errors.New("malformed image")
+
+// Image removes EXIF, XMP, ICC profiles, comments and other metadata from
+// JPEG, PNG and WebP images. Metadata is cut out while the image streams
+// through, without touching the image data, unless reencode is set or the JPEG
+// relies on its EXIF orientation, in which case the image is decoded, rotated
+// upright and encoded again. Other files are returned as they are.
+//
+// Malformed images make Image, or reading what it returns, fail with
+// ErrMalformedImage.
+func Image(file io.ReadSeeker, reencode bool) (io.Reader, error) {
+	header := make([]byte, 12)
+
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	header = header[:n]
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, jpegSignature):
+		if reencode {
+			return reencodeImage(file, bimg.JPEG)
+		}
+
+		return stripJPEG(file)
+	case bytes.HasPrefix(header, pngSignature):
+		if reencode {
+			return reencodeImage(file, bimg.PNG)
+		}
+
+		return stripPNG(bufio.NewReader(file)), nil
+	case isWebP(header):
+		if reencode {
+			return reencodeImage(file, bimg.WEBP)
+		}
+
+		return stripWebP(file)
+	default:
+		return file, nil
+	}
+}
+
+// reencodeImage decodes and encodes the whole image again. libvips decodes
+// from memory, so unlike stripping this reads the image in full.
+func reencodeImage(file io.ReadSeeker, imageType bimg.ImageType) (io.Reader, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	buf, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// libvips rotates the image according to its EXIF orientation before the
+	// metadata is dropped.
+	reencoded, err := bimg.NewImage(buf).Process(bimg.Options{
+		Type:          imageType,
+		Quality:       90,
+		StripMetadata: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedImage, err)
+	}
+
+	return bytes.NewReader(reencoded), nil
+}
+
+// exactReader reads the next n bytes of a file, a file ending before them is
+// malformed.
+type exactReader struct {
+	src io.Reader
+	n   int64
+}
+
+func (r *exactReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+
+	n, err := r.src.Read(p)
+	r.n -= int64(n)
+
+	if errors.Is(err, io.EOF) {
+		if r.n > 0 {
+			return n, ErrMalformedImage
+		}
+		err = nil
+	}
+
+	return n, err
+}
@@ -0,0 +1,260 @@
+package sanitize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 8; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 30), uint8(y * 30), 100, 255})
+		}
+	}
+
+	return img
+}
+
+// sanitized runs an image through Image and reads the result.
+func sanitized(input []byte) ([]byte, error) {
+	stripped, err := Image(bytes.NewReader(input), false)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(stripped)
+}
+
+func jpegSegment(marker byte, payload string) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(2+len(payload)))
+	return append(segment, payload...)
+}
+
+// exifPayload is an APP1 payload with a little endian TIFF structure holding
+// only an orientation tag.
+func exifPayload(orientation uint16) string {
+	tiff := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	entry := make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry[0:], 0x0112)
+	binary.LittleEndian.PutUint16(entry[2:], 3)
+	binary.LittleEndian.PutUint32(entry[4:], 1)
+	binary.LittleEndian.PutUint16(entry[8:], orientation)
+	tiff = append(tiff, entry...)
+	tiff = append(tiff, 0, 0, 0, 0)
+
+	return "Exif\x00\x00" + string(tiff)
+}
+
+const (
+	xmpPayload = "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>GPS 51.5, -0.1</x:xmpmeta>"
+	iccPayload = "ICC_PROFILE\x00\x01\x01private colour profile"
+)
+
+func jpegFixtures(t *testing.T) (withMetadata, withoutMetadata []byte) {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	jfif := jpegSegment(markerAPP0, "JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")
+
+	withoutMetadata = append(append([]byte{}, jpegSignature...), jfif...)
+	withoutMetadata = append(withoutMetadata, encoded.Bytes()[2:]...)
+
+	withMetadata = append(append([]byte{}, jpegSignature...), jfif...)
+	withMetadata = append(withMetadata, jpegSegment(markerAPP1, exifPayload(1))...)
+	withMetadata = append(withMetadata, jpegSegment(markerAPP1, xmpPayload)...)
+	withMetadata = append(withMetadata, jpegSegment(0xE2, iccPayload)...)
+	withMetadata = append(withMetadata, jpegSegment(markerCOM, "shot by someone")...)
+	// Fill bytes before a marker.
+	withMetadata = append(withMetadata, 0xFF, 0xFF)
+	withMetadata = append(withMetadata, encoded.Bytes()[2:]...)
+
+	return withMetadata, withoutMetadata
+}
+
+func pngChunk(chunkType, data string) []byte {
+	chunk := make([]byte, 4, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	return append(chunk, crc...)
+}
+
+func pngFixtures(t *testing.T) (withMetadata, withoutMetadata []byte) {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	withoutMetadata = encoded.Bytes()
+
+	// The IHDR chunk always comes first, metadata goes after it.
+	ihdrEnd := len(pngSignature) + 12 + 13
+
+	withMetadata = append([]byte{}, withoutMetadata[:ihdrEnd]...)
+	withMetadata = append(withMetadata, pngChunk("iCCP", "profile\x00\x00private colour profile")...)
+	withMetadata = append(withMetadata, pngChunk("eXIf", exifPayload(1)[6:])...)
+	withMetadata = append(withMetadata, pngChunk("iTXt", "XML:com.adobe.xmp\x00\x00\x00\x00\x00"+xmpPayload)...)
+	withMetadata = append(withMetadata, pngChunk("tEXt", "Author\x00someone")...)
+	withMetadata = append(withMetadata, pngChunk("tIME", "\x07\xe8\x01\x01\x00\x00\x00")...)
+	withMetadata = append(withMetadata, withoutMetadata[ihdrEnd:]...)
+	withMetadata = append(withMetadata, "trailing data"...)
+
+	return withMetadata, withoutMetadata
+}
+
+func webpChunkBytes(chunkType, data string, pad bool) []byte {
+	chunk := []byte(chunkType)
+	chunk = binary.LittleEndian.AppendUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, data...)
+	if pad && len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+
+	return chunk
+}
+
+func webpFile(chunks ...[]byte) []byte {
+	body := bytes.Join(chunks, nil)
+
+	file := []byte("RIFF")
+	file = binary.LittleEndian.AppendUint32(file, uint32(4+len(body)))
+	file = append(file, "WEBP"...)
+	return append(file, body...)
+}
+
+// vp8x is a VP8X chunk of an 8x8 image with the given flags.
+func vp8x(flags byte) []byte {
+	return webpChunkBytes("VP8X", string([]byte{flags, 0, 0, 0, 7, 0, 0, 7, 0, 0}), true)
+}
+
+// The image data isn't decoded, any bytes will do.
+const webpImageData = "not really lossless data"
+
+func TestImage(t *testing.T) {
+	jpegWithMetadata, jpegWithoutMetadata := jpegFixtures(t)
+	pngWithMetadata, pngWithoutMetadata := pngFixtures(t)
+
+	const alpha = 0x10
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  []byte
+	}{
+		{"jpeg", jpegWithMetadata, jpegWithoutMetadata},
+		{"jpeg without metadata", jpegWithoutMetadata, jpegWithoutMetadata},
+		{"png", pngWithMetadata, pngWithoutMetadata},
+		{"png without metadata", pngWithoutMetadata, pngWithoutMetadata},
+		{
+			"webp",
+			webpFile(
+				vp8x(webpFlagICC|webpFlagEXIF|webpFlagXMP|alpha),
+				webpChunkBytes("ICCP", "private colour profile", true),
+				webpChunkBytes("VP8L", webpImageData+"!", true),
+				webpChunkBytes("EXIF", exifPayload(1)[6:], true),
+				webpChunkBytes("XMP ", xmpPayload, true),
+			),
+			webpFile(vp8x(alpha), webpChunkBytes("VP8L", webpImageData+"!", true)),
+		},
+		{
+			"webp with an unpadded last chunk",
+			webpFile(
+				vp8x(webpFlagEXIF),
+				webpChunkBytes("EXIF", exifPayload(1)[6:], true),
+				webpChunkBytes("VP8L", webpImageData+"!", false),
+			),
+			webpFile(vp8x(0), webpChunkBytes("VP8L", webpImageData+"!", false)),
+		},
+		{"not an image", []byte("just some text"), []byte("just some text")},
+		{"empty", []byte{}, []byte{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := sanitized(test.input)
+			if err != nil {
+				t.Fatalf("sanitizing failed: %s", err)
+			}
+
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("sanitizing returned\n%q\nwant\n%q", got, test.want)
+			}
+		})
+	}
+
+	// The stripped images still decode.
+	if stripped, err := sanitized(jpegWithMetadata); err == nil {
+		if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+			t.Errorf("the stripped JPEG doesn't decode: %s", err)
+		}
+	}
+	if stripped, err := sanitized(pngWithMetadata); err == nil {
+		if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+			t.Errorf("the stripped PNG doesn't decode: %s", err)
+		}
+	}
+}
+
+func TestImageMalformed(t *testing.T) {
+	jpegWithMetadata, _ := jpegFixtures(t)
+	pngWithMetadata, _ := pngFixtures(t)
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"jpeg truncated in a segment", jpegWithMetadata[:40]},
+		{"jpeg without markers", append(append([]byte{}, jpegSignature...), "not a marker"...)},
+		{"jpeg segment too short", append(append([]byte{}, jpegSignature...), 0xFF, markerAPP1, 0, 1)},
+		{"jpeg with only a signature", jpegSignature},
+		{"png truncated in a chunk", pngWithMetadata[:len(pngWithMetadata)/2]},
+		{"png truncated in a chunk header", append(append([]byte{}, pngSignature...), 0, 0, 0)},
+		{"webp chunk longer than the file", webpFile(webpChunkBytes("VP8L", webpImageData, true))[:30]},
+		{"webp truncated in a chunk header", append(webpFile(vp8x(0)), "VP8"...)},
+		{"webp with an empty VP8X chunk", webpFile(webpChunkBytes("VP8X", "", true))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := sanitized(test.input)
+			if !errors.Is(err, ErrMalformedImage) {
+				t.Errorf("sanitizing returned %v, want ErrMalformedImage", err)
+			}
+		})
+	}
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	for _, orientation := range []uint16{1, 6, 8} {
+		header := append([]byte{}, jpegSignature...)
+		header = append(header, jpegSegment(markerAPP1, xmpPayload)...)
+		header = append(header, jpegSegment(markerAPP1, exifPayload(orientation))...)
+		header = append(header, 0xFF, markerSOS)
+
+		if got := jpegOrientation(header); got != int(orientation) {
+			t.Errorf("jpegOrientation returned %d, want %d", got, orientation)
+		}
+	}
+
+	if got := jpegOrientation(append(append([]byte{}, jpegSignature...), 0xFF, markerSOS)); got != 0 {
+		t.Errorf("jpegOrientation of a JPEG without EXIF data returned %d", got)
+	}
+}
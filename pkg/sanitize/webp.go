@@ -0,0 +1,152 @@
+package sanitize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Flags in the VP8X chunk announcing the optional metadata chunks.
+const (
+	webpFlagICC  = 0x20
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+func isWebP(buf []byte) bool {
+	return len(buf) >= 12 && string(buf[0:4]) == "RIFF" && string(buf[8:12]) == "WEBP"
+}
+
+type webpChunk struct {
+	chunkType string
+	offset    int64
+	// size is the size of the whole chunk, with its header and padding.
+	size int64
+}
+
+func (c webpChunk) metadata() bool {
+	return c.chunkType == "EXIF" || c.chunkType == "XMP " || c.chunkType == "ICCP"
+}
+
+// webpChunks lists the chunks of a WebP file without reading their data.
+func webpChunks(file io.ReadSeeker) ([]webpChunk, error) {
+	fileSize, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []webpChunk
+
+	offset := int64(12)
+	for offset < fileSize {
+		if offset+8 > fileSize {
+			return nil, ErrMalformedImage
+		}
+
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(file, header); err != nil {
+			return nil, err
+		}
+
+		chunkType := string(header[0:4])
+		length := int64(binary.LittleEndian.Uint32(header[4:]))
+
+		// Chunks are padded to an even length, though some encoders leave
+		// out the padding of the last chunk.
+		size := 8 + length + length%2
+		if offset+size > fileSize && offset+8+length == fileSize {
+			size = 8 + length
+		}
+		if offset+size > fileSize {
+			return nil, ErrMalformedImage
+		}
+
+		if chunkType == "VP8X" && length < 1 {
+			return nil, ErrMalformedImage
+		}
+
+		chunks = append(chunks, webpChunk{chunkType: chunkType, offset: offset, size: size})
+		offset += size
+	}
+
+	return chunks, nil
+}
+
+// stripWebP streams a WebP without its metadata chunks. The RIFF header holds
+// the size of the file, so a first pass over the chunk headers works out the
+// size of what's kept before anything is read.
+func stripWebP(file io.ReadSeeker) (io.Reader, error) {
+	chunks, err := webpChunks(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []webpChunk
+	var size int64
+	for _, chunk := range chunks {
+		if !chunk.metadata() {
+			kept = append(kept, chunk)
+			size += chunk.size
+		}
+	}
+
+	var header bytes.Buffer
+	header.WriteString("RIFF")
+	binary.Write(&header, binary.LittleEndian, uint32(4+size))
+	header.WriteString("WEBP")
+
+	return io.MultiReader(&header, &webpReader{file: file, chunks: kept}), nil
+}
+
+// webpReader copies the listed chunks of a WebP, clearing the metadata flags
+// of the VP8X chunk.
+type webpReader struct {
+	file   io.ReadSeeker
+	chunks []webpChunk
+	// chunk is the rest of the chunk being copied.
+	chunk io.Reader
+}
+
+func (r *webpReader) Read(p []byte) (int, error) {
+	for {
+		if r.chunk != nil {
+			n, err := r.chunk.Read(p)
+			if errors.Is(err, io.EOF) {
+				r.chunk = nil
+				err = nil
+			}
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+
+		next := r.chunks[0]
+		r.chunks = r.chunks[1:]
+
+		if _, err := r.file.Seek(next.offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+
+		r.chunk = &exactReader{src: r.file, n: next.size}
+
+		if next.chunkType == "VP8X" {
+			chunk := make([]byte, next.size)
+			if _, err := io.ReadFull(r.chunk, chunk); err != nil {
+				return 0, err
+			}
+
+			chunk[8] &^= webpFlagICC | webpFlagEXIF | webpFlagXMP
+			r.chunk = bytes.NewReader(chunk)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package tripcode
+
+// crypt is the traditional DES based crypt(3) of Unix, which classic
+// tripcodes are built on. It encrypts a zero block 25 times with the first 8
+// characters of the key, using a DES whose expansion is perturbed by the salt.
+
+var initialPermutation = [64]byte{
+	58, 50, 42, 34, 26, 18, 10, 2,
+	60, 52, 44, 36, 28, 20, 12, 4,
+	62, 54, 46, 38, 30, 22, 14, 6,
+	64, 56, 48, 40, 32, 24, 16, 8,
+	57, 49, 41, 33, 25, 17, 9, 1,
+	59, 51, 43, 35, 27, 19, 11, 3,
+	61, 53, 45, 37, 29, 21, 13, 5,
+	63, 55, 47, 39, 31, 23, 15, 7,
+}
+
+var finalPermutation = [64]byte{
+	40, 8, 48, 16, 56, 24, 64, 32,
+	39, 7, 47, 15, 55, 23, 63, 31,
+	38, 6, 46, 14, 54, 22, 62, 30,
+	37, 5, 45, 13, 53, 21, 61, 29,
+	36, 4, 44, 12, 52, 20, 60, 28,
+	35, 3, 43, 11, 51, 19, 59, 27,
+	34, 2, 42, 10, 50, 18, 58, 26,
+	33, 1, 41, 9, 49, 17, 57, 25,
+}
+
+var permutedChoice1C = [28]byte{
+	57, 49, 41, 33, 25, 17, 9,
+	1, 58, 50, 42, 34, 26, 18,
+	10, 2, 59, 51, 43, 35, 27,
+	19, 11, 3, 60, 52, 44, 36,
+}
+
+var permutedChoice1D = [28]byte{
+	63, 55, 47, 39, 31, 23, 15,
+	7, 62, 54, 46, 38, 30, 22,
+	14, 6, 61, 53, 45, 37, 29,
+	21, 13, 5, 28, 20, 12, 4,
+}
+
+var keyShifts = [16]int{1, 1, 2, 2, 2, 2, 2, 2, 1, 2, 2, 2, 2, 2, 2, 1}
+
+var permutedChoice2C = [24]byte{
+	14, 17, 11, 24, 1, 5,
+	3, 28, 15, 6, 21, 10,
+	23, 19, 12, 4, 26, 8,
+	16, 7, 27, 20, 13, 2,
+}
+
+var permutedChoice2D = [24]byte{
+	41, 52, 31, 37, 47, 55,
+	30, 40, 51, 45, 33, 48,
+	44, 49, 39, 56, 34, 53,
+	46, 42, 50, 36, 29, 32,
+}
+
+var expansion = [48]byte{
+	32, 1, 2, 3, 4, 5,
+	4, 5, 6, 7, 8, 9,
+	8, 9, 10, 11, 12, 13,
+	12, 13, 14, 15, 16, 17,
+	16, 17, 18, 19, 20, 21,
+	20, 21, 22, 23, 24, 25,
+	24, 25, 26, 27, 28, 29,
+	28, 29, 30, 31, 32, 1,
+}
+
+var sBoxes = [8][64]byte{
+	{
+		14, 4, 13, 1, 2, 15, 11, 8, 3, 10, 6, 12, 5, 9, 0, 7,
+		0, 15, 7, 4, 14, 2, 13, 1, 10, 6, 12, 11, 9, 5, 3, 8,
+		4, 1, 14, 8, 13, 6, 2, 11, 15, 12, 9, 7, 3, 10, 5, 0,
+		15, 12, 8, 2, 4, 9, 1, 7, 5, 11, 3, 14, 10, 0, 6, 13,
+	},
+	{
+		15, 1, 8, 14, 6, 11, 3, 4, 9, 7, 2, 13, 12, 0, 5, 10,
+		3, 13, 4, 7, 15, 2, 8, 14, 12, 0, 1, 10, 6, 9, 11, 5,
+		0, 14, 7, 11, 10, 4, 13, 1, 5, 8, 12, 6, 9, 3, 2, 15,
+		13, 8, 10, 1, 3, 15, 4, 2, 11, 6, 7, 12, 0, 5, 14, 9,
+	},
+	{
+		10, 0, 9, 14, 6, 3, 15, 5, 1, 13, 12, 7, 11, 4, 2, 8,
+		13, 7, 0, 9, 3, 4, 6, 10, 2, 8, 5, 14, 12, 11, 15, 1,
+		13, 6, 4, 9, 8, 15, 3, 0, 11, 1, 2, 12, 5, 10, 14, 7,
+		1, 10, 13, 0, 6, 9, 8, 7, 4, 15, 14, 3, 11, 5, 2, 12,
+	},
+	{
+		7, 13, 14, 3, 0, 6, 9, 10, 1, 2, 8, 5, 11, 12, 4, 15,
+		13, 8, 11, 5, 6, 15, 0, 3, 4, 7, 2, 12, 1, 10, 14, 9,
+		10, 6, 9, 0, 12, 11, 7, 13, 15, 1, 3, 14, 5, 2, 8, 4,
+		3, 15, 0, 6, 10, 1, 13, 8, 9, 4, 5, 11, 12, 7, 2, 14,
+	},
+	{
+		2, 12, 4, 1, 7, 10, 11, 6, 8, 5, 3, 15, 13, 0, 14, 9,
+		14, 11, 2, 12, 4, 7, 13, 1, 5, 0, 15, 10, 3, 9, 8, 6,
+		4, 2, 1, 11, 10, 13, 7, 8, 15, 9, 12, 5, 6, 3, 0, 14,
+		11, 8, 12, 7, 1, 14, 2, 13, 6, 15, 0, 9, 10, 4, 5, 3,
+	},
+	{
+		12, 1, 10, 15, 9, 2, 6, 8, 0, 13, 3, 4, 14, 7, 5, 11,
+		10, 15, 4, 2, 7, 12, 9, 5, 6, 1, 13, 14, 0, 11, 3, 8,
+		9, 14, 15, 5, 2, 8, 12, 3, 7, 0, 4, 10, 1, 13, 11, 6,
+		4, 3, 2, 12, 9, 5, 15, 10, 11, 14, 1, 7, 6, 0, 8, 13,
+	},
+	{
+		4, 11, 2, 14, 15, 0, 8, 13, 3, 12, 9, 7, 5, 10, 6, 1,
+		13, 0, 11, 7, 4, 9, 1, 10, 14, 3, 5, 12, 2, 15, 8, 6,
+		1, 4, 11, 13, 12, 3, 7, 14, 10, 15, 6, 8, 0, 5, 9, 2,
+		6, 11, 13, 8, 1, 4, 10, 7, 9, 5, 0, 15, 14, 2, 3, 12,
+	},
+	{
+		13, 2, 8, 4, 6, 15, 11, 1, 10, 9, 3, 14, 5, 0, 12, 7,
+		1, 15, 13, 8, 10, 3, 7, 4, 12, 5, 6, 11, 0, 14, 9, 2,
+		7, 11, 4, 1, 9, 12, 14, 2, 0, 6, 10, 13, 15, 3, 5, 8,
+		2, 1, 14, 7, 4, 10, 8, 13, 15, 12, 9, 0, 3, 5, 6, 11,
+	},
+}
+
+var roundPermutation = [32]byte{
+	16, 7, 20, 21,
+	29, 12, 28, 17,
+	1, 15, 23, 26,
+	5, 18, 31, 10,
+	2, 8, 24, 14,
+	32, 27, 3, 9,
+	19, 13, 30, 6,
+	22, 11, 4, 25,
+}
+
+// cryptCharValue maps a character of the crypt alphabet "./0-9A-Za-z" to its
+// 6 bit value.
+func cryptCharValue(c byte) byte {
+	if c > 'Z' {
+		c -= 6
+	}
+	if c > '9' {
+		c -= 7
+	}
+
+	return (c - '.') & 0x3f
+}
+
+func cryptChar(value byte) byte {
+	c := value + '.'
+	if c > '9' {
+		c += 7
+	}
+	if c > 'Z' {
+		c += 6
+	}
+
+	return c
+}
+
+// crypt returns the 13 character hash of key with a 2 character salt, the
+// salt followed by 11 characters of the encrypted block.
+func crypt(key string, salt [2]byte) string {
+	// Every bit is a byte of its own, which keeps the permutations readable.
+	var keyBits [64]byte
+	for i := 0; i < 8 && i < len(key); i++ {
+		for j := 0; j < 7; j++ {
+			keyBits[i*8+j] = (key[i] >> (6 - j)) & 1
+		}
+	}
+
+	var c, d [28]byte
+	for i := range c {
+		c[i] = keyBits[permutedChoice1C[i]-1]
+		d[i] = keyBits[permutedChoice1D[i]-1]
+	}
+
+	var schedule [16][48]byte
+	for round := 0; round < 16; round++ {
+		for shift := 0; shift < keyShifts[round]; shift++ {
+			firstC, firstD := c[0], d[0]
+			copy(c[:], c[1:])
+			copy(d[:], d[1:])
+			c[27], d[27] = firstC, firstD
+		}
+
+		for i := 0; i < 24; i++ {
+			schedule[round][i] = c[permutedChoice2C[i]-1]
+			schedule[round][i+24] = d[permutedChoice2D[i]-28-1]
+		}
+	}
+
+	// Each set bit of the salt swaps two entries of the expansion.
+	e := expansion
+	for i, saltChar := range salt {
+		value := cryptCharValue(saltChar)
+		for j := 0; j < 6; j++ {
+			if (value>>j)&1 == 1 {
+				e[6*i+j], e[6*i+j+24] = e[6*i+j+24], e[6*i+j]
+			}
+		}
+	}
+
+	var block [66]byte
+	for iteration := 0; iteration < 25; iteration++ {
+		desEncrypt(&block, &schedule, &e)
+	}
+
+	hash := []byte{salt[0], salt[1]}
+	for i := 0; i < 11; i++ {
+		var value byte
+		for j := 0; j < 6; j++ {
+			value = value<<1 | block[6*i+j]
+		}
+		hash = append(hash, cryptChar(value))
+	}
+
+	return string(hash)
+}
+
+func desEncrypt(block *[66]byte, schedule *[16][48]byte, e *[48]byte) {
+	var lr [64]byte
+	for i := range lr {
+		lr[i] = block[initialPermutation[i]-1]
+	}
+
+	left := lr[:32]
+	right := lr[32:]
+
+	for round := 0; round < 16; round++ {
+		var expanded [48]byte
+		for i := range expanded {
+			expanded[i] = right[e[i]-1] ^ schedule[round][i]
+		}
+
+		var substituted [32]byte
+		for box := 0; box < 8; box++ {
+			bits := expanded[6*box : 6*box+6]
+			index := bits[0]<<5 | bits[5]<<4 | bits[1]<<3 | bits[2]<<2 | bits[3]<<1 | bits[4]
+			value := sBoxes[box][index]
+
+			for i := 0; i < 4; i++ {
+				substituted[4*box+i] = (value >> (3 - i)) & 1
+			}
+		}
+
+		var newRight [32]byte
+		for i := range newRight {
+			newRight[i] = left[i] ^ substituted[roundPermutation[i]-1]
+		}
+
+		copy(left, right)
+		copy(right, newRight[:])
+	}
+
+	// The halves are swapped once more before the final permutation.
+	var swapped [64]byte
+	copy(swapped[:32], right)
+	copy(swapped[32:], left)
+
+	for i := 0; i < 64; i++ {
+		block[i] = swapped[finalPermutation[i]-1]
+	}
+}
@@ -0,0 +1,88 @@
+// Package tripcode turns the passwords posters put after their name into
+// tripcodes, which let others tell posters with the same name apart without
+// any accounts.
+package tripcode
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+var ErrNoSecureSalt = errors.New("secure tripcodes need a salt")
+
+// Parse splits a name field into the name and its tripcode. "name#password"
+// gives a classic tripcode like "!dD5bdBpjbE" that is the same on every
+// board, "name##password" a secure tripcode like "!!gE9dDqeaZ5" that depends
+// on the secret salt of the server. Without a password the tripcode is empty.
+func Parse(input, secureSalt string) (string, string, error) {
+	name, password, found := strings.Cut(input, "#")
+	name = strings.TrimSpace(name)
+	if !found {
+		return name, "", nil
+	}
+
+	if secret, secure := strings.CutPrefix(password, "#"); secure {
+		if secret == "" {
+			return name, "", nil
+		}
+		if secureSalt == "" {
+			return "", "", ErrNoSecureSalt
+		}
+
+		return name, "!!" + Secure(secret, secureSalt), nil
+	}
+
+	if password == "" {
+		return name, "", nil
+	}
+
+	return name, "!" + Classic(password), nil
+}
+
+// Classic returns the 10 character tripcode of a password, as computed by
+// other imageboards: the crypt(3) hash of the password, escaped like HTML and
+// encoded as Shift JIS, with a salt taken from its second and third
+// characters.
+func Classic(password string) string {
+	escaped := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(password)
+
+	key, err := japanese.ShiftJIS.NewEncoder().String(escaped)
+	if err != nil {
+		// Characters without a Shift JIS encoding are hashed as UTF-8.
+		key = escaped
+	}
+
+	saltSource := (key + "H.")[1:3]
+
+	var salt [2]byte
+	for i := 0; i < 2; i++ {
+		c := saltSource[i]
+		switch {
+		case c < '.' || c > 'z':
+			c = '.'
+		case c >= ':' && c <= '@':
+			c += 'A' - ':'
+		case c >= '[' && c <= '`':
+			c += 'a' - '['
+		}
+		salt[i] = c
+	}
+
+	hash := crypt(key, salt)
+
+	return hash[len(hash)-10:]
+}
+
+// Secure returns the 10 character secure tripcode of a password. It can't be
+// found by trying passwords without knowing the salt.
+func Secure(password, salt string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(password))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))[:10]
+}
@@ -0,0 +1,75 @@
+package tripcode
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCrypt(t *testing.T) {
+	// Hashes computed by the crypt(3) of glibc.
+	cases := []struct {
+		key  string
+		salt string
+		want string
+	}{
+		{"password", "as", "as1ozOtJW9BFA"},
+		{"Ab1&x", "b1", "b12XT1ax/nbEM"},
+		{"12345678901", "23", "23FWBRXcNtpf."},
+	}
+
+	for _, c := range cases {
+		if got := crypt(c.key, [2]byte{c.salt[0], c.salt[1]}); got != c.want {
+			t.Errorf("crypt(%q, %q) = %s, want %s", c.key, c.salt, got, c.want)
+		}
+	}
+}
+
+func TestClassic(t *testing.T) {
+	// Tripcodes worked out with the crypt(3) of glibc.
+	cases := map[string]string{
+		"tripcode":  "3GqYIJ3Obs",
+		"frogboard": "dD5bdBpjbE",
+		"password":  "ozOtJW9BFA",
+	}
+
+	for password, want := range cases {
+		if got := Classic(password); got != want {
+			t.Errorf("Classic(%q) = %s, want %s", password, got, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	secure := Secure("secret", "salt")
+
+	cases := []struct {
+		input    string
+		name     string
+		tripcode string
+	}{
+		{"", "", ""},
+		{"frog", "frog", ""},
+		{" frog ", "frog", ""},
+		{"frog#", "frog", ""},
+		{"frog##", "frog", ""},
+		{"frog#tripcode", "frog", "!3GqYIJ3Obs"},
+		{"#tripcode", "", "!3GqYIJ3Obs"},
+		{"frog##secret", "frog", "!!" + secure},
+		{"frog###secret", "frog", "!!" + Secure("#secret", "salt")},
+	}
+
+	for _, c := range cases {
+		name, tripcode, err := Parse(c.input, "salt")
+		if err != nil || name != c.name || tripcode != c.tripcode {
+			t.Errorf("Parse(%q) = %q, %q, %v, want %q, %q", c.input, name, tripcode, err, c.name, c.tripcode)
+		}
+	}
+
+	if Secure("secret", "other salt") == secure {
+		t.Error("secure tripcodes don't depend on the salt")
+	}
+
+	if _, _, err := Parse("frog##secret", ""); !errors.Is(err, ErrNoSecureSalt) {
+		t.Errorf("Parse without a salt returned %v, want ErrNoSecureSalt", err)
+	}
+}
@@ -1,6 +1,6 @@
 /** @type {import('tailwindcss').Config} */
 module.exports = {
-  content: ["./cmd/frogboard/templates/*.tmpl.html", "./cmd/frogboard/templates/required/*.tmpl.html", "./internal/models/post.go"],
+  content: ["./cmd/frogboard/templates/*.tmpl.html", "./cmd/frogboard/templates/required/*.tmpl.html", "./pkg/markup/*.go"],
   theme: {
     extend: {},
   },